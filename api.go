@@ -0,0 +1,55 @@
+package bitstamp
+
+import "time"
+
+// Моки генерируются mockgen v1.6.0: go install github.com/golang/mock/mockgen@v1.6.0
+//go:generate mockgen -destination=bitstampmock/mocks.go -package=bitstampmock github.com/b2broker/bitstamp TradingAPI,AccountAPI,TokenProvider,FillStream
+
+// TradingAPI выставление и отмена ордеров. Реализуют PrivateClient и PaperClient
+type TradingAPI interface {
+	PlaceOrder(opts PlaceOrderRequest) (PlaceOrderResult, error)
+	CancelOrder(id string) (OrderCancelResult, error)
+	CancelOrderByClientOrderID(clientOrderID string) (OrderCancelResult, error)
+	CancelAllOrders() (CancelAllOrdersResult, error)
+	GetOpenOrders() ([]OpenOrderResult, error)
+	GetOrderStatus(id string) (OrderStatusResult, error)
+	GetOrderStatusByClientOrderID(clientOrderID string) (OrderStatusResult, error)
+}
+
+// AccountAPI балансы и история транзакций. Реализуют PrivateClient и PaperClient
+type AccountAPI interface {
+	GetBalances() (BalanceResult, error)
+	GetAccountBalances() (Balances, error)
+	GetTransactions() ([]TransactionResult, error)
+	GetPairTransactions(pair string, since time.Time) ([]TransactionResult, error)
+}
+
+// TokenProvider выдает токен для приватных каналов WebSocket'a. Если он реализует и
+// GetPairTransactions (как PrivateClient), Websocket восстанавливает трейды, пропущенные во время реконнекта
+type TokenProvider interface {
+	GenerateWSToken() (*GenerateWSTokenResult, error)
+}
+
+// FillStream источник трейдов. Реализуют Websocket и PaperClient
+type FillStream interface {
+	Fills() <-chan Fill
+	NewFillSubscription(buffer int, policy OverflowPolicy) *FillSubscription
+	OnFill(handler func(Fill)) *FillSubscription
+	Stop()
+}
+
+// transactionsSource источник транзакций для восстановления пропущенных трейдов
+type transactionsSource interface {
+	GetPairTransactions(pair string, since time.Time) ([]TransactionResult, error)
+}
+
+var (
+	_ TradingAPI    = (*PrivateClient)(nil)
+	_ AccountAPI    = (*PrivateClient)(nil)
+	_ TokenProvider = (*PrivateClient)(nil)
+	_ FillStream    = (*Websocket)(nil)
+
+	_ TradingAPI = (*PaperClient)(nil)
+	_ AccountAPI = (*PaperClient)(nil)
+	_ FillStream = (*PaperClient)(nil)
+)
@@ -0,0 +1,340 @@
+package bitstamp
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrBalanceTrackerStopped Run завершился после Stop()
+var ErrBalanceTrackerStopped = errors.New("balance tracker stopped")
+
+// reservation резерв открытого ордера
+type reservation struct {
+	currency string
+	amount   float64
+}
+
+// BalanceTracker ведет балансы локально: трейды HandleFill меняют общий баланс, события
+// HandleOrderEvent резервируют и освобождают средства под ордера. Sync и Run сверяют балансы с REST
+//
+//	tracker := bitstamp.NewBalanceTracker(client)
+//	ws := bitstamp.NewWSClientWithOptions(symbols, bitstamp.WithOrderEvents(tracker.HandleOrderEvent))
+//	ws.OnFill(tracker.HandleFill)
+//	go tracker.Run(time.Minute)
+type BalanceTracker struct {
+	api     AccountAPI
+	logger  Logger
+	feeRate float64
+
+	mu       sync.Mutex
+	balances Balances
+	orders   map[int64]reservation
+	trades   *recentTrades
+	syncedAt time.Time
+
+	handlersMu sync.Mutex
+	handlers   []func(currency string, balance Balance)
+
+	stopMu sync.Mutex
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// BalanceTrackerOption настройка BalanceTracker
+type BalanceTrackerOption func(*BalanceTracker)
+
+// WithBalanceTrackerLogger задает логгер
+func WithBalanceTrackerLogger(logger Logger) BalanceTrackerOption {
+	return func(bt *BalanceTracker) {
+		bt.logger = logger
+	}
+}
+
+// WithBalanceTrackerFeeRate задает комиссию, которую Bitstamp резервирует сверх объема ордера на покупку
+func WithBalanceTrackerFeeRate(rate float64) BalanceTrackerOption {
+	return func(bt *BalanceTracker) {
+		bt.feeRate = rate
+	}
+}
+
+// NewBalanceTracker создает трекер балансов поверх PrivateClient или PaperClient.
+// Балансы пустые до первого Sync
+func NewBalanceTracker(api AccountAPI, opts ...BalanceTrackerOption) *BalanceTracker {
+	bt := &BalanceTracker{
+		api:      api,
+		logger:   NewLogrusLogger(logrus.WithField("provider", "bitstamp").WithField("module", "balances")),
+		balances: make(Balances),
+		orders:   make(map[int64]reservation),
+		trades:   newRecentTrades(recentTradesLimit),
+		stop:     make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(bt)
+	}
+
+	bt.logger = newRedactingLogger(bt.logger)
+
+	return bt
+}
+
+// OnChange добавляет обработчик изменения баланса валюты
+func (bt *BalanceTracker) OnChange(handler func(currency string, balance Balance)) {
+	bt.handlersMu.Lock()
+	defer bt.handlersMu.Unlock()
+
+	bt.handlers = append(bt.handlers, handler)
+}
+
+// Balance возвращает баланс валюты
+func (bt *BalanceTracker) Balance(currency string) Balance {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	return bt.balances[currency]
+}
+
+// Balances возвращает копию всех балансов
+func (bt *BalanceTracker) Balances() Balances {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	result := make(Balances, len(bt.balances))
+	for currency, balance := range bt.balances {
+		result[currency] = balance
+	}
+
+	return result
+}
+
+// SyncedAt время последней успешной сверки с REST
+func (bt *BalanceTracker) SyncedAt() time.Time {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	return bt.syncedAt
+}
+
+// Required валюта и сумма, которые Bitstamp зарезервирует под ордер. Для рыночного ордера
+// на покупку price оценка цены исполнения, для остальных ордеров используется opts.Price
+func (bt *BalanceTracker) Required(opts PlaceOrderRequest, price float64) (string, float64) {
+	base, quote := SplitSymbol(opts.Symbol)
+
+	if opts.Side == Sell {
+		return base, opts.Amount
+	}
+
+	if opts.Type == Limit {
+		price = opts.Price
+	}
+
+	return quote, price * opts.Amount * (1 + bt.feeRate)
+}
+
+// CanFund проверяет, хватает ли доступного баланса на ордер. price как в Required
+func (bt *BalanceTracker) CanFund(opts PlaceOrderRequest, price float64) bool {
+	currency, need := bt.Required(opts, price)
+
+	return bt.Balance(currency).Available+paperEpsilon >= need
+}
+
+// Sync заменяет балансы ответом REST. Резерв валюты не меньше суммы резервов известных ордеров:
+// ордер, созданный после снимка REST, остается зарезервированным до order_deleted.
+// Общий баланс берется из REST: трейд, пришедший во время запроса, учтется следующим Sync
+func (bt *BalanceTracker) Sync() error {
+	balances, err := bt.api.GetAccountBalances()
+	if err != nil {
+		return err
+	}
+
+	if balances == nil {
+		balances = make(Balances)
+	}
+
+	bt.mu.Lock()
+
+	reserved := make(map[string]float64)
+	for _, r := range bt.orders {
+		reserved[r.currency] += r.amount
+	}
+
+	for currency, amount := range reserved {
+		balance := balances[currency]
+		if amount > balance.Reserved {
+			balance.Reserved = amount
+			balance.Available = balance.Total - balance.Reserved
+			balances[currency] = balance
+		}
+	}
+
+	var changed []string
+
+	for currency, balance := range balances {
+		if bt.balances[currency] != balance {
+			changed = append(changed, currency)
+		}
+	}
+
+	for currency := range bt.balances {
+		if _, ok := balances[currency]; !ok {
+			changed = append(changed, currency)
+		}
+	}
+
+	bt.balances = balances
+	bt.syncedAt = time.Now()
+	bt.mu.Unlock()
+
+	bt.notify(changed...)
+
+	return nil
+}
+
+// Run сверяет балансы с REST каждые interval до вызова Stop(). Ошибки логируются, цикл продолжается
+func (bt *BalanceTracker) Run(interval time.Duration) error {
+	bt.stopMu.Lock()
+	select {
+	case <-bt.stop:
+		bt.stopMu.Unlock()
+		return ErrBalanceTrackerStopped
+	default:
+		bt.wg.Add(1)
+		defer bt.wg.Done()
+	}
+	bt.stopMu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := bt.Sync(); err != nil {
+			bt.logger.WithError(err).Error("could not sync balances")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-bt.stop:
+			return ErrBalanceTrackerStopped
+		}
+	}
+}
+
+// Stop останавливает Run
+func (bt *BalanceTracker) Stop() {
+	bt.stopMu.Lock()
+	select {
+	case <-bt.stop:
+	default:
+		close(bt.stop)
+	}
+	bt.stopMu.Unlock()
+	bt.wg.Wait()
+}
+
+// HandleFill применяет трейд к общему балансу. Подходит для FillStream.OnFill.
+// Резерв ордера уменьшается событием order_changed
+func (bt *BalanceTracker) HandleFill(fill Fill) {
+	base, quote := SplitSymbol(fill.Symbol)
+	if quote == "" {
+		bt.logger.WithField("symbol", fill.Symbol).Warn("fill of unknown symbol")
+		return
+	}
+
+	bt.mu.Lock()
+
+	if !bt.trades.remember(fill.TradeID) {
+		bt.mu.Unlock()
+		return
+	}
+
+	cost := fill.Price * fill.Size
+
+	if fill.Side == string(Sell) {
+		bt.add(base, -fill.Size, 0)
+		bt.add(quote, cost, 0)
+	} else {
+		bt.add(base, fill.Size, 0)
+		bt.add(quote, -cost, 0)
+	}
+
+	feeCurrency := fill.FeeCurrency
+	if feeCurrency == "" {
+		feeCurrency = quote
+	}
+
+	bt.add(feeCurrency, -fill.Fee, 0)
+	bt.mu.Unlock()
+
+	bt.notify(base, quote)
+}
+
+// HandleOrderEvent резервирует средства под неисполненный остаток ордера и освобождает их
+// при удалении ордера. Подходит для WithOrderEvents
+func (bt *BalanceTracker) HandleOrderEvent(event OrderEvent) {
+	base, quote := SplitSymbol(event.Symbol)
+	if quote == "" {
+		bt.logger.WithField("symbol", event.Symbol).Warn("order event of unknown symbol")
+		return
+	}
+
+	bt.mu.Lock()
+
+	var changed []string
+
+	if prev, ok := bt.orders[event.ID]; ok {
+		bt.add(prev.currency, 0, -prev.amount)
+		delete(bt.orders, event.ID)
+		changed = append(changed, prev.currency)
+	}
+
+	if event.Type != OrderEventDeleted {
+		next := reservation{currency: base, amount: event.Amount}
+		if event.Side == Buy {
+			next = reservation{currency: quote, amount: event.Price * event.Amount * (1 + bt.feeRate)}
+		}
+
+		bt.add(next.currency, 0, next.amount)
+		bt.orders[event.ID] = next
+		changed = append(changed, next.currency)
+	}
+
+	bt.mu.Unlock()
+
+	bt.notify(changed...)
+}
+
+// add меняет общий и зарезервированный баланс. Вызывается под bt.mu
+func (bt *BalanceTracker) add(currency string, total float64, reserved float64) {
+	balance := bt.balances[currency]
+	balance.Total += total
+	balance.Reserved += reserved
+
+	// резерв ордера, созданного до Sync, мог уже не войти в ответ REST
+	if balance.Reserved < paperEpsilon {
+		balance.Reserved = 0
+	}
+
+	balance.Available = balance.Total - balance.Reserved
+	bt.balances[currency] = balance
+}
+
+func (bt *BalanceTracker) notify(currencies ...string) {
+	bt.handlersMu.Lock()
+	handlers := bt.handlers
+	bt.handlersMu.Unlock()
+
+	if len(handlers) == 0 {
+		return
+	}
+
+	for _, currency := range currencies {
+		balance := bt.Balance(currency)
+
+		for _, handler := range handlers {
+			handler(currency, balance)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package bitstamp_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/b2broker/bitstamp"
+	"github.com/b2broker/bitstamp/bitstamptest"
+)
+
+func orderEvent(eventType bitstamp.OrderEventType, id int64, side bitstamp.OrderSide, price float64, amount float64) bitstamp.OrderEvent {
+	return bitstamp.OrderEvent{Type: eventType, ID: id, Symbol: "btcusd", Side: side, Price: price, Amount: amount}
+}
+
+func TestBalanceTrackerSyncReserve(t *testing.T) {
+	tests := []struct {
+		name    string
+		feeRate float64
+		// before вызывается до Sync, after после него
+		before func(t *testing.T, srv *bitstamptest.Server, client *bitstamp.PrivateClient, bt *bitstamp.BalanceTracker)
+		after  func(t *testing.T, srv *bitstamptest.Server, client *bitstamp.PrivateClient, bt *bitstamp.BalanceTracker)
+		want   map[string]bitstamp.Balance
+	}{
+		{
+			name: "no orders",
+			want: map[string]bitstamp.Balance{
+				"usd": {Total: 1000, Available: 1000},
+				"btc": {Total: 1, Available: 1},
+			},
+		},
+		{
+			name: "order in snapshot",
+			before: func(t *testing.T, srv *bitstamptest.Server, client *bitstamp.PrivateClient, bt *bitstamp.BalanceTracker) {
+				result, err := client.PlaceOrder(limitOrder(bitstamp.Buy, 20000, 0.01))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				bt.HandleOrderEvent(orderEvent(bitstamp.OrderEventCreated, result.ID, bitstamp.Buy, 20000, 0.01))
+			},
+			want: map[string]bitstamp.Balance{
+				"usd": {Total: 1000, Available: 800, Reserved: 200},
+				"btc": {Total: 1, Available: 1},
+			},
+		},
+		{
+			name:    "order in snapshot with fee",
+			feeRate: 0.005,
+			before: func(t *testing.T, srv *bitstamptest.Server, client *bitstamp.PrivateClient, bt *bitstamp.BalanceTracker) {
+				result, err := client.PlaceOrder(limitOrder(bitstamp.Buy, 20000, 0.01))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				bt.HandleOrderEvent(orderEvent(bitstamp.OrderEventCreated, result.ID, bitstamp.Buy, 20000, 0.01))
+			},
+			want: map[string]bitstamp.Balance{
+				"usd": {Total: 1000, Available: 799, Reserved: 201},
+				"btc": {Total: 1, Available: 1},
+			},
+		},
+		{
+			name: "order created after snapshot",
+			before: func(t *testing.T, srv *bitstamptest.Server, client *bitstamp.PrivateClient, bt *bitstamp.BalanceTracker) {
+				// событие пришло, а REST снимок ордер еще не видит
+				bt.HandleOrderEvent(orderEvent(bitstamp.OrderEventCreated, 999, bitstamp.Sell, 21000, 0.3))
+			},
+			want: map[string]bitstamp.Balance{
+				"usd": {Total: 1000, Available: 1000},
+				"btc": {Total: 1, Available: 0.7, Reserved: 0.3},
+			},
+		},
+		{
+			name: "reserve released after sync",
+			before: func(t *testing.T, srv *bitstamptest.Server, client *bitstamp.PrivateClient, bt *bitstamp.BalanceTracker) {
+				bt.HandleOrderEvent(orderEvent(bitstamp.OrderEventCreated, 999, bitstamp.Sell, 21000, 0.3))
+			},
+			after: func(t *testing.T, srv *bitstamptest.Server, client *bitstamp.PrivateClient, bt *bitstamp.BalanceTracker) {
+				bt.HandleOrderEvent(orderEvent(bitstamp.OrderEventDeleted, 999, bitstamp.Sell, 21000, 0.3))
+			},
+			want: map[string]bitstamp.Balance{
+				"usd": {Total: 1000, Available: 1000},
+				"btc": {Total: 1, Available: 1},
+			},
+		},
+		{
+			name: "partially filled order",
+			before: func(t *testing.T, srv *bitstamptest.Server, client *bitstamp.PrivateClient, bt *bitstamp.BalanceTracker) {
+				result, err := client.PlaceOrder(limitOrder(bitstamp.Buy, 20000, 0.01))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				bt.HandleOrderEvent(orderEvent(bitstamp.OrderEventCreated, result.ID, bitstamp.Buy, 20000, 0.01))
+				srv.Trade("btcusd", bitstamp.Sell, 20000, 0.004)
+				bt.HandleOrderEvent(orderEvent(bitstamp.OrderEventChanged, result.ID, bitstamp.Buy, 20000, 0.006))
+			},
+			want: map[string]bitstamp.Balance{
+				"usd": {Total: 920, Available: 800, Reserved: 120},
+				"btc": {Total: 1.004, Available: 1.004},
+			},
+		},
+		{
+			name: "duplicated fill after sync",
+			after: func(t *testing.T, srv *bitstamptest.Server, client *bitstamp.PrivateClient, bt *bitstamp.BalanceTracker) {
+				fill := bitstamp.Fill{TradeID: 7, Symbol: "btcusd", Side: string(bitstamp.Sell), Price: 20000, Size: 0.01, Fee: 1, FeeCurrency: "usd"}
+
+				bt.HandleFill(fill)
+				bt.HandleFill(fill)
+			},
+			want: map[string]bitstamp.Balance{
+				"usd": {Total: 1199, Available: 1199},
+				"btc": {Total: 0.99, Available: 0.99},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			srv := bitstamptest.NewServer("key", "secret")
+			defer srv.Close()
+
+			srv.SetBalance("usd", 1000)
+			srv.SetBalance("btc", 1)
+			srv.SetFeeRate(tt.feeRate)
+
+			client := srv.NewClient()
+			bt := bitstamp.NewBalanceTracker(client, bitstamp.WithBalanceTrackerFeeRate(tt.feeRate))
+
+			if tt.before != nil {
+				tt.before(t, srv, client, bt)
+			}
+
+			if err := bt.Sync(); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.after != nil {
+				tt.after(t, srv, client, bt)
+			}
+
+			for currency, want := range tt.want {
+				got := bt.Balance(currency)
+				got.WithdrawalFee = 0
+
+				if math.Abs(got.Total-want.Total) > 1e-9 ||
+					math.Abs(got.Available-want.Available) > 1e-9 ||
+					math.Abs(got.Reserved-want.Reserved) > 1e-9 {
+					t.Fatalf("%s balance = %+v, want %+v", currency, got, want)
+				}
+			}
+		})
+	}
+}
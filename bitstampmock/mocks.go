@@ -0,0 +1,339 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/b2broker/bitstamp (interfaces: TradingAPI,AccountAPI,TokenProvider,FillStream)
+
+// Package bitstampmock is a generated GoMock package.
+package bitstampmock
+
+import (
+	reflect "reflect"
+	time "time"
+
+	bitstamp "github.com/b2broker/bitstamp"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockTradingAPI is a mock of TradingAPI interface.
+type MockTradingAPI struct {
+	ctrl     *gomock.Controller
+	recorder *MockTradingAPIMockRecorder
+}
+
+// MockTradingAPIMockRecorder is the mock recorder for MockTradingAPI.
+type MockTradingAPIMockRecorder struct {
+	mock *MockTradingAPI
+}
+
+// NewMockTradingAPI creates a new mock instance.
+func NewMockTradingAPI(ctrl *gomock.Controller) *MockTradingAPI {
+	mock := &MockTradingAPI{ctrl: ctrl}
+	mock.recorder = &MockTradingAPIMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTradingAPI) EXPECT() *MockTradingAPIMockRecorder {
+	return m.recorder
+}
+
+// CancelAllOrders mocks base method.
+func (m *MockTradingAPI) CancelAllOrders() (bitstamp.CancelAllOrdersResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelAllOrders")
+	ret0, _ := ret[0].(bitstamp.CancelAllOrdersResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CancelAllOrders indicates an expected call of CancelAllOrders.
+func (mr *MockTradingAPIMockRecorder) CancelAllOrders() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelAllOrders", reflect.TypeOf((*MockTradingAPI)(nil).CancelAllOrders))
+}
+
+// CancelOrder mocks base method.
+func (m *MockTradingAPI) CancelOrder(arg0 string) (bitstamp.OrderCancelResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelOrder", arg0)
+	ret0, _ := ret[0].(bitstamp.OrderCancelResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CancelOrder indicates an expected call of CancelOrder.
+func (mr *MockTradingAPIMockRecorder) CancelOrder(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelOrder", reflect.TypeOf((*MockTradingAPI)(nil).CancelOrder), arg0)
+}
+
+// CancelOrderByClientOrderID mocks base method.
+func (m *MockTradingAPI) CancelOrderByClientOrderID(arg0 string) (bitstamp.OrderCancelResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelOrderByClientOrderID", arg0)
+	ret0, _ := ret[0].(bitstamp.OrderCancelResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CancelOrderByClientOrderID indicates an expected call of CancelOrderByClientOrderID.
+func (mr *MockTradingAPIMockRecorder) CancelOrderByClientOrderID(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelOrderByClientOrderID", reflect.TypeOf((*MockTradingAPI)(nil).CancelOrderByClientOrderID), arg0)
+}
+
+// GetOpenOrders mocks base method.
+func (m *MockTradingAPI) GetOpenOrders() ([]bitstamp.OpenOrderResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOpenOrders")
+	ret0, _ := ret[0].([]bitstamp.OpenOrderResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOpenOrders indicates an expected call of GetOpenOrders.
+func (mr *MockTradingAPIMockRecorder) GetOpenOrders() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOpenOrders", reflect.TypeOf((*MockTradingAPI)(nil).GetOpenOrders))
+}
+
+// GetOrderStatus mocks base method.
+func (m *MockTradingAPI) GetOrderStatus(arg0 string) (bitstamp.OrderStatusResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrderStatus", arg0)
+	ret0, _ := ret[0].(bitstamp.OrderStatusResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrderStatus indicates an expected call of GetOrderStatus.
+func (mr *MockTradingAPIMockRecorder) GetOrderStatus(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderStatus", reflect.TypeOf((*MockTradingAPI)(nil).GetOrderStatus), arg0)
+}
+
+// GetOrderStatusByClientOrderID mocks base method.
+func (m *MockTradingAPI) GetOrderStatusByClientOrderID(arg0 string) (bitstamp.OrderStatusResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrderStatusByClientOrderID", arg0)
+	ret0, _ := ret[0].(bitstamp.OrderStatusResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrderStatusByClientOrderID indicates an expected call of GetOrderStatusByClientOrderID.
+func (mr *MockTradingAPIMockRecorder) GetOrderStatusByClientOrderID(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderStatusByClientOrderID", reflect.TypeOf((*MockTradingAPI)(nil).GetOrderStatusByClientOrderID), arg0)
+}
+
+// PlaceOrder mocks base method.
+func (m *MockTradingAPI) PlaceOrder(arg0 bitstamp.PlaceOrderRequest) (bitstamp.PlaceOrderResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PlaceOrder", arg0)
+	ret0, _ := ret[0].(bitstamp.PlaceOrderResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PlaceOrder indicates an expected call of PlaceOrder.
+func (mr *MockTradingAPIMockRecorder) PlaceOrder(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PlaceOrder", reflect.TypeOf((*MockTradingAPI)(nil).PlaceOrder), arg0)
+}
+
+// MockAccountAPI is a mock of AccountAPI interface.
+type MockAccountAPI struct {
+	ctrl     *gomock.Controller
+	recorder *MockAccountAPIMockRecorder
+}
+
+// MockAccountAPIMockRecorder is the mock recorder for MockAccountAPI.
+type MockAccountAPIMockRecorder struct {
+	mock *MockAccountAPI
+}
+
+// NewMockAccountAPI creates a new mock instance.
+func NewMockAccountAPI(ctrl *gomock.Controller) *MockAccountAPI {
+	mock := &MockAccountAPI{ctrl: ctrl}
+	mock.recorder = &MockAccountAPIMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAccountAPI) EXPECT() *MockAccountAPIMockRecorder {
+	return m.recorder
+}
+
+// GetAccountBalances mocks base method.
+func (m *MockAccountAPI) GetAccountBalances() (bitstamp.Balances, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAccountBalances")
+	ret0, _ := ret[0].(bitstamp.Balances)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAccountBalances indicates an expected call of GetAccountBalances.
+func (mr *MockAccountAPIMockRecorder) GetAccountBalances() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAccountBalances", reflect.TypeOf((*MockAccountAPI)(nil).GetAccountBalances))
+}
+
+// GetBalances mocks base method.
+func (m *MockAccountAPI) GetBalances() (bitstamp.BalanceResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBalances")
+	ret0, _ := ret[0].(bitstamp.BalanceResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBalances indicates an expected call of GetBalances.
+func (mr *MockAccountAPIMockRecorder) GetBalances() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBalances", reflect.TypeOf((*MockAccountAPI)(nil).GetBalances))
+}
+
+// GetPairTransactions mocks base method.
+func (m *MockAccountAPI) GetPairTransactions(arg0 string, arg1 time.Time) ([]bitstamp.TransactionResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPairTransactions", arg0, arg1)
+	ret0, _ := ret[0].([]bitstamp.TransactionResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPairTransactions indicates an expected call of GetPairTransactions.
+func (mr *MockAccountAPIMockRecorder) GetPairTransactions(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPairTransactions", reflect.TypeOf((*MockAccountAPI)(nil).GetPairTransactions), arg0, arg1)
+}
+
+// GetTransactions mocks base method.
+func (m *MockAccountAPI) GetTransactions() ([]bitstamp.TransactionResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTransactions")
+	ret0, _ := ret[0].([]bitstamp.TransactionResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTransactions indicates an expected call of GetTransactions.
+func (mr *MockAccountAPIMockRecorder) GetTransactions() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTransactions", reflect.TypeOf((*MockAccountAPI)(nil).GetTransactions))
+}
+
+// MockTokenProvider is a mock of TokenProvider interface.
+type MockTokenProvider struct {
+	ctrl     *gomock.Controller
+	recorder *MockTokenProviderMockRecorder
+}
+
+// MockTokenProviderMockRecorder is the mock recorder for MockTokenProvider.
+type MockTokenProviderMockRecorder struct {
+	mock *MockTokenProvider
+}
+
+// NewMockTokenProvider creates a new mock instance.
+func NewMockTokenProvider(ctrl *gomock.Controller) *MockTokenProvider {
+	mock := &MockTokenProvider{ctrl: ctrl}
+	mock.recorder = &MockTokenProviderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTokenProvider) EXPECT() *MockTokenProviderMockRecorder {
+	return m.recorder
+}
+
+// GenerateWSToken mocks base method.
+func (m *MockTokenProvider) GenerateWSToken() (*bitstamp.GenerateWSTokenResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GenerateWSToken")
+	ret0, _ := ret[0].(*bitstamp.GenerateWSTokenResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GenerateWSToken indicates an expected call of GenerateWSToken.
+func (mr *MockTokenProviderMockRecorder) GenerateWSToken() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GenerateWSToken", reflect.TypeOf((*MockTokenProvider)(nil).GenerateWSToken))
+}
+
+// MockFillStream is a mock of FillStream interface.
+type MockFillStream struct {
+	ctrl     *gomock.Controller
+	recorder *MockFillStreamMockRecorder
+}
+
+// MockFillStreamMockRecorder is the mock recorder for MockFillStream.
+type MockFillStreamMockRecorder struct {
+	mock *MockFillStream
+}
+
+// NewMockFillStream creates a new mock instance.
+func NewMockFillStream(ctrl *gomock.Controller) *MockFillStream {
+	mock := &MockFillStream{ctrl: ctrl}
+	mock.recorder = &MockFillStreamMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockFillStream) EXPECT() *MockFillStreamMockRecorder {
+	return m.recorder
+}
+
+// Fills mocks base method.
+func (m *MockFillStream) Fills() <-chan bitstamp.Fill {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Fills")
+	ret0, _ := ret[0].(<-chan bitstamp.Fill)
+	return ret0
+}
+
+// Fills indicates an expected call of Fills.
+func (mr *MockFillStreamMockRecorder) Fills() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Fills", reflect.TypeOf((*MockFillStream)(nil).Fills))
+}
+
+// NewFillSubscription mocks base method.
+func (m *MockFillStream) NewFillSubscription(arg0 int, arg1 bitstamp.OverflowPolicy) *bitstamp.FillSubscription {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NewFillSubscription", arg0, arg1)
+	ret0, _ := ret[0].(*bitstamp.FillSubscription)
+	return ret0
+}
+
+// NewFillSubscription indicates an expected call of NewFillSubscription.
+func (mr *MockFillStreamMockRecorder) NewFillSubscription(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewFillSubscription", reflect.TypeOf((*MockFillStream)(nil).NewFillSubscription), arg0, arg1)
+}
+
+// OnFill mocks base method.
+func (m *MockFillStream) OnFill(arg0 func(bitstamp.Fill)) *bitstamp.FillSubscription {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "OnFill", arg0)
+	ret0, _ := ret[0].(*bitstamp.FillSubscription)
+	return ret0
+}
+
+// OnFill indicates an expected call of OnFill.
+func (mr *MockFillStreamMockRecorder) OnFill(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnFill", reflect.TypeOf((*MockFillStream)(nil).OnFill), arg0)
+}
+
+// Stop mocks base method.
+func (m *MockFillStream) Stop() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Stop")
+}
+
+// Stop indicates an expected call of Stop.
+func (mr *MockFillStreamMockRecorder) Stop() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stop", reflect.TypeOf((*MockFillStream)(nil).Stop))
+}
@@ -0,0 +1,11 @@
+module github.com/b2broker/bitstamp/bitstampotel
+
+go 1.15
+
+require (
+	github.com/b2broker/bitstamp v0.0.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+)
+
+replace github.com/b2broker/bitstamp => ../
@@ -0,0 +1,128 @@
+// Package bitstampotel трассировка OpenTelemetry для bitstamp.PrivateClient и bitstamp.Websocket
+package bitstampotel
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/b2broker/bitstamp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/b2broker/bitstamp"
+
+// ordersLimit сколько span'ов ордеров хранится для связи с трейдами
+const ordersLimit = 4096
+
+// Tracer реализация bitstamp.Tracer поверх OpenTelemetry
+type Tracer struct {
+	tracer trace.Tracer
+
+	mu     sync.Mutex
+	orders map[string]trace.SpanContext
+	order  []string
+}
+
+var _ bitstamp.Tracer = (*Tracer)(nil)
+
+// New создает Tracer с провайдером tp
+func New(tp trace.TracerProvider) *Tracer {
+	return &Tracer{
+		tracer: tp.Tracer(instrumentationName),
+		orders: make(map[string]trace.SpanContext),
+	}
+}
+
+func (t *Tracer) StartRequest(endpoint string, symbol string, clientOrderID string) bitstamp.Span {
+	attrs := []attribute.KeyValue{
+		attribute.String("bitstamp.endpoint", endpoint),
+	}
+
+	if symbol != "" {
+		attrs = append(attrs, attribute.String("bitstamp.symbol", symbol))
+	}
+
+	if clientOrderID != "" {
+		attrs = append(attrs, attribute.String("bitstamp.client_order_id", clientOrderID))
+	}
+
+	_, span := t.tracer.Start(context.Background(), "bitstamp "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+
+	if clientOrderID != "" {
+		t.remember(clientOrderID, span.SpanContext())
+	}
+
+	return otelSpan{span: span}
+}
+
+func (t *Tracer) StartFill(fill bitstamp.Fill) bitstamp.Span {
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("bitstamp.symbol", fill.Symbol),
+			attribute.String("bitstamp.client_order_id", fill.ClientOrderID),
+			attribute.String("bitstamp.order_id", strconv.FormatInt(fill.OrderID, 10)),
+			attribute.String("bitstamp.trade_id", strconv.FormatInt(fill.TradeID, 10)),
+			attribute.String("bitstamp.side", fill.Side),
+			attribute.Bool("bitstamp.recovered", fill.Recovered),
+		),
+	}
+
+	if sc, ok := t.lookup(fill.ClientOrderID); ok {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: sc}))
+	}
+
+	_, span := t.tracer.Start(context.Background(), "bitstamp fill", opts...)
+
+	return otelSpan{span: span}
+}
+
+func (t *Tracer) remember(clientOrderID string, sc trace.SpanContext) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.orders[clientOrderID]; !ok {
+		t.order = append(t.order, clientOrderID)
+	}
+
+	t.orders[clientOrderID] = sc
+
+	if len(t.order) > ordersLimit {
+		delete(t.orders, t.order[0])
+		t.order = t.order[1:]
+	}
+}
+
+func (t *Tracer) lookup(clientOrderID string) (trace.SpanContext, bool) {
+	if clientOrderID == "" {
+		return trace.SpanContext{}, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sc, ok := t.orders[clientOrderID]
+
+	return sc, ok
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) End(status string, err error) {
+	s.span.SetAttributes(attribute.String("bitstamp.status", status))
+
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+
+	s.span.End()
+}
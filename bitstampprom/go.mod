@@ -0,0 +1,10 @@
+module github.com/b2broker/bitstamp/bitstampprom
+
+go 1.15
+
+require (
+	github.com/b2broker/bitstamp v0.0.0
+	github.com/prometheus/client_golang v1.12.2
+)
+
+replace github.com/b2broker/bitstamp => ../
@@ -0,0 +1,147 @@
+// Package bitstampprom метрики Prometheus для bitstamp.PrivateClient и bitstamp.Websocket
+package bitstampprom
+
+import (
+	"time"
+
+	"github.com/b2broker/bitstamp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics реализация bitstamp.Metrics поверх Prometheus
+type Metrics struct {
+	requestDuration *prometheus.HistogramVec
+	apiErrors       *prometheus.CounterVec
+	rateLimited     *prometheus.CounterVec
+	reconnects      prometheus.Counter
+	messages        *prometheus.CounterVec
+	fillsEmitted    *prometheus.CounterVec
+	fillsDropped    prometheus.Counter
+	fillsDepth      prometheus.Gauge
+	pingRTT         prometheus.Histogram
+}
+
+var _ bitstamp.Metrics = (*Metrics)(nil)
+
+// New создает метрики и регистрирует их в reg
+func New(reg prometheus.Registerer, namespace string) (*Metrics, error) {
+	m := &Metrics{
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "bitstamp",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of private REST requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"endpoint", "status"}),
+		apiErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "bitstamp",
+			Name:      "api_errors_total",
+			Help:      "Errors returned by Bitstamp API by error code.",
+		}, []string{"endpoint", "code"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "bitstamp",
+			Name:      "rate_limited_total",
+			Help:      "Requests rejected by rate limit.",
+		}, []string{"endpoint"}),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "bitstamp",
+			Name:      "ws_reconnects_total",
+			Help:      "Websocket reconnects.",
+		}),
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "bitstamp",
+			Name:      "ws_messages_total",
+			Help:      "Websocket messages by event type.",
+		}, []string{"event"}),
+		fillsEmitted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "bitstamp",
+			Name:      "fills_emitted_total",
+			Help:      "Fills delivered to Fills().",
+		}, []string{"symbol"}),
+		fillsDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "bitstamp",
+			Name:      "fills_dropped_total",
+			Help:      "Fills dropped by overflow policy.",
+		}),
+		fillsDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "bitstamp",
+			Name:      "fills_depth",
+			Help:      "Fills waiting to be consumed.",
+		}),
+		pingRTT: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "bitstamp",
+			Name:      "ws_ping_rtt_seconds",
+			Help:      "Websocket ping/pong round-trip time.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+	}
+
+	collectors := []prometheus.Collector{
+		m.requestDuration,
+		m.apiErrors,
+		m.rateLimited,
+		m.reconnects,
+		m.messages,
+		m.fillsEmitted,
+		m.fillsDropped,
+		m.fillsDepth,
+		m.pingRTT,
+	}
+
+	for _, collector := range collectors {
+		if err := reg.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func (m *Metrics) ObserveRequest(endpoint string, duration time.Duration, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+
+	m.requestDuration.WithLabelValues(endpoint, status).Observe(duration.Seconds())
+}
+
+func (m *Metrics) IncAPIError(endpoint string, code string) {
+	m.apiErrors.WithLabelValues(endpoint, code).Inc()
+}
+
+func (m *Metrics) IncRateLimited(endpoint string) {
+	m.rateLimited.WithLabelValues(endpoint).Inc()
+}
+
+func (m *Metrics) IncReconnect() {
+	m.reconnects.Inc()
+}
+
+func (m *Metrics) IncMessage(event string) {
+	m.messages.WithLabelValues(event).Inc()
+}
+
+func (m *Metrics) IncFillsEmitted(symbol string) {
+	m.fillsEmitted.WithLabelValues(symbol).Inc()
+}
+
+func (m *Metrics) AddFillsDropped(count uint64) {
+	m.fillsDropped.Add(float64(count))
+}
+
+func (m *Metrics) SetFillsDepth(depth int) {
+	m.fillsDepth.Set(float64(depth))
+}
+
+func (m *Metrics) ObservePingRTT(rtt time.Duration) {
+	m.pingRTT.Observe(rtt.Seconds())
+}
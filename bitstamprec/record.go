@@ -0,0 +1,288 @@
+// Package bitstamprec запись и воспроизведение REST и WebSocket трафика Bitstamp.
+// Recorder пишет обмены privateRequest и сырые кадры WebSocket'a в файл JSON Lines с версией формата,
+// секреты вырезаются. Replayer детерминированно воспроизводит запись через PrivateClient и Websocket.
+package bitstamprec
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/b2broker/bitstamp"
+)
+
+// Version версия формата записи
+const Version = 1
+
+const redacted = "[REDACTED]"
+
+const (
+	kindREST      = "rest"
+	kindWSConnect = "ws_connect"
+	kindWSFrame   = "ws_frame"
+)
+
+// sensitiveKeys поля запросов и ответов, значения которых не записываются
+var sensitiveKeys = []string{"secret", "signature", "token", "auth", "api_key", "apikey", "password"}
+
+type header struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type entry struct {
+	Kind string    `json:"kind"`
+	Seq  int64     `json:"seq"`
+	At   time.Time `json:"at"`
+
+	// REST
+	Path       string            `json:"path,omitempty"`
+	Params     map[string]string `json:"params,omitempty"`
+	StatusCode int               `json:"status_code,omitempty"`
+	Date       string            `json:"date,omitempty"`
+	Body       string            `json:"body,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	// ErrorKind api, rate_limited, transport
+	ErrorKind string `json:"error_kind,omitempty"`
+
+	// WebSocket
+	URL   string `json:"url,omitempty"`
+	Frame string `json:"frame,omitempty"`
+}
+
+// Recorder записывает трафик. Реализует bitstamp.FrameObserver
+type Recorder struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	closer  io.Closer
+	seq     int64
+	secrets []string
+	err     error
+}
+
+var _ bitstamp.FrameObserver = (*Recorder)(nil)
+
+// NewRecorder пишет запись в w. secrets - значения, которые нужно вырезать (API ключ и т.п.)
+func NewRecorder(w io.Writer, secrets ...string) (*Recorder, error) {
+	r := &Recorder{
+		enc: json.NewEncoder(w),
+	}
+
+	for _, secret := range secrets {
+		if secret != "" {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+
+	if err := r.enc.Encode(header{Version: Version, CreatedAt: time.Now().UTC()}); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// CreateRecorder создает файл записи
+func CreateRecorder(path string, secrets ...string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := NewRecorder(f, secrets...)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	r.closer = f
+
+	return r, nil
+}
+
+// Interceptor записывает каждый обмен с приватным REST API
+func (r *Recorder) Interceptor() bitstamp.Interceptor {
+	return func(next bitstamp.Handler) bitstamp.Handler {
+		return func(req *bitstamp.Request) (*bitstamp.Response, error) {
+			resp, err := next(req)
+
+			e := entry{
+				Kind:   kindREST,
+				Path:   req.Path,
+				Params: r.redactParams(req.Params),
+			}
+
+			if resp != nil {
+				e.StatusCode = resp.StatusCode
+				e.Date = resp.Header.Get("Date")
+				e.Body = r.redactJSON(resp.Body)
+			}
+
+			if err != nil {
+				e.Error = r.redactString(err.Error())
+				e.ErrorKind = errorKind(err)
+			}
+
+			r.write(e)
+
+			return resp, err
+		}
+	}
+}
+
+func (r *Recorder) OnConnect(url string) {
+	r.write(entry{Kind: kindWSConnect, URL: url})
+}
+
+func (r *Recorder) OnFrame(frame []byte) {
+	r.write(entry{Kind: kindWSFrame, Frame: r.redactJSON(string(frame))})
+}
+
+// Err возвращает первую ошибку записи
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+// Close закрывает файл, созданный CreateRecorder
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closer == nil {
+		return r.err
+	}
+
+	if err := r.closer.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+
+	r.closer = nil
+
+	return r.err
+}
+
+func (r *Recorder) write(e entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	r.seq++
+	e.Seq = r.seq
+	e.At = time.Now().UTC()
+
+	if err := r.enc.Encode(e); err != nil {
+		r.err = err
+	}
+}
+
+func errorKind(err error) string {
+	var apiErr bitstamp.ErrorResult
+
+	switch {
+	case errors.As(err, &apiErr):
+		return "api"
+	case errors.Is(err, bitstamp.ErrRateLimited):
+		return "rate_limited"
+	default:
+		return "transport"
+	}
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Recorder) redactString(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.Replace(s, secret, redacted, -1)
+	}
+
+	return s
+}
+
+func (r *Recorder) redactParams(params map[string]string) map[string]string {
+	if len(params) == 0 {
+		return nil
+	}
+
+	result := make(map[string]string, len(params))
+
+	for k, v := range params {
+		if isSensitive(k) {
+			v = redacted
+		}
+
+		result[k] = r.redactString(v)
+	}
+
+	return result
+}
+
+// redactJSON вырезает значения чувствительных полей. Порядок ключей не сохраняется,
+// но числа и строки остаются как были, чтобы воспроизвести особенности форматов Bitstamp
+func (r *Recorder) redactJSON(body string) string {
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return r.redactString(body)
+	}
+
+	if !redactValue(value) {
+		return r.redactString(body)
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return r.redactString(body)
+	}
+
+	return r.redactString(string(data))
+}
+
+// redactValue заменяет значения чувствительных полей, возвращает true если что-то изменилось
+func redactValue(value interface{}) bool {
+	changed := false
+
+	switch vv := value.(type) {
+	case map[string]interface{}:
+		for k, v := range vv {
+			if isSensitive(k) {
+				vv[k] = redacted
+				changed = true
+
+				continue
+			}
+
+			if redactValue(v) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, v := range vv {
+			if redactValue(v) {
+				changed = true
+			}
+		}
+	}
+
+	return changed
+}
@@ -0,0 +1,257 @@
+package bitstamprec
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/b2broker/bitstamp"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// ErrUnsupportedVersion запись сделана в неизвестной версии формата
+	ErrUnsupportedVersion = errors.New("unsupported recording version")
+	// ErrReplayExhausted для запроса не осталось записанных ответов
+	ErrReplayExhausted = errors.New("no recorded response left")
+)
+
+// TransportError ошибка транспорта, записанная при записи
+type TransportError struct {
+	Message string
+}
+
+func (e TransportError) Error() string {
+	return e.Message
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// Replayer воспроизводит запись. REST ответы отдаются по порядку для каждого path,
+// кадры WebSocket'a отдаются тестовым сервером по соединениям в том порядке, в каком были записаны
+type Replayer struct {
+	mu       sync.Mutex
+	rest     map[string][]entry
+	segments [][]string
+	conns    int
+
+	srv *httptest.Server
+	// URL адрес сервера воспроизведения для WithBaseURL
+	URL string
+	// WSURL адрес WebSocket'a для WithWSURL
+	WSURL string
+}
+
+// LoadReplay читает запись
+func LoadReplay(r io.Reader) (*Replayer, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("empty recording")
+	}
+
+	var h header
+	if err := json.Unmarshal(scanner.Bytes(), &h); err != nil {
+		return nil, fmt.Errorf("could not read recording header: %w", err)
+	}
+
+	if h.Version != Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, h.Version)
+	}
+
+	rp := &Replayer{
+		rest: make(map[string][]entry),
+	}
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+
+		var e entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("could not read recording entry: %w", err)
+		}
+
+		switch e.Kind {
+		case kindREST:
+			rp.rest[e.Path] = append(rp.rest[e.Path], e)
+		case kindWSConnect:
+			rp.segments = append(rp.segments, nil)
+		case kindWSFrame:
+			if len(rp.segments) == 0 {
+				rp.segments = append(rp.segments, nil)
+			}
+
+			last := len(rp.segments) - 1
+			rp.segments[last] = append(rp.segments[last], e.Frame)
+		default:
+			return nil, fmt.Errorf("unknown recording entry kind %q", e.Kind)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws/", rp.serveWS)
+
+	rp.srv = httptest.NewServer(mux)
+	rp.URL = rp.srv.URL
+	rp.WSURL = "ws" + strings.TrimPrefix(rp.srv.URL, "http") + "/ws/"
+
+	return rp, nil
+}
+
+// OpenReplay читает запись из файла
+func OpenReplay(path string) (*Replayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return LoadReplay(f)
+}
+
+// Interceptor отдает записанные ответы вместо обращения к Bitstamp
+func (rp *Replayer) Interceptor() bitstamp.Interceptor {
+	return func(bitstamp.Handler) bitstamp.Handler {
+		return rp.handle
+	}
+}
+
+func (rp *Replayer) handle(req *bitstamp.Request) (*bitstamp.Response, error) {
+	rp.mu.Lock()
+	queue := rp.rest[req.Path]
+	if len(queue) == 0 {
+		rp.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrReplayExhausted, req.Path)
+	}
+
+	e := queue[0]
+	rp.rest[req.Path] = queue[1:]
+	rp.mu.Unlock()
+
+	if e.ErrorKind == "transport" {
+		return nil, TransportError{Message: e.Error}
+	}
+
+	resp := &bitstamp.Response{
+		StatusCode: e.StatusCode,
+		Header:     http.Header{},
+		Body:       e.Body,
+	}
+
+	if e.Date != "" {
+		resp.Header.Set("Date", e.Date)
+	}
+
+	switch e.ErrorKind {
+	case "rate_limited":
+		return resp, bitstamp.ErrRateLimited
+	case "api":
+		var apiErr bitstamp.ErrorResult
+		if err := json.Unmarshal([]byte(e.Body), &apiErr); err != nil {
+			return resp, TransportError{Message: e.Error}
+		}
+
+		apiErr.HTTPStatus = e.StatusCode
+
+		return resp, apiErr
+	}
+
+	return resp, nil
+}
+
+// Remaining количество неотданных REST ответов
+func (rp *Replayer) Remaining() int {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	n := 0
+	for _, queue := range rp.rest {
+		n += len(queue)
+	}
+
+	return n
+}
+
+// serveWS отдает кадры очередного записанного соединения после первой подписки.
+// Соединение закрывается в конце сегмента, кроме последнего, чтобы воспроизвести переподключения
+func (rp *Replayer) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	rp.mu.Lock()
+	n := rp.conns
+	rp.conns++
+	rp.mu.Unlock()
+
+	var frames []string
+	if n < len(rp.segments) {
+		frames = rp.segments[n]
+	}
+
+	last := n >= len(rp.segments)-1
+
+	if _, _, err := conn.ReadMessage(); err != nil {
+		return
+	}
+
+	for _, frame := range frames {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+			return
+		}
+	}
+
+	if !last {
+		return
+	}
+
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// NewClient создает PrivateClient, который получает ответы из записи
+func (rp *Replayer) NewClient(opts ...bitstamp.ClientOption) *bitstamp.PrivateClient {
+	// интерсептор воспроизведения должен быть внутренним, чтобы интерсепторы из opts тоже вызывались
+	opts = append([]bitstamp.ClientOption{bitstamp.WithBaseURL(rp.URL)}, opts...)
+	opts = append(opts, bitstamp.WithInterceptors(rp.Interceptor()))
+
+	return bitstamp.NewPrivateClient("replay", "replay", opts...)
+}
+
+// NewWebsocket создает Websocket, который получает кадры из записи
+func (rp *Replayer) NewWebsocket(symbols []string, opts ...bitstamp.WSOption) *bitstamp.Websocket {
+	opts = append([]bitstamp.WSOption{bitstamp.WithWSURL(rp.WSURL)}, opts...)
+	return bitstamp.NewWSClientWithOptions(symbols, opts...)
+}
+
+// Close останавливает сервер воспроизведения
+func (rp *Replayer) Close() {
+	rp.srv.CloseClientConnections()
+	rp.srv.Close()
+}
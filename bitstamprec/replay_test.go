@@ -0,0 +1,95 @@
+package bitstamprec_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/b2broker/bitstamp"
+	"github.com/b2broker/bitstamp/bitstamprec"
+	"github.com/b2broker/bitstamp/bitstamptest"
+)
+
+type session struct {
+	placed   bitstamp.PlaceOrderResult
+	open     []bitstamp.OpenOrderResult
+	rejected bitstamp.ErrorResult
+}
+
+// run выполняет одинаковый сценарий при записи и при воспроизведении
+func run(t *testing.T, client *bitstamp.PrivateClient) session {
+	t.Helper()
+
+	var s session
+	var err error
+
+	s.placed, err = client.PlaceOrder(bitstamp.PlaceOrderRequest{
+		Symbol:        "btcusd",
+		Side:          bitstamp.Buy,
+		Type:          bitstamp.Limit,
+		Price:         19000,
+		Amount:        0.01,
+		ClientOrderID: "rec-1",
+	})
+	if err != nil {
+		t.Fatalf("place order: %v", err)
+	}
+
+	s.open, err = client.GetOpenOrders()
+	if err != nil {
+		t.Fatalf("get open orders: %v", err)
+	}
+
+	_, err = client.CancelOrder("1")
+	if !errors.As(err, &s.rejected) {
+		t.Fatalf("cancel unknown order: err = %v, want ErrorResult", err)
+	}
+
+	return s
+}
+
+func TestRecordReplay(t *testing.T) {
+	srv := bitstamptest.NewServer("key", "top-secret")
+	defer srv.Close()
+
+	srv.SetBalance("usd", 1000)
+
+	var buf bytes.Buffer
+
+	recorder, err := bitstamprec.NewRecorder(&buf, "key", "top-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := run(t, srv.NewClient(bitstamp.WithInterceptors(recorder.Interceptor())))
+
+	if err := recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(buf.String(), "top-secret") {
+		t.Fatal("recording contains the secret")
+	}
+
+	replayer, err := bitstamprec.LoadReplay(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayer.Close()
+
+	replayed := run(t, replayer.NewClient())
+
+	if !reflect.DeepEqual(recorded, replayed) {
+		t.Fatalf("replay differs from recording\nrecorded: %+v\nreplayed: %+v", recorded, replayed)
+	}
+
+	if n := replayer.Remaining(); n != 0 {
+		t.Fatalf("%d recorded responses were not replayed", n)
+	}
+
+	if _, err := replayer.NewClient().GetOpenOrders(); !errors.Is(err, bitstamprec.ErrReplayExhausted) {
+		t.Fatalf("err = %v, want ErrReplayExhausted", err)
+	}
+}
@@ -0,0 +1,794 @@
+package bitstamptest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/b2broker/bitstamp"
+)
+
+const datetimeLayout = "2006-01-02 15:04:05.000000"
+
+// Order снимок ордера пользователя
+type Order struct {
+	ID            int64
+	Pair          string
+	Side          bitstamp.OrderSide
+	Type          bitstamp.OrderType
+	Price         float64
+	Amount        float64
+	Remaining     float64
+	ClientOrderID string
+	Status        string
+	CreatedAt     time.Time
+}
+
+type order struct {
+	Order
+	trades []transaction
+}
+
+type transaction struct {
+	ID             int64
+	OrderID        int64
+	CounterOrderID int64
+	ClientOrderID  string
+	Pair           string
+	Side           bitstamp.OrderSide
+	Price          float64
+	Amount         float64
+	Fee            float64
+	At             time.Time
+}
+
+// liquidity сценарная заявка другого участника
+type liquidity struct {
+	id     int64
+	price  float64
+	amount float64
+}
+
+// book сценарная книга: bids по убыванию цены, asks по возрастанию
+type book struct {
+	bids []*liquidity
+	asks []*liquidity
+}
+
+// event сообщение для подписчиков WebSocket канала
+type event struct {
+	channel string
+	event   string
+	data    interface{}
+}
+
+func (s *Server) book(pair string) *book {
+	b, ok := s.books[pair]
+	if !ok {
+		b = &book{}
+		s.books[pair] = b
+	}
+
+	return b
+}
+
+func (s *Server) id() int64 {
+	s.nextID++
+	return s.nextID
+}
+
+// AddLiquidity добавляет в книгу заявку другого участника. side - сторона заявки: bitstamp.Buy для bid.
+// Часть, пересекающая ордера пользователя, сразу сводится с ними
+func (s *Server) AddLiquidity(pair string, side bitstamp.OrderSide, price float64, amount float64) {
+	s.mu.Lock()
+
+	id := s.id()
+	events, remaining := s.matchResting(pair, side, id, price, amount)
+
+	if remaining > 0 {
+		b := s.book(pair)
+		level := &liquidity{id: id, price: price, amount: remaining}
+
+		if side == bitstamp.Buy {
+			b.bids = append(b.bids, level)
+			sort.SliceStable(b.bids, func(i, j int) bool { return b.bids[i].price > b.bids[j].price })
+		} else {
+			b.asks = append(b.asks, level)
+			sort.SliceStable(b.asks, func(i, j int) bool { return b.asks[i].price < b.asks[j].price })
+		}
+	}
+
+	s.mu.Unlock()
+
+	s.broadcast(events)
+}
+
+// ClearBook удаляет все сценарные заявки по паре
+func (s *Server) ClearBook(pair string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.books, pair)
+}
+
+// Trade имитирует агрессивную сделку другого участника: сначала сводится с ордерами пользователя,
+// остаток публикуется в live_trades как сделка по price
+func (s *Server) Trade(pair string, side bitstamp.OrderSide, price float64, amount float64) {
+	s.mu.Lock()
+
+	id := s.id()
+	events, remaining := s.matchResting(pair, side, id, price, amount)
+
+	if remaining > 0 {
+		events = append(events, s.liveTrade(pair, side, id, 0, price, remaining))
+	}
+
+	s.mu.Unlock()
+
+	s.broadcast(events)
+}
+
+// Orders возвращает все ордера пользователя в порядке создания
+func (s *Server) Orders() []Order {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]Order, 0, len(s.orderSeq))
+	for _, id := range s.orderSeq {
+		result = append(result, s.orders[id].Order)
+	}
+
+	return result
+}
+
+// Balance возвращает баланс валюты
+func (s *Server) Balance(currency string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.balances[strings.ToLower(currency)]
+}
+
+// matchResting сводит агрессора другого участника с открытыми ордерами пользователя. Вызывается под s.mu
+func (s *Server) matchResting(pair string, side bitstamp.OrderSide, counterID int64, price float64, amount float64) ([]event, float64) {
+	var events []event
+
+	for _, id := range s.orderSeq {
+		if amount <= 0 {
+			break
+		}
+
+		o := s.orders[id]
+		if o.Pair != pair || o.Status != bitstamp.OrderStatusOpen || o.Side == side {
+			continue
+		}
+
+		crosses := (side == bitstamp.Buy && o.Price <= price) || (side == bitstamp.Sell && o.Price >= price)
+		if !crosses {
+			continue
+		}
+
+		size := o.Remaining
+		if amount < size {
+			size = amount
+		}
+
+		amount -= size
+		events = append(events, s.fill(o, counterID, o.Price, size, side)...)
+	}
+
+	return events, amount
+}
+
+// matchBook сводит ордер пользователя со сценарной книгой. Вызывается под s.mu
+func (s *Server) matchBook(o *order) []event {
+	b := s.book(o.Pair)
+
+	levels := b.asks
+	if o.Side == bitstamp.Sell {
+		levels = b.bids
+	}
+
+	var events []event
+
+	for len(levels) > 0 && o.Remaining > 0 {
+		level := levels[0]
+
+		if o.Type == bitstamp.Limit {
+			if (o.Side == bitstamp.Buy && level.price > o.Price) || (o.Side == bitstamp.Sell && level.price < o.Price) {
+				break
+			}
+		}
+
+		size := level.amount
+		if o.Remaining < size {
+			size = o.Remaining
+		}
+
+		level.amount -= size
+		if level.amount <= 0 {
+			levels = levels[1:]
+		}
+
+		events = append(events, s.fill(o, level.id, level.price, size, o.Side)...)
+	}
+
+	if o.Side == bitstamp.Buy {
+		b.asks = levels
+	} else {
+		b.bids = levels
+	}
+
+	return events
+}
+
+// available объем сценарной книги, доступный ордеру
+func (s *Server) available(o *order) float64 {
+	b := s.book(o.Pair)
+
+	levels := b.asks
+	if o.Side == bitstamp.Sell {
+		levels = b.bids
+	}
+
+	var total float64
+
+	for _, level := range levels {
+		if o.Type == bitstamp.Limit {
+			if (o.Side == bitstamp.Buy && level.price > o.Price) || (o.Side == bitstamp.Sell && level.price < o.Price) {
+				break
+			}
+		}
+
+		total += level.amount
+	}
+
+	return total
+}
+
+// fill исполняет часть ордера пользователя и формирует события. aggressor - сторона агрессора сделки
+func (s *Server) fill(o *order, counterID int64, price float64, size float64, aggressor bitstamp.OrderSide) []event {
+	now := s.now()
+	base, quote := bitstamp.SplitSymbol(o.Pair)
+	fee := price * size * s.feeRate
+
+	t := transaction{
+		ID:             s.id(),
+		OrderID:        o.ID,
+		CounterOrderID: counterID,
+		ClientOrderID:  o.ClientOrderID,
+		Pair:           o.Pair,
+		Side:           o.Side,
+		Price:          price,
+		Amount:         size,
+		Fee:            fee,
+		At:             now,
+	}
+
+	o.Remaining -= size
+	if o.Remaining <= 1e-12 {
+		o.Remaining = 0
+		o.Status = bitstamp.OrderStatusFinished
+	}
+
+	o.trades = append(o.trades, t)
+	s.transactions = append(s.transactions, t)
+	s.lastPrice[o.Pair] = price
+
+	if o.Side == bitstamp.Buy {
+		s.balances[base] += size
+		s.balances[quote] -= price*size + fee
+	} else {
+		s.balances[base] -= size
+		s.balances[quote] += price*size - fee
+	}
+
+	buyOrderID, sellOrderID := o.ID, counterID
+	if o.Side == bitstamp.Sell {
+		buyOrderID, sellOrderID = counterID, o.ID
+	}
+
+	events := []event{
+		{
+			channel: fmt.Sprintf("private-my_trades_%s-%d", o.Pair, s.userID),
+			event:   "trade",
+			data: map[string]interface{}{
+				"id":              t.ID,
+				"order_id":        o.ID,
+				"client_order_id": o.ClientOrderID,
+				"amount":          formatFloat(size),
+				"price":           formatFloat(price),
+				"fee":             formatFloat(fee),
+				"fee_currency":    quote,
+				"side":            string(o.Side),
+				"microtimestamp":  strconv.FormatInt(now.UnixNano()/int64(time.Microsecond), 10),
+				"buy_order_id":    buyOrderID,
+				"sell_order_id":   sellOrderID,
+			},
+		},
+		s.liveTrade(o.Pair, aggressor, buyOrderID, sellOrderID, price, size),
+	}
+
+	if o.Status == bitstamp.OrderStatusFinished {
+		events = append(events, s.orderEvent(o, "order_deleted"))
+	} else if o.Type == bitstamp.Limit {
+		events = append(events, s.orderEvent(o, "order_changed"))
+	}
+
+	return events
+}
+
+// liveTrade публичная сделка. Для сделок без участия пользователя sellOrderID равен 0
+func (s *Server) liveTrade(pair string, aggressor bitstamp.OrderSide, buyOrderID int64, sellOrderID int64, price float64, size float64) event {
+	now := s.now()
+	s.lastPrice[pair] = price
+
+	tradeType := bitstamp.OrderSideBuy
+	if aggressor == bitstamp.Sell {
+		tradeType = bitstamp.OrderSideSell
+	}
+
+	return event{
+		channel: "live_trades_" + pair,
+		event:   "trade",
+		data: map[string]interface{}{
+			"id":             s.id(),
+			"timestamp":      strconv.FormatInt(now.Unix(), 10),
+			"microtimestamp": strconv.FormatInt(now.UnixNano()/int64(time.Microsecond), 10),
+			"amount":         size,
+			"amount_str":     formatFloat(size),
+			"price":          price,
+			"price_str":      formatFloat(price),
+			"type":           tradeType,
+			"buy_order_id":   buyOrderID,
+			"sell_order_id":  sellOrderID,
+		},
+	}
+}
+
+func (s *Server) orderEvent(o *order, name string) event {
+	orderType := bitstamp.OrderSideBuy
+	if o.Side == bitstamp.Sell {
+		orderType = bitstamp.OrderSideSell
+	}
+
+	now := s.now()
+
+	return event{
+		channel: fmt.Sprintf("private-my_orders_%s-%d", o.Pair, s.userID),
+		event:   name,
+		data: map[string]interface{}{
+			"id":              o.ID,
+			"id_str":          strconv.FormatInt(o.ID, 10),
+			"order_type":      orderType,
+			"datetime":        strconv.FormatInt(now.Unix(), 10),
+			"microtimestamp":  strconv.FormatInt(now.UnixNano()/int64(time.Microsecond), 10),
+			"amount":          o.Remaining,
+			"amount_str":      formatFloat(o.Remaining),
+			"price":           o.Price,
+			"price_str":       formatFloat(o.Price),
+			"client_order_id": o.ClientOrderID,
+		},
+	}
+}
+
+func (s *Server) placeOrder(side bitstamp.OrderSide, orderType bitstamp.OrderType, pair string, values map[string]string) (interface{}, *bitstamp.ErrorResult) {
+	amount, err := strconv.ParseFloat(values["amount"], 64)
+	if err != nil || amount <= 0 {
+		return nil, &bitstamp.ErrorResult{Code: codeInvalidOrder, Reason: map[string][]string{"amount": {"Invalid amount"}}}
+	}
+
+	var price float64
+
+	if orderType == bitstamp.Limit {
+		price, err = strconv.ParseFloat(values["price"], 64)
+		if err != nil || price <= 0 {
+			return nil, &bitstamp.ErrorResult{Code: codeInvalidOrder, Reason: map[string][]string{"price": {"Invalid price"}}}
+		}
+	}
+
+	s.mu.Lock()
+
+	o := &order{Order: Order{
+		ID:            s.id(),
+		Pair:          pair,
+		Side:          side,
+		Type:          orderType,
+		Price:         price,
+		Amount:        amount,
+		Remaining:     amount,
+		ClientOrderID: values["client_order_id"],
+		Status:        bitstamp.OrderStatusOpen,
+		CreatedAt:     s.now(),
+	}}
+
+	s.orders[o.ID] = o
+	s.orderSeq = append(s.orderSeq, o.ID)
+
+	var events []event
+
+	fok := values["fok_order"] == "True"
+	ioc := values["ioc_order"] == "True"
+
+	if !fok || s.available(o) >= amount {
+		events = s.matchBook(o)
+	}
+
+	if o.Status == bitstamp.OrderStatusOpen {
+		if orderType == bitstamp.Market || fok || ioc {
+			o.Status = bitstamp.OrderStatusCanceled
+		} else {
+			events = append([]event{s.orderEvent(o, "order_created")}, events...)
+		}
+	}
+
+	if price == 0 && len(o.trades) > 0 {
+		price = o.trades[len(o.trades)-1].Price
+	}
+
+	result := placeOrderResult(o, price)
+
+	s.mu.Unlock()
+
+	s.broadcast(events)
+
+	return result, nil
+}
+
+func placeOrderResult(o *order, price float64) map[string]interface{} {
+	return map[string]interface{}{
+		"id":              strconv.FormatInt(o.ID, 10),
+		"datetime":        o.CreatedAt.UTC().Format(datetimeLayout),
+		"type":            sideType(o.Side),
+		"price":           formatFloat(price),
+		"amount":          formatFloat(o.Amount),
+		"client_order_id": o.ClientOrderID,
+	}
+}
+
+func sideType(side bitstamp.OrderSide) string {
+	if side == bitstamp.Sell {
+		return strconv.Itoa(bitstamp.OrderSideSell)
+	}
+
+	return strconv.Itoa(bitstamp.OrderSideBuy)
+}
+
+func currencyPair(pair string) string {
+	base, quote := bitstamp.SplitSymbol(pair)
+	return strings.ToUpper(base) + "/" + strings.ToUpper(quote)
+}
+
+func (s *Server) findOrder(values map[string]string) (*order, *bitstamp.ErrorResult) {
+	if id, err := strconv.ParseInt(values["id"], 10, 64); err == nil {
+		if o, ok := s.orders[id]; ok {
+			return o, nil
+		}
+	}
+
+	if clientOrderID := values["client_order_id"]; clientOrderID != "" {
+		for _, id := range s.orderSeq {
+			if s.orders[id].ClientOrderID == clientOrderID {
+				return s.orders[id], nil
+			}
+		}
+	}
+
+	return nil, &bitstamp.ErrorResult{Code: codeOrderNotFound, Reason: "Order not found"}
+}
+
+func (s *Server) orderStatus(values map[string]string) (interface{}, *bitstamp.ErrorResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, errResult := s.findOrder(values)
+	if errResult != nil {
+		return nil, errResult
+	}
+
+	base, quote := bitstamp.SplitSymbol(o.Pair)
+	transactions := make([]map[string]interface{}, 0, len(o.trades))
+
+	for _, t := range o.trades {
+		transactions = append(transactions, map[string]interface{}{
+			"tid":      t.ID,
+			"price":    formatFloat(t.Price),
+			"fee":      formatFloat(t.Fee),
+			"datetime": t.At.UTC().Format(datetimeLayout),
+			"type":     bitstamp.TransactionTrade,
+			base:       formatFloat(t.Amount),
+			quote:      formatFloat(t.Amount * t.Price),
+		})
+	}
+
+	return map[string]interface{}{
+		"id":               o.ID,
+		"datetime":         o.CreatedAt.UTC().Format(datetimeLayout),
+		"type":             sideType(o.Side),
+		"status":           o.Status,
+		"amount_remaining": formatFloat(o.Remaining),
+		"client_order_id":  o.ClientOrderID,
+		"transactions":     transactions,
+	}, nil
+}
+
+func (s *Server) cancelOrder(values map[string]string) (interface{}, *bitstamp.ErrorResult) {
+	s.mu.Lock()
+
+	o, errResult := s.findOrder(values)
+	if errResult != nil || o.Status != bitstamp.OrderStatusOpen {
+		s.mu.Unlock()
+		return nil, &bitstamp.ErrorResult{Code: codeOrderNotFound, Reason: "Order not found"}
+	}
+
+	o.Status = bitstamp.OrderStatusCanceled
+	events := []event{s.orderEvent(o, "order_deleted")}
+
+	s.mu.Unlock()
+
+	s.broadcast(events)
+
+	return map[string]interface{}{
+		"id":     strconv.FormatInt(o.ID, 10),
+		"amount": formatFloat(o.Remaining),
+		"price":  formatFloat(o.Price),
+		"type":   sideType(o.Side),
+	}, nil
+}
+
+func (s *Server) cancelAllOrders() interface{} {
+	s.mu.Lock()
+
+	canceled := make([]map[string]interface{}, 0)
+
+	var events []event
+
+	for _, id := range s.orderSeq {
+		o := s.orders[id]
+		if o.Status != bitstamp.OrderStatusOpen {
+			continue
+		}
+
+		o.Status = bitstamp.OrderStatusCanceled
+		events = append(events, s.orderEvent(o, "order_deleted"))
+		canceled = append(canceled, map[string]interface{}{
+			"id":            o.ID,
+			"amount":        o.Remaining,
+			"price":         o.Price,
+			"type":          sideType(o.Side),
+			"currency_pair": currencyPair(o.Pair),
+		})
+	}
+
+	s.mu.Unlock()
+
+	s.broadcast(events)
+
+	return map[string]interface{}{
+		"success":  true,
+		"canceled": canceled,
+	}
+}
+
+func (s *Server) openOrders() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]map[string]interface{}, 0)
+
+	for _, id := range s.orderSeq {
+		o := s.orders[id]
+		if o.Status != bitstamp.OrderStatusOpen {
+			continue
+		}
+
+		result = append(result, map[string]interface{}{
+			"id":              strconv.FormatInt(o.ID, 10),
+			"datetime":        o.CreatedAt.UTC().Format(datetimeLayout),
+			"type":            sideType(o.Side),
+			"price":           formatFloat(o.Price),
+			"amount":          formatFloat(o.Remaining),
+			"currency_pair":   currencyPair(o.Pair),
+			"client_order_id": o.ClientOrderID,
+		})
+	}
+
+	return result
+}
+
+func (s *Server) balance() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reserved := make(map[string]float64)
+
+	for _, o := range s.orders {
+		if o.Status != bitstamp.OrderStatusOpen {
+			continue
+		}
+
+		base, quote := bitstamp.SplitSymbol(o.Pair)
+
+		if o.Side == bitstamp.Buy {
+			reserved[quote] += o.Price * o.Remaining * (1 + s.feeRate)
+		} else {
+			reserved[base] += o.Remaining
+		}
+	}
+
+	result := make(map[string]string)
+
+	for currency, total := range s.balances {
+		result[currency+"_balance"] = formatFloat(total)
+		result[currency+"_reserved"] = formatFloat(reserved[currency])
+		result[currency+"_available"] = formatFloat(total - reserved[currency])
+		result[currency+"_withdrawal_fee"] = formatFloat(0)
+	}
+
+	for pair := range s.books {
+		result[pair+"_fee"] = strconv.FormatFloat(s.feeRate*100, 'f', 3, 64)
+	}
+
+	return result
+}
+
+func (s *Server) userTransactions(pair string, values map[string]string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var since time.Time
+	if ts, err := strconv.ParseInt(values["since_timestamp"], 10, 64); err == nil {
+		since = time.Unix(ts, 0)
+	}
+
+	sinceID, _ := strconv.ParseInt(values["since_id"], 10, 64)
+
+	selected := make([]transaction, 0)
+
+	for _, t := range s.transactions {
+		if pair != "" && t.Pair != pair {
+			continue
+		}
+
+		if t.At.Before(since) || t.ID <= sinceID {
+			continue
+		}
+
+		selected = append(selected, t)
+	}
+
+	if values["sort"] != "asc" {
+		for i, j := 0, len(selected)-1; i < j; i, j = i+1, j-1 {
+			selected[i], selected[j] = selected[j], selected[i]
+		}
+	}
+
+	offset, _ := strconv.Atoi(values["offset"])
+	if offset > len(selected) {
+		offset = len(selected)
+	}
+
+	selected = selected[offset:]
+
+	limit, err := strconv.Atoi(values["limit"])
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+
+	if limit < len(selected) {
+		selected = selected[:limit]
+	}
+
+	result := make([]map[string]interface{}, 0, len(selected))
+
+	for _, t := range selected {
+		base, quote := bitstamp.SplitSymbol(t.Pair)
+
+		baseAmount, quoteAmount := t.Amount, -t.Amount*t.Price
+		if t.Side == bitstamp.Sell {
+			baseAmount, quoteAmount = -t.Amount, t.Amount*t.Price
+		}
+
+		result = append(result, map[string]interface{}{
+			"id":               t.ID,
+			"order_id":         t.OrderID,
+			"datetime":         t.At.UTC().Format(datetimeLayout),
+			"type":             strconv.Itoa(bitstamp.TransactionTrade),
+			"fee":              formatFloat(t.Fee),
+			base:               formatFloat(baseAmount),
+			quote:              formatFloat(quoteAmount),
+			base + "_" + quote: t.Price,
+		})
+	}
+
+	return result
+}
+
+func (s *Server) ticker(pair string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bids, asks := s.levels(pair)
+
+	var bid, ask float64
+	if len(bids) > 0 {
+		bid = bids[0][0]
+	}
+
+	if len(asks) > 0 {
+		ask = asks[0][0]
+	}
+
+	last := s.lastPrice[pair]
+
+	return map[string]string{
+		"last":      formatFloat(last),
+		"bid":       formatFloat(bid),
+		"ask":       formatFloat(ask),
+		"high":      formatFloat(last),
+		"low":       formatFloat(last),
+		"open":      formatFloat(last),
+		"vwap":      formatFloat(last),
+		"volume":    formatFloat(0),
+		"timestamp": strconv.FormatInt(s.now().Unix(), 10),
+	}
+}
+
+func (s *Server) orderBook(pair string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bids, asks := s.levels(pair)
+	now := s.now()
+
+	format := func(levels [][2]float64) [][]string {
+		result := make([][]string, 0, len(levels))
+		for _, level := range levels {
+			result = append(result, []string{formatFloat(level[0]), formatFloat(level[1])})
+		}
+
+		return result
+	}
+
+	return map[string]interface{}{
+		"timestamp":      strconv.FormatInt(now.Unix(), 10),
+		"microtimestamp": strconv.FormatInt(now.UnixNano()/int64(time.Microsecond), 10),
+		"bids":           format(bids),
+		"asks":           format(asks),
+	}
+}
+
+// levels книга по паре: сценарные заявки и открытые ордера пользователя. Вызывается под s.mu
+func (s *Server) levels(pair string) ([][2]float64, [][2]float64) {
+	var bids, asks [][2]float64
+
+	b := s.book(pair)
+
+	for _, level := range b.bids {
+		bids = append(bids, [2]float64{level.price, level.amount})
+	}
+
+	for _, level := range b.asks {
+		asks = append(asks, [2]float64{level.price, level.amount})
+	}
+
+	for _, id := range s.orderSeq {
+		o := s.orders[id]
+		if o.Pair != pair || o.Status != bitstamp.OrderStatusOpen {
+			continue
+		}
+
+		if o.Side == bitstamp.Buy {
+			bids = append(bids, [2]float64{o.Price, o.Remaining})
+		} else {
+			asks = append(asks, [2]float64{o.Price, o.Remaining})
+		}
+	}
+
+	sort.SliceStable(bids, func(i, j int) bool { return bids[i][0] > bids[j][0] })
+	sort.SliceStable(asks, func(i, j int) bool { return asks[i][0] < asks[j][0] })
+
+	return bids, asks
+}
@@ -0,0 +1,426 @@
+// Package bitstamptest фейковый Bitstamp для интеграционных тестов: приватный REST API v2 и WebSocket.
+// Сервер проверяет подписи, сводит лимитные и рыночные ордера со сценарной книгой,
+// отправляет события my_trades, my_orders и live_trades, умеет внедрять ошибки, задержки и дисконекты.
+package bitstamptest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/b2broker/bitstamp"
+)
+
+// timestampWindow допустимое расхождение X-Auth-Timestamp с часами сервера
+const timestampWindow = 150 * time.Second
+
+const (
+	codeInvalidAuth      = "API0001"
+	codeNonceUsed        = "API0004"
+	codeInvalidSignature = "API0005"
+	codeTimestamp        = "API0019"
+	codeOrderNotFound    = "404"
+	codeInvalidOrder     = "400.001"
+)
+
+// Fault ошибка или задержка, которую сервер применит к запросам
+type Fault struct {
+	// Path путь запроса, пусто - любой приватный запрос
+	Path string
+	// Delay задержка перед обработкой
+	Delay time.Duration
+	// Status HTTP статус ответа. Если Status и Code пустые, запрос обрабатывается после задержки
+	Status int
+	Code   string
+	Reason string
+	// Times сколько раз сработать, 0 - один раз
+	Times int
+}
+
+// Server фейковый Bitstamp
+type Server struct {
+	// URL адрес REST API для bitstamp.WithBaseURL
+	URL string
+	// WSURL адрес WebSocket'a для bitstamp.WithWSURL
+	WSURL string
+
+	srv    *httptest.Server
+	apiKey string
+	secret string
+	userID int
+
+	mu           sync.Mutex
+	now          func() time.Time
+	latency      time.Duration
+	feeRate      float64
+	faults       []*Fault
+	nonces       map[string]struct{}
+	tokens       map[string]struct{}
+	nextID       int64
+	books        map[string]*book
+	orders       map[int64]*order
+	orderSeq     []int64
+	transactions []transaction
+	balances     map[string]float64
+	lastPrice    map[string]float64
+
+	connsMu sync.Mutex
+	conns   map[*wsConn]struct{}
+}
+
+// NewServer запускает фейковый сервер, принимающий запросы, подписанные apiKey и secretKey
+func NewServer(apiKey string, secretKey string) *Server {
+	s := &Server{
+		apiKey:    apiKey,
+		secret:    secretKey,
+		userID:    1,
+		now:       time.Now,
+		nonces:    make(map[string]struct{}),
+		tokens:    make(map[string]struct{}),
+		nextID:    1000,
+		books:     make(map[string]*book),
+		orders:    make(map[int64]*order),
+		balances:  make(map[string]float64),
+		lastPrice: make(map[string]float64),
+		conns:     make(map[*wsConn]struct{}),
+	}
+
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL
+	s.WSURL = "ws" + strings.TrimPrefix(s.srv.URL, "http") + "/ws/"
+
+	return s
+}
+
+// Close останавливает сервер и закрывает все WebSocket соединения
+func (s *Server) Close() {
+	s.DropConnections()
+	s.srv.Close()
+}
+
+// NewClient создает PrivateClient, направленный на сервер
+func (s *Server) NewClient(opts ...bitstamp.ClientOption) *bitstamp.PrivateClient {
+	opts = append([]bitstamp.ClientOption{bitstamp.WithBaseURL(s.URL)}, opts...)
+	return bitstamp.NewPrivateClient(s.apiKey, s.secret, opts...)
+}
+
+// NewWebsocket создает Websocket, направленный на сервер
+func (s *Server) NewWebsocket(symbols []string, opts ...bitstamp.WSOption) *bitstamp.Websocket {
+	opts = append([]bitstamp.WSOption{bitstamp.WithWSURL(s.WSURL)}, opts...)
+	return bitstamp.NewWSClientWithOptions(symbols, opts...)
+}
+
+// UserID идентификатор пользователя в приватных каналах
+func (s *Server) UserID() int {
+	return s.userID
+}
+
+// SetClock задает часы сервера, например отстающие для проверки коррекции времени
+func (s *Server) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.now = now
+}
+
+// SetLatency задает задержку всех приватных запросов
+func (s *Server) SetLatency(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latency = latency
+}
+
+// SetFeeRate задает комиссию, например 0.005 для 0.5%
+func (s *Server) SetFeeRate(rate float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.feeRate = rate
+}
+
+// SetBalance задает баланс валюты. Сервер не проверяет достаточность средств
+func (s *Server) SetBalance(currency string, amount float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.balances[strings.ToLower(currency)] = amount
+}
+
+// InjectFault добавляет ошибку или задержку для следующих запросов
+func (s *Server) InjectFault(fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if fault.Times <= 0 {
+		fault.Times = 1
+	}
+
+	s.faults = append(s.faults, &fault)
+}
+
+// takeFault возвращает подходящую ошибку и уменьшает ее счетчик
+func (s *Server) takeFault(path string) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, fault := range s.faults {
+		if fault.Path != "" && fault.Path != path {
+			continue
+		}
+
+		fault.Times--
+		if fault.Times <= 0 {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+		}
+
+		result := *fault
+
+		return &result
+	}
+
+	return nil
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	w.Header().Set("Date", s.now().UTC().Format(http.TimeFormat))
+	s.mu.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, "/ws/") {
+		s.serveWS(w, r)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		s.servePublic(w, r)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	latency := s.latency
+	s.mu.Unlock()
+
+	time.Sleep(latency)
+
+	if fault := s.takeFault(r.URL.Path); fault != nil {
+		time.Sleep(fault.Delay)
+
+		if fault.Status != 0 || fault.Code != "" {
+			status := fault.Status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			writeError(w, status, fault.Code, fault.Reason)
+
+			return
+		}
+	}
+
+	if errResult := s.authenticate(r, body); errResult != nil {
+		writeError(w, http.StatusForbidden, errResult.Code, errResult.Reason)
+		return
+	}
+
+	values, err := parseForm(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidOrder, err.Error())
+		return
+	}
+
+	result, errResult := s.route(r.URL.Path, values)
+	if errResult != nil {
+		writeError(w, http.StatusBadRequest, errResult.Code, errResult.Reason)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// authenticate проверяет заголовки X-Auth-* так же, как Bitstamp API v2
+func (s *Server) authenticate(r *http.Request, body []byte) *bitstamp.ErrorResult {
+	if r.Header.Get("X-Auth") != "BITSTAMP "+s.apiKey || r.Header.Get("X-Auth-Version") != "v2" {
+		return &bitstamp.ErrorResult{Code: codeInvalidAuth, Reason: "Invalid X-Auth header"}
+	}
+
+	nonce := r.Header.Get("X-Auth-Nonce")
+	timestamp := r.Header.Get("X-Auth-Timestamp")
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return &bitstamp.ErrorResult{Code: codeTimestamp, Reason: "Invalid timestamp"}
+	}
+
+	s.mu.Lock()
+	now := s.now()
+	s.mu.Unlock()
+
+	if diff := now.Sub(time.Unix(0, ts*int64(time.Millisecond))); diff > timestampWindow || diff < -timestampWindow {
+		return &bitstamp.ErrorResult{Code: codeTimestamp, Reason: "Timestamp is outside of the allowed window"}
+	}
+
+	msg := "BITSTAMP " + s.apiKey +
+		r.Method +
+		r.Host +
+		r.URL.Path +
+		r.URL.RawQuery +
+		r.Header.Get("Content-Type") +
+		nonce +
+		timestamp +
+		"v2" +
+		string(body)
+
+	h := hmac.New(sha256.New, []byte(s.secret))
+	_, _ = h.Write([]byte(msg))
+
+	signature, err := hex.DecodeString(r.Header.Get("X-Auth-Signature"))
+	if err != nil || !hmac.Equal(signature, h.Sum(nil)) {
+		return &bitstamp.ErrorResult{Code: codeInvalidSignature, Reason: "Invalid signature"}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.nonces[nonce]; ok {
+		return &bitstamp.ErrorResult{Code: codeNonceUsed, Reason: "Nonce has already been used"}
+	}
+
+	s.nonces[nonce] = struct{}{}
+
+	return nil
+}
+
+func (s *Server) route(path string, values map[string]string) (interface{}, *bitstamp.ErrorResult) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) < 3 || segments[0] != "api" || segments[1] != "v2" {
+		return nil, &bitstamp.ErrorResult{Code: codeInvalidOrder, Reason: "Unknown endpoint"}
+	}
+
+	segments = segments[2:]
+
+	switch {
+	case segments[0] == "balance":
+		return s.balance(), nil
+	case segments[0] == "user_transactions":
+		pair := ""
+		if len(segments) > 1 {
+			pair = segments[1]
+		}
+
+		return s.userTransactions(pair, values), nil
+	case segments[0] == "open_orders":
+		return s.openOrders(), nil
+	case segments[0] == "order_status":
+		return s.orderStatus(values)
+	case segments[0] == "cancel_order":
+		return s.cancelOrder(values)
+	case segments[0] == "cancel_all_orders":
+		return s.cancelAllOrders(), nil
+	case segments[0] == "websockets_token":
+		return s.websocketsToken(), nil
+	case (segments[0] == "buy" || segments[0] == "sell") && len(segments) == 3 && segments[1] == "market":
+		return s.placeOrder(bitstamp.OrderSide(segments[0]), bitstamp.Market, segments[2], values)
+	case (segments[0] == "buy" || segments[0] == "sell") && len(segments) == 2:
+		return s.placeOrder(bitstamp.OrderSide(segments[0]), bitstamp.Limit, segments[1], values)
+	default:
+		return nil, &bitstamp.ErrorResult{Code: codeInvalidOrder, Reason: "Unknown endpoint"}
+	}
+}
+
+// servePublic публичные эндпоинты: ticker и order_book
+func (s *Server) servePublic(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(segments) != 4 || segments[0] != "api" || segments[1] != "v2" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch segments[2] {
+	case "ticker":
+		writeJSON(w, http.StatusOK, s.ticker(segments[3]))
+	case "order_book":
+		writeJSON(w, http.StatusOK, s.orderBook(segments[3]))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) websocketsToken() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	token := fmt.Sprintf("token-%d", s.nextID)
+	s.tokens[token] = struct{}{}
+
+	return map[string]interface{}{
+		"token":     token,
+		"valid_sec": 60,
+		"user_id":   s.userID,
+	}
+}
+
+func (s *Server) validToken(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.tokens[token]
+
+	return ok
+}
+
+func parseForm(body []byte) (map[string]string, error) {
+	values := make(map[string]string)
+
+	if len(body) == 0 {
+		return values, nil
+	}
+
+	parsed, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range parsed {
+		if len(v) > 0 {
+			values[k] = v[0]
+		}
+	}
+
+	return values, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, reason interface{}) {
+	writeJSON(w, status, map[string]interface{}{
+		"status": "error",
+		"reason": reason,
+		"code":   code,
+	})
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', 8, 64)
+}
@@ -0,0 +1,109 @@
+package bitstamptest_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/b2broker/bitstamp"
+	"github.com/b2broker/bitstamp/bitstamptest"
+)
+
+func TestPlaceOrderRoundTrip(t *testing.T) {
+	srv := bitstamptest.NewServer("key", "secret")
+	defer srv.Close()
+
+	srv.SetBalance("usd", 1000)
+	srv.AddLiquidity("btcusd", bitstamp.Sell, 20000, 0.01)
+
+	client := srv.NewClient()
+
+	resting, err := client.PlaceOrder(bitstamp.PlaceOrderRequest{
+		Symbol:        "btcusd",
+		Side:          bitstamp.Buy,
+		Type:          bitstamp.Limit,
+		Price:         19000,
+		Amount:        0.01,
+		ClientOrderID: "resting-1",
+	})
+	if err != nil {
+		t.Fatalf("place limit order: %v", err)
+	}
+
+	if resting.ID == 0 || resting.ClientOrderID != "resting-1" {
+		t.Fatalf("unexpected limit order result: %+v", resting)
+	}
+
+	filled, err := client.PlaceOrder(bitstamp.PlaceOrderRequest{
+		Symbol: "btcusd",
+		Side:   bitstamp.Buy,
+		Type:   bitstamp.Market,
+		Amount: 0.01,
+	})
+	if err != nil {
+		t.Fatalf("place market order: %v", err)
+	}
+
+	if filled.Price != 20000 {
+		t.Fatalf("market order price = %v, want 20000", filled.Price)
+	}
+
+	orders := srv.Orders()
+	if len(orders) != 2 {
+		t.Fatalf("server has %d orders, want 2", len(orders))
+	}
+
+	if orders[0].ID != resting.ID || orders[0].Status != bitstamp.OrderStatusOpen || orders[0].Price != 19000 {
+		t.Fatalf("unexpected resting order: %+v", orders[0])
+	}
+
+	if orders[1].ID != filled.ID || orders[1].Remaining != 0 {
+		t.Fatalf("unexpected market order: %+v", orders[1])
+	}
+
+	if _, err := bitstamp.ParseClientOrderID(orders[1].ClientOrderID); err != nil {
+		t.Fatalf("market order client order id isn't generated: %v", err)
+	}
+
+	if got := srv.Balance("btc"); got != 0.01 {
+		t.Fatalf("btc balance = %v, want 0.01", got)
+	}
+
+	open, err := client.GetOpenOrders()
+	if err != nil {
+		t.Fatalf("get open orders: %v", err)
+	}
+
+	if len(open) != 1 || open[0].ID != resting.ID {
+		t.Fatalf("unexpected open orders: %+v", open)
+	}
+}
+
+func TestPlaceOrderRejectsBadSignature(t *testing.T) {
+	srv := bitstamptest.NewServer("key", "secret")
+	defer srv.Close()
+
+	srv.SetBalance("usd", 1000)
+
+	client := bitstamp.NewPrivateClient("key", "other", bitstamp.WithBaseURL(srv.URL))
+
+	_, err := client.PlaceOrder(bitstamp.PlaceOrderRequest{
+		Symbol: "btcusd",
+		Side:   bitstamp.Buy,
+		Type:   bitstamp.Limit,
+		Price:  19000,
+		Amount: 0.01,
+	})
+
+	var rejected bitstamp.ErrorResult
+	if !errors.As(err, &rejected) {
+		t.Fatalf("err = %v, want ErrorResult", err)
+	}
+
+	if rejected.Code != "API0005" {
+		t.Fatalf("code = %s, want invalid signature", rejected.Code)
+	}
+
+	if len(srv.Orders()) != 0 {
+		t.Fatal("order with invalid signature was placed")
+	}
+}
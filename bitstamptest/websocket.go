@@ -0,0 +1,170 @@
+package bitstamptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+type wsConn struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+	subsMu  sync.Mutex
+	subs    map[string]struct{}
+}
+
+type wsRequest struct {
+	Event string `json:"event"`
+	Data  struct {
+		Channel string `json:"channel"`
+		Auth    string `json:"auth"`
+	} `json:"data"`
+}
+
+func (c *wsConn) send(channel string, name string, data interface{}) error {
+	msg, err := json.Marshal(map[string]interface{}{
+		"channel": channel,
+		"event":   name,
+		"data":    data,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return c.conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+func (c *wsConn) subscribed(channel string) bool {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+
+	_, ok := c.subs[channel]
+
+	return ok
+}
+
+func (s *Server) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	c := &wsConn{
+		conn: conn,
+		subs: make(map[string]struct{}),
+	}
+
+	s.connsMu.Lock()
+	s.conns[c] = struct{}{}
+	s.connsMu.Unlock()
+
+	defer func() {
+		s.connsMu.Lock()
+		delete(s.conns, c)
+		s.connsMu.Unlock()
+		_ = conn.Close()
+	}()
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req wsRequest
+		if err := json.Unmarshal(msg, &req); err != nil {
+			_ = c.send("", "bts:error", map[string]string{"message": "Bad request"})
+			continue
+		}
+
+		switch req.Event {
+		case "bts:subscribe":
+			if err := s.authorizeChannel(req.Data.Channel, req.Data.Auth); err != nil {
+				_ = c.send(req.Data.Channel, "bts:error", map[string]string{"message": err.Error()})
+				continue
+			}
+
+			c.subsMu.Lock()
+			c.subs[req.Data.Channel] = struct{}{}
+			c.subsMu.Unlock()
+
+			_ = c.send(req.Data.Channel, "bts:subscription_succeeded", map[string]string{})
+		case "bts:unsubscribe":
+			c.subsMu.Lock()
+			delete(c.subs, req.Data.Channel)
+			c.subsMu.Unlock()
+
+			_ = c.send(req.Data.Channel, "bts:unsubscription_succeeded", map[string]string{})
+		default:
+			_ = c.send("", "bts:error", map[string]string{"message": "Unknown event"})
+		}
+	}
+}
+
+// authorizeChannel приватные каналы требуют токен из websockets_token и id пользователя в имени
+func (s *Server) authorizeChannel(channel string, auth string) error {
+	if !strings.HasPrefix(channel, "private-") {
+		return nil
+	}
+
+	if !s.validToken(auth) {
+		return fmt.Errorf("invalid token")
+	}
+
+	if !strings.HasSuffix(channel, fmt.Sprintf("-%d", s.userID)) {
+		return fmt.Errorf("invalid user id")
+	}
+
+	return nil
+}
+
+// broadcast отправляет события подписчикам. Вызывается без s.mu
+func (s *Server) broadcast(events []event) {
+	if len(events) == 0 {
+		return
+	}
+
+	s.connsMu.Lock()
+	conns := make([]*wsConn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.connsMu.Unlock()
+
+	for _, e := range events {
+		for _, c := range conns {
+			if c.subscribed(e.channel) {
+				_ = c.send(e.channel, e.event, e.data)
+			}
+		}
+	}
+}
+
+// DropConnections разрывает все WebSocket соединения, клиенты должны переподключиться
+func (s *Server) DropConnections() {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	for c := range s.conns {
+		_ = c.conn.Close()
+	}
+}
+
+// Connections количество открытых WebSocket соединений
+func (s *Server) Connections() int {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	return len(s.conns)
+}
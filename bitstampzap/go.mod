@@ -0,0 +1,10 @@
+module github.com/b2broker/bitstamp/bitstampzap
+
+go 1.15
+
+require (
+	github.com/b2broker/bitstamp v0.0.0
+	go.uber.org/zap v1.21.0
+)
+
+replace github.com/b2broker/bitstamp => ../
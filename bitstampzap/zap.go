@@ -0,0 +1,29 @@
+// Package bitstampzap адаптер go.uber.org/zap для bitstamp.Logger
+package bitstampzap
+
+import (
+	"github.com/b2broker/bitstamp"
+	"go.uber.org/zap"
+)
+
+type logger struct {
+	logger *zap.Logger
+}
+
+// New создает bitstamp.Logger поверх *zap.Logger
+func New(l *zap.Logger) bitstamp.Logger {
+	return logger{logger: l}
+}
+
+func (l logger) WithField(key string, value interface{}) bitstamp.Logger {
+	return logger{logger: l.logger.With(zap.Any(key, value))}
+}
+
+func (l logger) WithError(err error) bitstamp.Logger {
+	return logger{logger: l.logger.With(zap.Error(err))}
+}
+
+func (l logger) Debug(msg string) { l.logger.Debug(msg) }
+func (l logger) Info(msg string)  { l.logger.Info(msg) }
+func (l logger) Warn(msg string)  { l.logger.Warn(msg) }
+func (l logger) Error(msg string) { l.logger.Error(msg) }
@@ -1,29 +1,43 @@
 package bitstamp
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/crxfoz/webclient"
-	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 )
 
 // From API docs: Should you receive the error response 'Order could not be placed' when trying to place an order, please retry order placement.
 
 var (
-	ErrStatus = errors.New("incorrect status code")
+	ErrStatus      = errors.New("incorrect status code")
+	ErrRateLimited = errors.New("rate limit exceeded")
+	// ErrEmptyResponse интерсептор вернул пустой ответ без ошибки
+	ErrEmptyResponse = errors.New("empty response")
 )
 
+const bitstampAPI = "https://www.bitstamp.net"
+
+// codeRateLimited код ошибки Bitstamp при превышении лимита запросов
+const codeRateLimited = "400.002"
+
+// codeOrderNotFound код ошибки Bitstamp для неизвестного ордера
+const codeOrderNotFound = "404"
+
 type ErrorResult struct {
 	Status string      `json:"status"`
 	Reason interface{} `json:"reason"`
 	Code   string      `json:"code"`
+	// HTTPStatus статус ответа, 0 если ошибка получена не из HTTP ответа
+	HTTPStatus int `json:"-"`
 }
 
 func (er ErrorResult) Error() string {
@@ -31,30 +45,170 @@ func (er ErrorResult) Error() string {
 }
 
 type PrivateClient struct {
-	APIKey    string
-	SecretKey string
-	client    *webclient.Webclient
+	signer  Signer
+	client  *webclient.Webclient
+	logger  Logger
+	metrics Metrics
+	tracer  Tracer
+
+	interceptors []Interceptor
+	handler      Handler
+
+	clock *clock
+	nonce NonceFunc
+
+	clientOrderIDs ClientOrderIDFunc
+
+	baseURL string
+	host    string
+}
+
+// ClientOption настройка PrivateClient
+type ClientOption func(*PrivateClient)
+
+// WithClientLogger задает логгер. Секреты вырезаются из всех сообщений
+func WithClientLogger(logger Logger) ClientOption {
+	return func(pc *PrivateClient) {
+		pc.logger = logger
+	}
+}
+
+// WithClientMetrics задает хуки для сбора метрик
+func WithClientMetrics(metrics Metrics) ClientOption {
+	return func(pc *PrivateClient) {
+		pc.metrics = metrics
+	}
+}
+
+// WithClientTracer задает трассировку запросов
+func WithClientTracer(tracer Tracer) ClientOption {
+	return func(pc *PrivateClient) {
+		pc.tracer = tracer
+	}
+}
+
+// WithInterceptors добавляет интерсепторы запросов. Первый интерсептор - внешний
+func WithInterceptors(interceptors ...Interceptor) ClientOption {
+	return func(pc *PrivateClient) {
+		pc.interceptors = append(pc.interceptors, interceptors...)
+	}
 }
 
-func NewPrivateClient(apiKey string, secretKey string) *PrivateClient {
-	return &PrivateClient{
-		APIKey:    apiKey,
-		SecretKey: secretKey,
+// WithNonceGenerator задает генератор X-Auth-Nonce, например детерминированный для тестов
+func WithNonceGenerator(nonce NonceFunc) ClientOption {
+	return func(pc *PrivateClient) {
+		pc.nonce = nonce
+	}
+}
+
+// WithClientOrderIDs задает генератор client_order_id для ордеров без него вместо ClientOrderIDGenerator
+// без тега, который клиент использует по умолчанию. nil отключает генерацию.
+// Bitstamp не проверяет уникальность client_order_id: повтор запроса может выставить второй ордер
+func WithClientOrderIDs(generate ClientOrderIDFunc) ClientOption {
+	return func(pc *PrivateClient) {
+		pc.clientOrderIDs = generate
+	}
+}
+
+// WithClock задает источник локального времени. Поправка на часы Bitstamp применяется поверх него
+func WithClock(now func() time.Time) ClientOption {
+	return func(pc *PrivateClient) {
+		pc.clock.now = now
+	}
+}
+
+// WithBaseURL задает адрес API, например фейкового сервера из bitstamptest
+func WithBaseURL(baseURL string) ClientOption {
+	return func(pc *PrivateClient) {
+		pc.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// NewPrivateClient создает клиент с секретом в памяти процесса
+func NewPrivateClient(apiKey string, secretKey string, opts ...ClientOption) *PrivateClient {
+	return NewPrivateClientWithSigner(NewHMACSigner(apiKey, secretKey), opts...)
+}
+
+// NewPrivateClientWithSigner создает клиент, который подписывает запросы через signer
+func NewPrivateClientWithSigner(signer Signer, opts ...ClientOption) *PrivateClient {
+	pc := &PrivateClient{
+		signer: signer,
 		client: webclient.Config{
 			Timeout:        time.Second * 10,
 			UseKeepAlive:   false,
 			FollowRedirect: false,
 		}.New(),
+		logger:  NewLogrusLogger(logrus.WithField("provider", "bitstamp").WithField("module", "private")),
+		metrics: nopMetrics{},
+		tracer:  nopTracer{},
+		clock:   &clock{now: time.Now},
+		nonce:   UUIDNonce,
+		baseURL: bitstampAPI,
+	}
+
+	// без client_order_id ордер нельзя найти после потерянного ответа
+	generator, generatorErr := NewClientOrderIDGenerator("")
+	if generatorErr == nil {
+		pc.clientOrderIDs = generator.Next
+	}
+
+	for _, opt := range opts {
+		opt(pc)
+	}
+
+	// host входит в подписываемое сообщение
+	pc.host = strings.TrimPrefix(strings.TrimPrefix(pc.baseURL, "https://"), "http://")
+
+	secrets.add(signer.APIKey())
+	pc.logger = newRedactingLogger(pc.logger)
+	pc.handler = chain(pc.interceptors, pc.send)
+
+	if generatorErr != nil && pc.clientOrderIDs == nil {
+		pc.logger.WithError(generatorErr).Warn("could not create client order id generator, orders without client order id are sent as is")
 	}
+
+	return pc
 }
 
 func (pc *PrivateClient) privateRequest(path string, params map[string]string) (string, error) {
-	ts := time.Now().Add(time.Second * 10).Unix()
-	nonce, err := uuid.NewUUID()
+	span := pc.tracer.StartRequest(path, symbolFromPath(path), params["client_order_id"])
+
+	resp, err := pc.handler(&Request{Path: path, Params: params})
+
+	status := "ok"
+
+	var apiErr ErrorResult
+	if errors.As(err, &apiErr) {
+		status = apiErr.Code
+	} else if err != nil {
+		status = "error"
+	}
+
+	span.End(status, err)
+
 	if err != nil {
 		return "", err
 	}
 
+	if resp == nil {
+		return "", ErrEmptyResponse
+	}
+
+	return resp.Body, nil
+}
+
+// send подписывает и отправляет запрос. Последний Handler в цепочке интерсепторов
+func (pc *PrivateClient) send(r *Request) (*Response, error) {
+	path := r.Path
+	params := r.Params
+	apiKey := pc.signer.APIKey()
+
+	ts := pc.clock.Now().Add(time.Second * 10).Unix()
+	nonce, err := pc.nonce()
+	if err != nil {
+		return nil, err
+	}
+
 	// line from bitstamp API official docs
 	// it doesn't work without this line and with UnixNano
 	ts *= 1000
@@ -74,48 +228,108 @@ func (pc *PrivateClient) privateRequest(path string, params map[string]string) (
 	// Bitstamp API v2 auth method: https://www.bitstamp.net/api/
 	msg := fmt.Sprintf("BITSTAMP %s"+
 		"POST"+
-		"www.bitstamp.net"+
+		"%s"+
 		"%s"+
 		"%s"+
 		"%s"+
 		"%d"+
 		"v2"+
-		"%s", pc.APIKey, path, contentType, nonce, ts, values.Encode())
+		"%s", apiKey, pc.host, path, contentType, nonce, ts, values.Encode())
 
-	h := hmac.New(sha256.New, []byte(pc.SecretKey))
-	if _, err := h.Write([]byte(msg)); err != nil {
-		return "", err
+	sign, err := pc.signer.Sign([]byte(msg))
+	if err != nil {
+		return nil, fmt.Errorf("could not sign request: %w", err)
 	}
 
-	sign := h.Sum(nil)
-
 	headers := map[string]string{
-		"X-Auth":           fmt.Sprintf("BITSTAMP %s", pc.APIKey),
+		"X-Auth":           fmt.Sprintf("BITSTAMP %s", apiKey),
 		"X-Auth-Signature": hex.EncodeToString(sign),
-		"X-Auth-Nonce":     nonce.String(),
+		"X-Auth-Nonce":     nonce,
 		"X-Auth-Timestamp": fmt.Sprintf("%d", ts),
 		"X-Auth-Version":   "v2",
 	}
 
-	req := pc.client.Post(fmt.Sprintf("https://www.bitstamp.net%s", path)).SetHeaders(headers)
+	req := pc.client.Post(pc.baseURL + path).SetHeaders(headers)
 
 	for k, v := range params {
 		req.SendParam(k, v)
 	}
 
-	_, body, err := req.Do()
+	pc.logger.WithField("path", path).Debug("sending request")
+
+	start := time.Now()
+	sentAt := pc.clock.now()
+
+	resp, err := pc.do(path, req)
+	pc.metrics.ObserveRequest(path, time.Since(start), err)
+
+	if resp != nil {
+		pc.clock.observe(resp.Header, sentAt, pc.clock.now())
+	}
+
+	var apiErr ErrorResult
+	if errors.As(err, &apiErr) && isTimestampError(apiErr) {
+		err = TimestampError{ErrorResult: apiErr, Offset: pc.clock.Offset()}
+	}
+
+	return resp, err
+}
+
+// do выполняет запрос. Для ошибок Bitstamp возвращается и ответ, и ErrorResult
+func (pc *PrivateClient) do(path string, req *webclient.Request) (*Response, error) {
+	httpResp, body, err := req.Do()
 	if err != nil {
-		return "", err
+		pc.logger.WithError(err).WithField("path", path).Error("request failed")
+		return nil, err
+	}
+
+	resp := &Response{
+		StatusCode: httpResp.StatusCode,
+		Header:     httpResp.Header,
+		Body:       body,
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		pc.metrics.IncRateLimited(path)
+		return resp, ErrRateLimited
 	}
 
 	// parsing errors
 	var errBody ErrorResult
 
 	if err := json.Unmarshal([]byte(body), &errBody); err == nil && errBody.Status == "error" {
-		return "", errBody
+		pc.logger.WithError(errBody).WithField("path", path).WithField("code", errBody.Code).Warn("api error")
+		pc.metrics.IncAPIError(path, errBody.Code)
+
+		if errBody.Code == codeRateLimited {
+			pc.metrics.IncRateLimited(path)
+		}
+
+		errBody.HTTPStatus = resp.StatusCode
+
+		return resp, errBody
+	}
+
+	return resp, nil
+}
+
+// ClockOffset возвращает смещение часов Bitstamp относительно локальных, которое применяется к X-Auth-Timestamp
+func (pc *PrivateClient) ClockOffset() time.Duration {
+	return pc.clock.Offset()
+}
+
+// SyncClock измеряет смещение часов по публичному API, не дожидаясь приватного запроса
+func (pc *PrivateClient) SyncClock() (time.Duration, error) {
+	sentAt := pc.clock.now()
+
+	resp, _, err := pc.client.Get(pc.baseURL + "/api/v2/ticker/btcusd/").Do()
+	if err != nil {
+		return 0, err
 	}
 
-	return body, nil
+	pc.clock.observe(resp.Header, sentAt, pc.clock.now())
+
+	return pc.clock.Offset(), nil
 }
 
 func (pc *PrivateClient) GetBalances() (BalanceResult, error) {
@@ -133,6 +347,22 @@ func (pc *PrivateClient) GetBalances() (BalanceResult, error) {
 	return balances, nil
 }
 
+// GetAccountBalances возвращает общий, доступный и зарезервированный баланс и комиссию за вывод по валютам
+func (pc *PrivateClient) GetAccountBalances() (Balances, error) {
+	resp, err := pc.privateRequest("/api/v2/balance/", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var balances Balances
+
+	if err := json.Unmarshal([]byte(resp), &balances); err != nil {
+		return nil, err
+	}
+
+	return balances, nil
+}
+
 func (pc *PrivateClient) GetTransactions() ([]TransactionResult, error) {
 	resp, err := pc.privateRequest("/api/v2/user_transactions/", nil)
 	if err != nil {
@@ -148,6 +378,39 @@ func (pc *PrivateClient) GetTransactions() ([]TransactionResult, error) {
 	return transactions, nil
 }
 
+// transactionsPageLimit максимальный limit user_transactions
+const transactionsPageLimit = 1000
+
+// GetPairTransactions возвращает транзакции пользователя по паре начиная с момента since в порядке возрастания.
+// Страницы запрашиваются, пока Bitstamp не вернет меньше transactionsPageLimit транзакций
+func (pc *PrivateClient) GetPairTransactions(pair string, since time.Time) ([]TransactionResult, error) {
+	var result []TransactionResult
+
+	for offset := 0; ; offset += transactionsPageLimit {
+		resp, err := pc.privateRequest(fmt.Sprintf("/api/v2/user_transactions/%s/", pair), map[string]string{
+			"since_timestamp": fmt.Sprintf("%d", since.Unix()),
+			"sort":            "asc",
+			"limit":           strconv.Itoa(transactionsPageLimit),
+			"offset":          strconv.Itoa(offset),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var transactions []TransactionResult
+
+		if err := json.Unmarshal([]byte(resp), &transactions); err != nil {
+			return nil, err
+		}
+
+		result = append(result, transactions...)
+
+		if len(transactions) < transactionsPageLimit {
+			return result, nil
+		}
+	}
+}
+
 func (pc *PrivateClient) GetOpenOrders() ([]OpenOrderResult, error) {
 	resp, err := pc.privateRequest("/api/v2/open_orders/all/", nil)
 	if err != nil {
@@ -178,8 +441,60 @@ func (pc *PrivateClient) GetOrderStatus(id string) (OrderStatusResult, error) {
 	return status, nil
 }
 
+// GetOrderStatusByClientOrderID возвращает статус ордера по client_order_id,
+// например чтобы узнать, был ли выставлен ордер, ответ на который потерян
+func (pc *PrivateClient) GetOrderStatusByClientOrderID(clientOrderID string) (OrderStatusResult, error) {
+	resp, err := pc.privateRequest("/api/v2/order_status/", map[string]string{"client_order_id": clientOrderID})
+	if err != nil {
+		return OrderStatusResult{}, err
+	}
+
+	var status OrderStatusResult
+
+	if err := json.Unmarshal([]byte(resp), &status); err != nil {
+		return OrderStatusResult{}, err
+	}
+
+	return status, nil
+}
+
+// IsOrderNotFound ошибка Bitstamp о том, что ордер не найден
+func IsOrderNotFound(err error) bool {
+	var apiErr ErrorResult
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	return apiErr.Code == codeOrderNotFound || strings.Contains(strings.ToLower(fmt.Sprint(apiErr.Reason)), "not found")
+}
+
+// IsRejected ошибка означает, что Bitstamp точно не выполнил запрос: ошибка API с HTTP статусом
+// ниже 500 или превышение лимита запросов. После остальных ошибок результат запроса неизвестен
+func IsRejected(err error) bool {
+	if errors.Is(err, ErrRateLimited) {
+		return true
+	}
+
+	var apiErr ErrorResult
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	return apiErr.HTTPStatus < http.StatusInternalServerError
+}
+
 func (pc *PrivateClient) CancelOrder(id string) (OrderCancelResult, error) {
-	resp, err := pc.privateRequest("/api/v2/cancel_order/", map[string]string{"id": id})
+	return pc.cancelOrder(map[string]string{"id": id})
+}
+
+// CancelOrderByClientOrderID отменяет ордер по client_order_id, например ордер, ответ на выставление
+// которого потерялся
+func (pc *PrivateClient) CancelOrderByClientOrderID(clientOrderID string) (OrderCancelResult, error) {
+	return pc.cancelOrder(map[string]string{"client_order_id": clientOrderID})
+}
+
+func (pc *PrivateClient) cancelOrder(values map[string]string) (OrderCancelResult, error) {
+	resp, err := pc.privateRequest("/api/v2/cancel_order/", values)
 	if err != nil {
 		return OrderCancelResult{}, err
 	}
@@ -285,31 +600,56 @@ func (pc *PrivateClient) marketOrder(order PlaceOrderRequest) (PlaceOrderResult,
 	return status, nil
 }
 
-func (pc *PrivateClient) PlaceOrder(opts PlaceOrderRequest) (PlaceOrderResult, error) {
+// validateOrder проверяет параметры ордера до отправки
+func validateOrder(opts PlaceOrderRequest) error {
 	if opts.Symbol == "" {
-		return PlaceOrderResult{}, fmt.Errorf("symbol isn't specified")
+		return fmt.Errorf("symbol isn't specified")
 	}
 
 	if opts.Amount <= 0 {
-		return PlaceOrderResult{}, fmt.Errorf("amount isn't specified")
+		return fmt.Errorf("amount isn't specified")
 	}
 
 	if opts.Side == "" {
-		return PlaceOrderResult{}, fmt.Errorf("side isn't specified")
+		return fmt.Errorf("side isn't specified")
 	}
 
 	switch opts.Type {
 	case Limit:
 		if opts.Price <= 0 {
-			return PlaceOrderResult{}, fmt.Errorf("price can't be 0 for limit orders")
+			return fmt.Errorf("price can't be 0 for limit orders")
 		}
-
-		return pc.limitOrder(opts)
 	case Market:
-		return pc.marketOrder(opts)
 	default:
-		return PlaceOrderResult{}, fmt.Errorf("order type isn't specified")
+		return fmt.Errorf("order type isn't specified")
 	}
+
+	return nil
+}
+
+func (pc *PrivateClient) PlaceOrder(opts PlaceOrderRequest) (PlaceOrderResult, error) {
+	if err := validateOrder(opts); err != nil {
+		return PlaceOrderResult{}, err
+	}
+
+	opts, err := withClientOrderID(opts, pc.clientOrderIDs)
+	if err != nil {
+		return PlaceOrderResult{}, err
+	}
+
+	var result PlaceOrderResult
+
+	if opts.Type == Limit {
+		result, err = pc.limitOrder(opts)
+	} else {
+		result, err = pc.marketOrder(opts)
+	}
+
+	if err == nil && result.ClientOrderID == "" {
+		result.ClientOrderID = opts.ClientOrderID
+	}
+
+	return result, err
 }
 
 func (pc *PrivateClient) GenerateWSToken() (*GenerateWSTokenResult, error) {
@@ -324,5 +664,7 @@ func (pc *PrivateClient) GenerateWSToken() (*GenerateWSTokenResult, error) {
 		return nil, err
 	}
 
+	secrets.add(result.Token)
+
 	return &result, nil
 }
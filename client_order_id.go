@@ -0,0 +1,245 @@
+package bitstamp
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrForeignClientOrderID client_order_id сгенерирован не ClientOrderIDGenerator
+var ErrForeignClientOrderID = errors.New("client order id wasn't generated by ClientOrderIDGenerator")
+
+const (
+	// MaxClientOrderIDLength ограничение Bitstamp на длину client_order_id
+	MaxClientOrderIDLength = 180
+	// MaxClientOrderIDTagLength ограничение на длину тега стратегии
+	MaxClientOrderIDTagLength = 32
+
+	clientOrderIDTimeWidth = 9 // миллисекунды в base36, хватает до 5188 года
+	clientOrderIDSeqWidth  = 6
+	clientOrderIDNodeWidth = 4
+	clientOrderIDSeqLimit  = 2176782336 // 36^6
+)
+
+// ClientOrderIDFunc генерирует client_order_id для ордеров без него
+type ClientOrderIDFunc func() (string, error)
+
+// ClientOrderIDInfo метаданные, закодированные в client_order_id
+type ClientOrderIDInfo struct {
+	At   time.Time
+	Seq  uint64
+	Node string
+	Tag  string
+}
+
+// ClientOrderIDGenerator генерирует client_order_id вида <время>-<номер>-<узел>-<тег>.
+// Время в миллисекундах и номер записаны в base36 фиксированной ширины, поэтому id одного
+// генератора упорядочены лексикографически. Узел случайный для каждого генератора и отличает
+// id разных процессов, выданные в одну миллисекунду
+type ClientOrderIDGenerator struct {
+	tag  string
+	node string
+	now  func() time.Time
+
+	mu   sync.Mutex
+	last int64
+	seq  uint64
+}
+
+// ClientOrderIDOption настройка ClientOrderIDGenerator
+type ClientOrderIDOption func(*ClientOrderIDGenerator)
+
+// WithClientOrderIDClock задает источник времени
+func WithClientOrderIDClock(now func() time.Time) ClientOrderIDOption {
+	return func(g *ClientOrderIDGenerator) {
+		g.now = now
+	}
+}
+
+// WithClientOrderIDNode задает узел вместо случайного, например детерминированный для тестов
+func WithClientOrderIDNode(node string) ClientOrderIDOption {
+	return func(g *ClientOrderIDGenerator) {
+		g.node = node
+	}
+}
+
+// NewClientOrderIDGenerator создает генератор с тегом стратегии. Тег может быть пустым
+// и состоит из латинских букв, цифр и '_'
+func NewClientOrderIDGenerator(tag string, opts ...ClientOrderIDOption) (*ClientOrderIDGenerator, error) {
+	if err := validateClientOrderIDTag(tag); err != nil {
+		return nil, err
+	}
+
+	g := &ClientOrderIDGenerator{
+		tag: tag,
+		now: time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	if g.node == "" {
+		node, err := randomBase36(clientOrderIDNodeWidth)
+		if err != nil {
+			return nil, fmt.Errorf("could not generate node: %w", err)
+		}
+
+		g.node = node
+	}
+
+	if len(g.node) != clientOrderIDNodeWidth || !isBase36(g.node) {
+		return nil, fmt.Errorf("node must be %d base36 characters: %q", clientOrderIDNodeWidth, g.node)
+	}
+
+	return g, nil
+}
+
+func validateClientOrderIDTag(tag string) error {
+	if len(tag) > MaxClientOrderIDTagLength {
+		return fmt.Errorf("tag is longer than %d characters: %q", MaxClientOrderIDTagLength, tag)
+	}
+
+	for _, r := range tag {
+		if r != '_' && !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') {
+			return fmt.Errorf("tag contains invalid character %q: %q", r, tag)
+		}
+	}
+
+	return nil
+}
+
+// Tag тег стратегии генератора
+func (g *ClientOrderIDGenerator) Tag() string {
+	return g.tag
+}
+
+// Next возвращает следующий id. Подходит как ClientOrderIDFunc: WithClientOrderIDs(gen.Next)
+func (g *ClientOrderIDGenerator) Next() (string, error) {
+	g.mu.Lock()
+
+	// время не идет назад, чтобы id оставались упорядоченными при коррекции часов
+	ms := g.now().UnixNano() / int64(time.Millisecond)
+	if ms < g.last {
+		ms = g.last
+	}
+
+	g.last = ms
+	seq := g.seq % clientOrderIDSeqLimit
+	g.seq++
+
+	g.mu.Unlock()
+
+	if ms < 0 {
+		return "", fmt.Errorf("time before unix epoch")
+	}
+
+	parts := []string{
+		padBase36(uint64(ms), clientOrderIDTimeWidth),
+		padBase36(seq, clientOrderIDSeqWidth),
+		g.node,
+	}
+
+	if g.tag != "" {
+		parts = append(parts, g.tag)
+	}
+
+	return strings.Join(parts, "-"), nil
+}
+
+// ParseClientOrderID извлекает метаданные из client_order_id, например из Fill.ClientOrderID.
+// Для id, выданных не ClientOrderIDGenerator, возвращает ErrForeignClientOrderID
+func ParseClientOrderID(id string) (ClientOrderIDInfo, error) {
+	parts := strings.SplitN(id, "-", 4)
+	if len(parts) < 3 ||
+		len(parts[0]) != clientOrderIDTimeWidth ||
+		len(parts[1]) != clientOrderIDSeqWidth ||
+		len(parts[2]) != clientOrderIDNodeWidth ||
+		!isBase36(parts[2]) {
+		return ClientOrderIDInfo{}, fmt.Errorf("%w: %q", ErrForeignClientOrderID, id)
+	}
+
+	ms, err := strconv.ParseUint(parts[0], 36, 64)
+	if err != nil {
+		return ClientOrderIDInfo{}, fmt.Errorf("%w: %q", ErrForeignClientOrderID, id)
+	}
+
+	seq, err := strconv.ParseUint(parts[1], 36, 64)
+	if err != nil {
+		return ClientOrderIDInfo{}, fmt.Errorf("%w: %q", ErrForeignClientOrderID, id)
+	}
+
+	info := ClientOrderIDInfo{
+		At:   time.Unix(0, int64(ms)*int64(time.Millisecond)).UTC(),
+		Seq:  seq,
+		Node: parts[2],
+	}
+
+	if len(parts) == 4 {
+		if parts[3] == "" || validateClientOrderIDTag(parts[3]) != nil {
+			return ClientOrderIDInfo{}, fmt.Errorf("%w: %q", ErrForeignClientOrderID, id)
+		}
+
+		info.Tag = parts[3]
+	}
+
+	return info, nil
+}
+
+func padBase36(n uint64, width int) string {
+	s := strconv.FormatUint(n, 36)
+	if len(s) < width {
+		s = strings.Repeat("0", width-len(s)) + s
+	}
+
+	return s
+}
+
+func isBase36(s string) bool {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func randomBase36(width int) (string, error) {
+	limit := big.NewInt(1)
+	for i := 0; i < width; i++ {
+		limit.Mul(limit, big.NewInt(36))
+	}
+
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+
+	return padBase36(n.Uint64(), width), nil
+}
+
+// withClientOrderID заполняет пустой ClientOrderID через generate
+func withClientOrderID(opts PlaceOrderRequest, generate ClientOrderIDFunc) (PlaceOrderRequest, error) {
+	if opts.ClientOrderID != "" || generate == nil {
+		return opts, nil
+	}
+
+	id, err := generate()
+	if err != nil {
+		return opts, fmt.Errorf("could not generate client order id: %w", err)
+	}
+
+	if len(id) > MaxClientOrderIDLength {
+		return opts, fmt.Errorf("client order id is longer than %d characters: %q", MaxClientOrderIDLength, id)
+	}
+
+	opts.ClientOrderID = id
+
+	return opts, nil
+}
@@ -0,0 +1,105 @@
+package bitstamp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrTimestampWindow = errors.New("request timestamp is outside of the allowed window")
+
+// timestampErrorCodes коды ошибок Bitstamp для X-Auth-Timestamp вне допустимого окна
+var timestampErrorCodes = map[string]struct{}{
+	"API0017": {},
+	"API0018": {},
+	"API0019": {},
+}
+
+// TimestampError Bitstamp отклонил запрос из-за расхождения часов.
+// errors.Is(err, ErrTimestampWindow) == true, errors.As(err, &ErrorResult{}) тоже работает
+type TimestampError struct {
+	ErrorResult
+	// Offset смещение часов сервера относительно локальных на момент ошибки
+	Offset time.Duration
+}
+
+func (e TimestampError) Error() string {
+	return fmt.Sprintf("%s (clock offset %s): %s", ErrTimestampWindow, e.Offset, e.ErrorResult.Error())
+}
+
+func (e TimestampError) Is(target error) bool {
+	return target == ErrTimestampWindow
+}
+
+func (e TimestampError) Unwrap() error {
+	return e.ErrorResult
+}
+
+func isTimestampError(er ErrorResult) bool {
+	if _, ok := timestampErrorCodes[er.Code]; ok {
+		return true
+	}
+
+	reason, ok := er.Reason.(string)
+
+	return ok && strings.Contains(strings.ToLower(reason), "timestamp")
+}
+
+// NonceFunc генерирует X-Auth-Nonce
+type NonceFunc func() (string, error)
+
+// UUIDNonce генератор nonce по умолчанию
+func UUIDNonce() (string, error) {
+	nonce, err := uuid.NewUUID()
+	if err != nil {
+		return "", err
+	}
+
+	return nonce.String(), nil
+}
+
+// skewTolerance смещения меньше этого значения не корректируются: Date заголовок имеет точность в секунду
+const skewTolerance = time.Second
+
+// clock локальные часы с поправкой на смещение часов Bitstamp
+type clock struct {
+	now    func() time.Time
+	offset int64
+}
+
+func (c *clock) Now() time.Time {
+	return c.now().Add(c.Offset())
+}
+
+func (c *clock) Offset() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.offset))
+}
+
+// observe обновляет смещение по заголовку Date ответа, отправленного между sentAt и receivedAt
+func (c *clock) observe(header http.Header, sentAt time.Time, receivedAt time.Time) {
+	date := header.Get("Date")
+	if date == "" {
+		return
+	}
+
+	serverTime, err := http.ParseTime(date)
+	if err != nil {
+		return
+	}
+
+	// Date округлен вниз до секунды, в среднем сервер ответил на полсекунды позже
+	serverTime = serverTime.Add(time.Second / 2)
+	local := sentAt.Add(receivedAt.Sub(sentAt) / 2)
+
+	offset := serverTime.Sub(local)
+	if offset < skewTolerance && offset > -skewTolerance {
+		offset = 0
+	}
+
+	atomic.StoreInt64(&c.offset, int64(offset))
+}
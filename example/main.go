@@ -10,7 +10,12 @@ import (
 func main() {
 	logrus.SetLevel(logrus.DebugLevel)
 
-	bsSvc := bitstamp.NewPrivateClient("_", "_")
+	ids, err := bitstamp.NewClientOrderIDGenerator("example")
+	if err != nil {
+		logrus.WithError(err).Fatal("could not create client order id generator")
+	}
+
+	bsSvc := bitstamp.NewPrivateClient("_", "_", bitstamp.WithClientOrderIDs(ids.Next))
 	wsClient := bitstamp.NewWSClient("btcusdt")
 
 	go func() {
@@ -0,0 +1,194 @@
+package bitstamp
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrFillsOverflow = errors.New("fills buffer overflow")
+
+// OverflowPolicy поведение при заполненном буфере трейдов
+type OverflowPolicy int
+
+const (
+	// OverflowBlock ждать, пока потребитель освободит место. Медленный потребитель блокирует чтение из WebSocket'a
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest выбросить самый старый трейд из буфера, количество выброшенных считается
+	OverflowDropOldest
+	// OverflowSpill складывать трейды в неограниченную очередь
+	OverflowSpill
+	// OverflowError вернуть ErrFillsOverflow
+	OverflowError
+)
+
+const defaultFillsBuffer = 256
+
+// fillQueue доставляет трейды в канал согласно OverflowPolicy.
+// push вызывается из одной горутины
+type fillQueue struct {
+	ch      chan Fill
+	policy  OverflowPolicy
+	dropped uint64
+
+	// sendMu не дает закрыть канал во время push
+	sendMu    sync.Mutex
+	closed    bool
+	closeOnce sync.Once
+	abortOnce sync.Once
+	// done закрывается при abort, прерывает заблокированный push
+	done chan struct{}
+
+	// используются только для OverflowSpill
+	mu      sync.Mutex
+	pending []Fill
+	closing bool
+	notify  chan struct{}
+}
+
+func newFillQueue(size int, policy OverflowPolicy) *fillQueue {
+	if size < 0 {
+		size = 0
+	}
+
+	q := &fillQueue{
+		ch:     make(chan Fill, size),
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+
+	if policy == OverflowSpill {
+		q.notify = make(chan struct{}, 1)
+		go q.pump()
+	}
+
+	return q
+}
+
+// push доставляет трейд. После close или abort трейды молча отбрасываются
+func (q *fillQueue) push(fill Fill) error {
+	q.sendMu.Lock()
+	defer q.sendMu.Unlock()
+
+	if q.closed {
+		return nil
+	}
+
+	switch q.policy {
+	case OverflowDropOldest:
+		for {
+			select {
+			case q.ch <- fill:
+				return nil
+			default:
+			}
+
+			select {
+			case <-q.ch:
+				atomic.AddUint64(&q.dropped, 1)
+			default:
+			}
+		}
+	case OverflowSpill:
+		q.mu.Lock()
+		q.pending = append(q.pending, fill)
+		q.mu.Unlock()
+		q.wake()
+		return nil
+	case OverflowError:
+		select {
+		case q.ch <- fill:
+			return nil
+		default:
+			return ErrFillsOverflow
+		}
+	default:
+		select {
+		case q.ch <- fill:
+		case <-q.done:
+		}
+		return nil
+	}
+}
+
+// pump перекладывает трейды из неограниченной очереди в канал
+func (q *fillQueue) pump() {
+	defer close(q.ch)
+
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			closing := q.closing
+			q.mu.Unlock()
+
+			if closing {
+				return
+			}
+
+			select {
+			case <-q.notify:
+			case <-q.done:
+				return
+			}
+			continue
+		}
+
+		fill := q.pending[0]
+		q.pending[0] = Fill{}
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+
+		select {
+		case q.ch <- fill:
+		case <-q.done:
+			return
+		}
+	}
+}
+
+func (q *fillQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// close закрывает канал после того, как все трейды из очереди будут доставлены
+func (q *fillQueue) close() {
+	q.closeOnce.Do(func() {
+		q.sendMu.Lock()
+		q.closed = true
+		q.sendMu.Unlock()
+
+		if q.policy != OverflowSpill {
+			close(q.ch)
+			return
+		}
+
+		q.mu.Lock()
+		q.closing = true
+		q.mu.Unlock()
+		q.wake()
+	})
+}
+
+// abort закрывает канал, не дожидаясь доставки. Заблокированный push сразу возвращается
+func (q *fillQueue) abort() {
+	q.abortOnce.Do(func() {
+		close(q.done)
+	})
+	q.close()
+}
+
+// droppedCount количество выброшенных трейдов
+func (q *fillQueue) droppedCount() uint64 {
+	return atomic.LoadUint64(&q.dropped)
+}
+
+// queued количество трейдов, ожидающих доставки
+func (q *fillQueue) queued() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.ch) + len(q.pending)
+}
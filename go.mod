@@ -4,9 +4,13 @@ go 1.15
 
 require (
 	github.com/crxfoz/webclient v0.0.0-20200120161203-c845891562fd
+	github.com/golang/mock v1.6.0
 	github.com/google/uuid v1.3.0
 	github.com/gorilla/websocket v1.5.0
+	github.com/kr/pretty v0.1.0 // indirect
+	github.com/kr/text v0.2.0 // indirect
 	github.com/sirupsen/logrus v1.9.0
+	github.com/stretchr/testify v1.8.1 // indirect
 	golang.org/x/sys v0.0.0-20220804182731-e052cef7d300 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
+	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 )
@@ -0,0 +1,535 @@
+package bitstamp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrJournalClosed запись в закрытый журнал
+var ErrJournalClosed = errors.New("journal closed")
+
+const (
+	journalPrefix         = "journal-"
+	journalSuffix         = ".log"
+	defaultJournalMaxSize = 16 << 20
+)
+
+// JournalOp операция с ордером в журнале
+type JournalOp string
+
+const (
+	JournalPlace  JournalOp = "place"
+	JournalCancel JournalOp = "cancel"
+)
+
+// JournalRecordKind намерение записывается до запроса, результат после
+type JournalRecordKind string
+
+const (
+	JournalIntent  JournalRecordKind = "intent"
+	JournalOutcome JournalRecordKind = "outcome"
+)
+
+// JournalRecord запись журнала. Результат ссылается на намерение через IntentSeq
+type JournalRecord struct {
+	Seq           uint64            `json:"seq"`
+	Kind          JournalRecordKind `json:"kind"`
+	Op            JournalOp         `json:"op"`
+	At            time.Time         `json:"at"`
+	ClientOrderID string            `json:"client_order_id,omitempty"`
+	OrderID       int64             `json:"order_id,omitempty"`
+	// Request параметры ордера для JournalPlace
+	Request *PlaceOrderRequest `json:"request,omitempty"`
+	// IntentSeq номер намерения, к которому относится результат
+	IntentSeq uint64 `json:"intent_seq,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Journal журнал упреждающей записи операций с ордерами. Каждая запись сбрасывается на диск (fsync)
+// до возврата. При превышении размера сегмента журнал переходит в новый сегмент, переносит в него
+// неразрешенные намерения и удаляет старые сегменты
+type Journal struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	segment int
+	seq     uint64
+	pending map[uint64]JournalRecord
+	closed  bool
+}
+
+// JournalOption настройка Journal
+type JournalOption func(*Journal)
+
+// WithJournalMaxSize задает размер сегмента, после которого журнал ротируется
+func WithJournalMaxSize(size int64) JournalOption {
+	return func(j *Journal) {
+		j.maxSize = size
+	}
+}
+
+// OpenJournal открывает журнал в каталоге dir, создавая его при необходимости.
+// Существующие сегменты читаются, неразрешенные намерения доступны через InDoubt
+func OpenJournal(dir string, opts ...JournalOption) (*Journal, error) {
+	j := &Journal{
+		dir:     dir,
+		maxSize: defaultJournalMaxSize,
+		pending: make(map[uint64]JournalRecord),
+	}
+
+	for _, opt := range opts {
+		opt(j)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	segments, err := j.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, segment := range segments {
+		if err := j.load(segment); err != nil {
+			return nil, err
+		}
+	}
+
+	// запись всегда идет в новый сегмент: хвост старого мог быть оборван при падении
+	if err := j.rotate(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// segments номера существующих сегментов по возрастанию
+func (j *Journal) segments() ([]int, error) {
+	entries, err := ioutil.ReadDir(j.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []int
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, journalPrefix) || !strings.HasSuffix(name, journalSuffix) {
+			continue
+		}
+
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, journalPrefix), journalSuffix))
+		if err != nil {
+			continue
+		}
+
+		result = append(result, n)
+	}
+
+	sort.Ints(result)
+
+	return result, nil
+}
+
+func (j *Journal) path(segment int) string {
+	return filepath.Join(j.dir, fmt.Sprintf("%s%06d%s", journalPrefix, segment, journalSuffix))
+}
+
+// load читает сегмент. Оборванная последняя строка пропускается
+func (j *Journal) load(segment int) error {
+	f, err := os.Open(j.path(segment))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var record JournalRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("corrupted journal segment %d: %w", segment, err)
+		}
+
+		j.apply(record)
+	}
+}
+
+// apply обновляет номер и неразрешенные намерения. Перенесенные при ротации намерения сохраняют свой номер
+func (j *Journal) apply(record JournalRecord) {
+	if record.Seq > j.seq {
+		j.seq = record.Seq
+	}
+
+	switch record.Kind {
+	case JournalIntent:
+		j.pending[record.Seq] = record
+	case JournalOutcome:
+		delete(j.pending, record.IntentSeq)
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+// rotate создает новый сегмент с неразрешенными намерениями и удаляет старые. Вызывается под j.mu
+func (j *Journal) rotate() error {
+	old, err := j.segments()
+	if err != nil {
+		return err
+	}
+
+	next := j.segment + 1
+	if len(old) > 0 {
+		next = maxInt(next, old[len(old)-1]+1)
+	}
+
+	f, err := os.OpenFile(j.path(next), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+
+	pending := make([]JournalRecord, 0, len(j.pending))
+	for _, record := range j.pending {
+		pending = append(pending, record)
+	}
+
+	sort.Slice(pending, func(a, b int) bool { return pending[a].Seq < pending[b].Seq })
+
+	for _, record := range pending {
+		n, err := writeRecord(f, record)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+
+		size += int64(n)
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := syncDir(j.dir); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if j.file != nil {
+		_ = j.file.Close()
+	}
+
+	j.file = f
+	j.size = size
+	j.segment = next
+
+	// старые сегменты удаляются только после того, как новый сброшен на диск
+	for _, segment := range old {
+		if err := os.Remove(j.path(segment)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return syncDir(j.dir)
+}
+
+func writeRecord(w io.Writer, record JournalRecord) (int, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return 0, err
+	}
+
+	return w.Write(append(data, '\n'))
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+func (j *Journal) append(record JournalRecord) (JournalRecord, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return JournalRecord{}, ErrJournalClosed
+	}
+
+	j.seq++
+	record.Seq = j.seq
+	record.At = time.Now().UTC()
+
+	n, err := writeRecord(j.file, record)
+	if err != nil {
+		return JournalRecord{}, err
+	}
+
+	if err := j.file.Sync(); err != nil {
+		return JournalRecord{}, err
+	}
+
+	j.size += int64(n)
+	j.apply(record)
+
+	if j.size >= j.maxSize {
+		if err := j.rotate(); err != nil {
+			return record, fmt.Errorf("could not rotate journal: %w", err)
+		}
+	}
+
+	return record, nil
+}
+
+// Intent записывает намерение до отправки запроса и возвращает его номер
+func (j *Journal) Intent(op JournalOp, clientOrderID string, orderID int64, req *PlaceOrderRequest) (uint64, error) {
+	record, err := j.append(JournalRecord{
+		Kind:          JournalIntent,
+		Op:            op,
+		ClientOrderID: clientOrderID,
+		OrderID:       orderID,
+		Request:       req,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return record.Seq, nil
+}
+
+// Outcome записывает результат запроса. orderID id ордера Bitstamp, если он известен
+func (j *Journal) Outcome(intentSeq uint64, orderID int64, opErr error) error {
+	j.mu.Lock()
+	intent, ok := j.pending[intentSeq]
+	j.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("unknown journal intent %d", intentSeq)
+	}
+
+	record := JournalRecord{
+		Kind:          JournalOutcome,
+		Op:            intent.Op,
+		ClientOrderID: intent.ClientOrderID,
+		OrderID:       orderID,
+		IntentSeq:     intentSeq,
+	}
+
+	if opErr != nil {
+		record.Error = opErr.Error()
+	}
+
+	_, err := j.append(record)
+
+	return err
+}
+
+// InDoubt возвращает намерения без результата в порядке записи
+func (j *Journal) InDoubt() []JournalRecord {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	result := make([]JournalRecord, 0, len(j.pending))
+	for _, record := range j.pending {
+		result = append(result, record)
+	}
+
+	sort.Slice(result, func(a, b int) bool { return result[a].Seq < result[b].Seq })
+
+	return result
+}
+
+// Close закрывает журнал
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return nil
+	}
+
+	j.closed = true
+
+	return j.file.Close()
+}
+
+// JournaledClient записывает в журнал намерение до и результат после каждого выставления и отмены ордера.
+// Выставление требует ClientOrderID, по нему ордер находится при восстановлении
+type JournaledClient struct {
+	TradingAPI
+	journal *Journal
+	logger  Logger
+}
+
+// NewJournaledClient оборачивает api журналом
+func NewJournaledClient(api TradingAPI, journal *Journal) *JournaledClient {
+	return &JournaledClient{
+		TradingAPI: api,
+		journal:    journal,
+		logger:     newRedactingLogger(NewLogrusLogger(logrus.WithField("provider", "bitstamp").WithField("module", "journal"))),
+	}
+}
+
+// PlaceOrder не отправляет ордер, если намерение не удалось записать. Результат записывается только
+// для успеха и IsRejected: после ошибки сети или 5xx ордер мог быть выставлен, намерение остается в InDoubt.
+// Ошибка записи результата только логируется: ордер останется в InDoubt и разрешится при восстановлении
+func (jc *JournaledClient) PlaceOrder(opts PlaceOrderRequest) (PlaceOrderResult, error) {
+	if opts.ClientOrderID == "" {
+		return PlaceOrderResult{}, ErrNoClientOrderID
+	}
+
+	seq, err := jc.journal.Intent(JournalPlace, opts.ClientOrderID, 0, &opts)
+	if err != nil {
+		return PlaceOrderResult{}, fmt.Errorf("could not write journal: %w", err)
+	}
+
+	result, err := jc.TradingAPI.PlaceOrder(opts)
+
+	jc.outcome(seq, result.ID, err, jc.logger.WithField("client_order_id", opts.ClientOrderID))
+
+	return result, err
+}
+
+// CancelOrder журналирует отмену так же, как PlaceOrder
+func (jc *JournaledClient) CancelOrder(id string) (OrderCancelResult, error) {
+	orderID, _ := strconv.ParseInt(id, 10, 64)
+
+	seq, err := jc.journal.Intent(JournalCancel, "", orderID, nil)
+	if err != nil {
+		return OrderCancelResult{}, fmt.Errorf("could not write journal: %w", err)
+	}
+
+	result, err := jc.TradingAPI.CancelOrder(id)
+
+	jc.outcome(seq, orderID, err, jc.logger.WithField("order", id))
+
+	return result, err
+}
+
+// CancelOrderByClientOrderID журналирует отмену так же, как CancelOrder
+func (jc *JournaledClient) CancelOrderByClientOrderID(clientOrderID string) (OrderCancelResult, error) {
+	seq, err := jc.journal.Intent(JournalCancel, clientOrderID, 0, nil)
+	if err != nil {
+		return OrderCancelResult{}, fmt.Errorf("could not write journal: %w", err)
+	}
+
+	result, err := jc.TradingAPI.CancelOrderByClientOrderID(clientOrderID)
+
+	orderID, _ := strconv.ParseInt(result.ID, 10, 64)
+	jc.outcome(seq, orderID, err, jc.logger.WithField("client_order_id", clientOrderID))
+
+	return result, err
+}
+
+// outcome записывает результат, если он известен
+func (jc *JournaledClient) outcome(seq uint64, orderID int64, opErr error, logger Logger) {
+	if opErr != nil && !IsRejected(opErr) {
+		logger.WithError(opErr).Warn("request result is unknown, intent left in doubt")
+		return
+	}
+
+	if err := jc.journal.Outcome(seq, orderID, opErr); err != nil {
+		logger.WithError(err).Error("could not write journal outcome")
+	}
+}
+
+// RecoveredOrder результат разрешения намерения из журнала
+type RecoveredOrder struct {
+	Intent JournalRecord
+	// Exists ордер найден на бирже, Status его текущий статус
+	Exists bool
+	Status OrderStatusResult
+}
+
+// RecoverJournal разрешает все намерения без результата: выставленные ордера ищутся по client_order_id,
+// отмены по id. Разрешенные намерения закрываются записью результата. Намерения, которые не удалось
+// разрешить из-за ошибки, остаются в журнале до следующего вызова
+func RecoverJournal(journal *Journal, api TradingAPI) ([]RecoveredOrder, error) {
+	var (
+		result []RecoveredOrder
+		errs   []string
+	)
+
+	for _, intent := range journal.InDoubt() {
+		var (
+			status OrderStatusResult
+			err    error
+		)
+
+		switch {
+		case intent.ClientOrderID != "":
+			status, err = api.GetOrderStatusByClientOrderID(intent.ClientOrderID)
+		case intent.OrderID != 0:
+			status, err = api.GetOrderStatus(strconv.FormatInt(intent.OrderID, 10))
+		default:
+			err = ErrOrderNotTracked
+		}
+
+		recovered := RecoveredOrder{Intent: intent}
+
+		switch {
+		case err == nil:
+			recovered.Exists = true
+			recovered.Status = status
+		case IsOrderNotFound(err) || errors.Is(err, ErrOrderNotTracked):
+		default:
+			errs = append(errs, fmt.Sprintf("intent %d: %v", intent.Seq, err))
+			continue
+		}
+
+		outcomeErr := errors.New("recovered: order not found")
+		if recovered.Exists {
+			outcomeErr = nil
+		}
+
+		if err := journal.Outcome(intent.Seq, status.ID, outcomeErr); err != nil {
+			return result, err
+		}
+
+		result = append(result, recovered)
+	}
+
+	if len(errs) > 0 {
+		return result, fmt.Errorf("could not recover journal: %s", strings.Join(errs, "; "))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,320 @@
+package bitstamp_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/b2broker/bitstamp"
+	"github.com/b2broker/bitstamp/bitstamptest"
+)
+
+// lastSegment путь к последнему сегменту журнала
+func lastSegment(t *testing.T, dir string) string {
+	t.Helper()
+
+	segments, err := filepath.Glob(filepath.Join(dir, "journal-*.log"))
+	if err != nil || len(segments) == 0 {
+		t.Fatalf("no journal segments: %v", err)
+	}
+
+	sort.Strings(segments)
+
+	return segments[len(segments)-1]
+}
+
+// appendRaw дописывает байты в конец последнего сегмента, как при падении посреди записи
+func appendRaw(t *testing.T, dir string, data string) {
+	t.Helper()
+
+	f, err := os.OpenFile(lastSegment(t, dir), os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func placeRequest(clientOrderID string) *bitstamp.PlaceOrderRequest {
+	return &bitstamp.PlaceOrderRequest{
+		Symbol:        "btcusd",
+		Side:          bitstamp.Buy,
+		Type:          bitstamp.Limit,
+		Price:         19000,
+		Amount:        0.01,
+		ClientOrderID: clientOrderID,
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []bitstamp.JournalOption
+		// write пишет в журнал до падения. Журнал не закрывается
+		write func(t *testing.T, j *bitstamp.Journal, dir string)
+		// inDoubt ClientOrderID неразрешенных намерений после повторного открытия
+		inDoubt []string
+		// corrupted повторное открытие должно завершиться ошибкой
+		corrupted bool
+	}{
+		{
+			name: "empty",
+			write: func(t *testing.T, j *bitstamp.Journal, dir string) {
+			},
+		},
+		{
+			name: "intent without outcome",
+			write: func(t *testing.T, j *bitstamp.Journal, dir string) {
+				if _, err := j.Intent(bitstamp.JournalPlace, "j-1", 0, placeRequest("j-1")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			inDoubt: []string{"j-1"},
+		},
+		{
+			name: "resolved intent",
+			write: func(t *testing.T, j *bitstamp.Journal, dir string) {
+				seq, err := j.Intent(bitstamp.JournalPlace, "j-1", 0, placeRequest("j-1"))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err := j.Outcome(seq, 1001, nil); err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := j.Intent(bitstamp.JournalPlace, "j-2", 0, placeRequest("j-2")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			inDoubt: []string{"j-2"},
+		},
+		{
+			name: "truncated tail",
+			write: func(t *testing.T, j *bitstamp.Journal, dir string) {
+				if _, err := j.Intent(bitstamp.JournalPlace, "j-1", 0, placeRequest("j-1")); err != nil {
+					t.Fatal(err)
+				}
+
+				appendRaw(t, dir, `{"seq":2,"kind":"outcome","op":"place","intent_se`)
+			},
+			inDoubt: []string{"j-1"},
+		},
+		{
+			name: "corrupted record before tail",
+			write: func(t *testing.T, j *bitstamp.Journal, dir string) {
+				appendRaw(t, dir, "{\"seq\":1,\"kind\n")
+			},
+			corrupted: true,
+		},
+		{
+			name: "rotated segments",
+			opts: []bitstamp.JournalOption{bitstamp.WithJournalMaxSize(1)},
+			write: func(t *testing.T, j *bitstamp.Journal, dir string) {
+				for _, clientOrderID := range []string{"j-1", "j-2", "j-3"} {
+					seq, err := j.Intent(bitstamp.JournalPlace, clientOrderID, 0, placeRequest(clientOrderID))
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if clientOrderID == "j-2" {
+						if err := j.Outcome(seq, 1002, nil); err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+			},
+			inDoubt: []string{"j-1", "j-3"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "journal")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			crashed, err := bitstamp.OpenJournal(dir, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.write(t, crashed, dir)
+
+			j, err := bitstamp.OpenJournal(dir, tt.opts...)
+			if tt.corrupted {
+				if err == nil {
+					_ = j.Close()
+					t.Fatal("expected corrupted journal error")
+				}
+
+				_ = crashed.Close()
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer j.Close()
+
+			// процесс, который писал журнал, уже не работает
+			_ = crashed.Close()
+
+			inDoubt := j.InDoubt()
+			if len(inDoubt) != len(tt.inDoubt) {
+				t.Fatalf("in doubt %+v, want %v", inDoubt, tt.inDoubt)
+			}
+
+			var last uint64
+
+			for i, record := range inDoubt {
+				if record.ClientOrderID != tt.inDoubt[i] || record.Request == nil || record.Request.ClientOrderID != tt.inDoubt[i] {
+					t.Fatalf("in doubt %d = %+v, want %s", i, record, tt.inDoubt[i])
+				}
+
+				last = record.Seq
+			}
+
+			// журнал продолжает нумерацию и переживает следующее падение
+			seq, err := j.Intent(bitstamp.JournalCancel, "", 1001, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if seq <= last {
+				t.Fatalf("seq %d after replay, want > %d", seq, last)
+			}
+
+			reopened, err := bitstamp.OpenJournal(dir, tt.opts...)
+			if err != nil {
+				t.Fatalf("reopen after replay: %v", err)
+			}
+			defer reopened.Close()
+
+			if got := len(reopened.InDoubt()); got != len(tt.inDoubt)+1 {
+				t.Fatalf("in doubt after reopen = %d, want %d", got, len(tt.inDoubt)+1)
+			}
+		})
+	}
+}
+
+func TestRecoverJournal(t *testing.T) {
+	tests := []struct {
+		name string
+		// crash выставляет ордер через JournaledClient так, что результат остается неизвестным
+		crash  func(t *testing.T, srv *bitstamptest.Server, jc *bitstamp.JournaledClient)
+		exists bool
+	}{
+		{
+			name: "order wasn't placed",
+			crash: func(t *testing.T, srv *bitstamptest.Server, jc *bitstamp.JournaledClient) {
+				srv.InjectFault(bitstamptest.Fault{Path: "/api/v2/buy/btcusd/", Status: http.StatusBadGateway, Code: "502", Reason: "Bad gateway"})
+
+				if _, err := jc.PlaceOrder(*placeRequest("rj-1")); err == nil {
+					t.Fatal("expected place error")
+				}
+			},
+		},
+		{
+			name: "order was placed, response lost",
+			crash: func(t *testing.T, srv *bitstamptest.Server, jc *bitstamp.JournaledClient) {
+				srv.InjectFault(bitstamptest.Fault{Path: "/api/v2/buy/btcusd/", Status: http.StatusBadGateway, Code: "502", Reason: "Bad gateway"})
+
+				if _, err := jc.PlaceOrder(*placeRequest("rj-1")); err == nil {
+					t.Fatal("expected place error")
+				}
+
+				// запрос дошел до биржи, потерялся только ответ
+				if _, err := srv.NewClient().PlaceOrder(*placeRequest("rj-1")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			exists: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			srv := bitstamptest.NewServer("key", "secret")
+			defer srv.Close()
+
+			srv.SetBalance("usd", 1000)
+
+			dir, err := ioutil.TempDir("", "journal")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			crashed, err := bitstamp.OpenJournal(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.crash(t, srv, bitstamp.NewJournaledClient(srv.NewClient(), crashed))
+			_ = crashed.Close()
+
+			j, err := bitstamp.OpenJournal(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer j.Close()
+
+			if got := len(j.InDoubt()); got != 1 {
+				t.Fatalf("in doubt = %d, want 1", got)
+			}
+
+			recovered, err := bitstamp.RecoverJournal(j, srv.NewClient())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(recovered) != 1 || recovered[0].Exists != tt.exists {
+				t.Fatalf("recovered %+v, want exists %v", recovered, tt.exists)
+			}
+
+			if tt.exists && recovered[0].Status.Status != bitstamp.OrderStatusOpen {
+				t.Fatalf("recovered status = %s, want Open", recovered[0].Status.Status)
+			}
+
+			if got := len(j.InDoubt()); got != 0 {
+				t.Fatalf("in doubt after recovery = %d, want 0", got)
+			}
+		})
+	}
+}
+
+func TestJournalClosed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	j, err := bitstamp.OpenJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := j.Intent(bitstamp.JournalPlace, "j-1", 0, placeRequest("j-1")); !errors.Is(err, bitstamp.ErrJournalClosed) {
+		t.Fatalf("intent after close: %v, want ErrJournalClosed", err)
+	}
+}
@@ -0,0 +1,327 @@
+package bitstamp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrKillSwitchEngaged выставление ордеров заблокировано KillSwitch
+	ErrKillSwitchEngaged = errors.New("kill switch engaged")
+	// ErrKillSwitchStopped RunDeadMan завершился после Stop()
+	ErrKillSwitchStopped = errors.New("kill switch stopped")
+)
+
+// KillSwitchState состояние KillSwitch
+type KillSwitchState string
+
+const (
+	// KillSwitchArmed торговля разрешена
+	KillSwitchArmed KillSwitchState = "armed"
+	// KillSwitchEngaged ордера блокируются, открытые ордера отменяются
+	KillSwitchEngaged KillSwitchState = "engaged"
+	// KillSwitchFlat ордера блокируются, открытых ордеров не осталось
+	KillSwitchFlat KillSwitchState = "flat"
+)
+
+// KillSwitchEvent изменение состояния или неудачная попытка отмены
+type KillSwitchEvent struct {
+	State KillSwitchState
+	Prev  KillSwitchState
+	// Reason причина из Engage, для dead man's switch DeadManReason
+	Reason string
+	// Err ошибка попытки отмены, состояние при этом не меняется
+	Err error
+	At  time.Time
+}
+
+// DeadManReason причина срабатывания dead man's switch
+const DeadManReason = "dead man's switch: no heartbeat"
+
+// KillSwitch блокирует выставление ордеров и отменяет все открытые ордера по вызову Engage
+// или при отсутствии Heartbeat дольше таймаута RunDeadMan
+type KillSwitch struct {
+	TradingAPI
+	logger     Logger
+	now        func() time.Time
+	retryDelay time.Duration
+
+	// placeMu выставление держит RLock, Engage дожидается выставлений, начатых до блокировки
+	placeMu sync.RWMutex
+
+	mu        sync.Mutex
+	state     KillSwitchState
+	reason    string
+	heartbeat time.Time
+	// engageMu не дает двум Engage отменять ордера одновременно
+	engageMu sync.Mutex
+
+	handlersMu sync.Mutex
+	handlers   []func(KillSwitchEvent)
+
+	stopMu sync.Mutex
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// KillSwitchOption настройка KillSwitch
+type KillSwitchOption func(*KillSwitch)
+
+// WithKillSwitchLogger задает логгер
+func WithKillSwitchLogger(logger Logger) KillSwitchOption {
+	return func(ks *KillSwitch) {
+		ks.logger = logger
+	}
+}
+
+// WithKillSwitchClock задает источник времени
+func WithKillSwitchClock(now func() time.Time) KillSwitchOption {
+	return func(ks *KillSwitch) {
+		ks.now = now
+	}
+}
+
+// WithKillSwitchRetryDelay задает паузу между попытками отмены
+func WithKillSwitchRetryDelay(delay time.Duration) KillSwitchOption {
+	return func(ks *KillSwitch) {
+		ks.retryDelay = delay
+	}
+}
+
+// NewKillSwitch оборачивает api. Ордера нужно выставлять через KillSwitch, иначе они не блокируются
+func NewKillSwitch(api TradingAPI, opts ...KillSwitchOption) *KillSwitch {
+	ks := &KillSwitch{
+		TradingAPI: api,
+		logger:     NewLogrusLogger(logrus.WithField("provider", "bitstamp").WithField("module", "killswitch")),
+		now:        time.Now,
+		retryDelay: time.Second,
+		state:      KillSwitchArmed,
+		stop:       make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(ks)
+	}
+
+	ks.logger = newRedactingLogger(ks.logger)
+	ks.heartbeat = ks.now()
+
+	return ks
+}
+
+// OnStateChange добавляет обработчик изменений состояния и ошибок отмены
+func (ks *KillSwitch) OnStateChange(handler func(KillSwitchEvent)) {
+	ks.handlersMu.Lock()
+	defer ks.handlersMu.Unlock()
+
+	ks.handlers = append(ks.handlers, handler)
+}
+
+// State возвращает состояние и причину срабатывания
+func (ks *KillSwitch) State() (KillSwitchState, string) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	return ks.state, ks.reason
+}
+
+// PlaceOrder возвращает ErrKillSwitchEngaged, не отправляя ордер, после Engage и до Reset
+func (ks *KillSwitch) PlaceOrder(opts PlaceOrderRequest) (PlaceOrderResult, error) {
+	ks.placeMu.RLock()
+	defer ks.placeMu.RUnlock()
+
+	ks.mu.Lock()
+	state, reason := ks.state, ks.reason
+	ks.mu.Unlock()
+
+	if state != KillSwitchArmed {
+		return PlaceOrderResult{}, fmt.Errorf("%w: %s", ErrKillSwitchEngaged, reason)
+	}
+
+	return ks.TradingAPI.PlaceOrder(opts)
+}
+
+// Engage блокирует новые ордера и отменяет все открытые, повторяя CancelAllOrders, пока
+// GetOpenOrders не вернет пустой список. Возвращает ErrKillSwitchStopped, если до этого вызван Stop()
+func (ks *KillSwitch) Engage(reason string) error {
+	ks.mu.Lock()
+	prev := ks.state
+	if prev == KillSwitchArmed {
+		ks.state = KillSwitchEngaged
+		ks.reason = reason
+	}
+	ks.mu.Unlock()
+
+	if prev == KillSwitchArmed {
+		ks.logger.WithField("reason", reason).Error("kill switch engaged")
+		ks.notify(KillSwitchEvent{State: KillSwitchEngaged, Prev: prev, Reason: reason, At: ks.now()})
+	}
+
+	// выставления, начатые до блокировки, должны завершиться, иначе их ордер останется открытым
+	ks.placeMu.Lock()
+	ks.placeMu.Unlock() // nolint: staticcheck
+
+	ks.engageMu.Lock()
+	defer ks.engageMu.Unlock()
+
+	for {
+		err := ks.cancelAll()
+		if err == nil {
+			break
+		}
+
+		ks.logger.WithError(err).Error("could not cancel all orders")
+
+		state, reason := ks.State()
+		if state == KillSwitchArmed {
+			return nil
+		}
+
+		ks.notify(KillSwitchEvent{State: state, Prev: state, Reason: reason, Err: err, At: ks.now()})
+
+		select {
+		case <-time.After(ks.retryDelay):
+		case <-ks.stop:
+			return ErrKillSwitchStopped
+		}
+	}
+
+	ks.mu.Lock()
+	prev = ks.state
+	reason = ks.reason
+	if prev == KillSwitchEngaged {
+		ks.state = KillSwitchFlat
+	}
+	ks.mu.Unlock()
+
+	if prev == KillSwitchEngaged {
+		ks.logger.WithField("reason", reason).Warn("all orders cancelled")
+		ks.notify(KillSwitchEvent{State: KillSwitchFlat, Prev: prev, Reason: reason, At: ks.now()})
+	}
+
+	return nil
+}
+
+// cancelAll отменяет все ордера и проверяет, что открытых не осталось.
+// Оставшиеся ордера отменяются по одному
+func (ks *KillSwitch) cancelAll() error {
+	if _, err := ks.TradingAPI.CancelAllOrders(); err != nil {
+		return fmt.Errorf("could not cancel all orders: %w", err)
+	}
+
+	open, err := ks.TradingAPI.GetOpenOrders()
+	if err != nil {
+		return fmt.Errorf("could not get open orders: %w", err)
+	}
+
+	if len(open) == 0 {
+		return nil
+	}
+
+	for _, o := range open {
+		if _, err := ks.TradingAPI.CancelOrder(strconv.FormatInt(o.ID, 10)); err != nil && !IsOrderNotFound(err) {
+			ks.logger.WithError(err).WithField("order", o.ID).Error("could not cancel order")
+		}
+	}
+
+	return fmt.Errorf("%d orders still open", len(open))
+}
+
+// Reset снова разрешает выставление ордеров и сбрасывает таймер dead man's switch
+func (ks *KillSwitch) Reset() {
+	ks.mu.Lock()
+	prev := ks.state
+	ks.state = KillSwitchArmed
+	ks.reason = ""
+	ks.heartbeat = ks.now()
+	ks.mu.Unlock()
+
+	if prev != KillSwitchArmed {
+		ks.logger.Info("kill switch reset")
+		ks.notify(KillSwitchEvent{State: KillSwitchArmed, Prev: prev, At: ks.now()})
+	}
+}
+
+// Heartbeat сообщает dead man's switch, что приложение живо
+func (ks *KillSwitch) Heartbeat() {
+	ks.mu.Lock()
+	ks.heartbeat = ks.now()
+	ks.mu.Unlock()
+}
+
+// RunDeadMan вызывает Engage(DeadManReason), если Heartbeat не вызывался дольше timeout.
+// Работает до вызова Stop(). timeout должен быть положительным
+func (ks *KillSwitch) RunDeadMan(timeout time.Duration) error {
+	if timeout <= 0 {
+		return fmt.Errorf("dead man's switch timeout must be positive, got %v", timeout)
+	}
+
+	ks.stopMu.Lock()
+	select {
+	case <-ks.stop:
+		ks.stopMu.Unlock()
+		return ErrKillSwitchStopped
+	default:
+		ks.wg.Add(1)
+		defer ks.wg.Done()
+	}
+	ks.stopMu.Unlock()
+
+	ks.Heartbeat()
+
+	// проверка чаще таймаута, но не меньше 1ns: NewTicker паникует на нуле
+	interval := timeout / 4
+	if interval <= 0 {
+		interval = timeout
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ks.stop:
+			return ErrKillSwitchStopped
+		}
+
+		ks.mu.Lock()
+		expired := ks.state == KillSwitchArmed && ks.now().Sub(ks.heartbeat) > timeout
+		ks.mu.Unlock()
+
+		if !expired {
+			continue
+		}
+
+		if err := ks.Engage(DeadManReason); err != nil {
+			return err
+		}
+	}
+}
+
+// Stop останавливает RunDeadMan и повторы отмены в Engage
+func (ks *KillSwitch) Stop() {
+	ks.stopMu.Lock()
+	select {
+	case <-ks.stop:
+	default:
+		close(ks.stop)
+	}
+	ks.stopMu.Unlock()
+	ks.wg.Wait()
+}
+
+func (ks *KillSwitch) notify(event KillSwitchEvent) {
+	ks.handlersMu.Lock()
+	handlers := ks.handlers
+	ks.handlersMu.Unlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
@@ -0,0 +1,260 @@
+package bitstamp_test
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/b2broker/bitstamp"
+	"github.com/b2broker/bitstamp/bitstamptest"
+)
+
+// killSwitchEvents собирает события KillSwitch
+type killSwitchEvents struct {
+	mu     sync.Mutex
+	events []bitstamp.KillSwitchEvent
+}
+
+func (e *killSwitchEvents) handle(event bitstamp.KillSwitchEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.events = append(e.events, event)
+}
+
+// states состояния событий без ошибок и число событий с ошибками отмены
+func (e *killSwitchEvents) states() ([]bitstamp.KillSwitchState, int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var (
+		states []bitstamp.KillSwitchState
+		errs   int
+	)
+
+	for _, event := range e.events {
+		if event.Err != nil {
+			errs++
+			continue
+		}
+
+		states = append(states, event.State)
+	}
+
+	return states, errs
+}
+
+func openOrders(t *testing.T, srv *bitstamptest.Server) int {
+	t.Helper()
+
+	var n int
+
+	for _, o := range srv.Orders() {
+		if o.Status == bitstamp.OrderStatusOpen {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestKillSwitchEngage(t *testing.T) {
+	tests := []struct {
+		name string
+		// resting открытых ордеров до Engage
+		resting int
+		// cancelFaults сколько раз CancelAllOrders завершится ошибкой
+		cancelFaults int
+		states       []bitstamp.KillSwitchState
+		errs         int
+	}{
+		{
+			name:   "no open orders",
+			states: []bitstamp.KillSwitchState{bitstamp.KillSwitchEngaged, bitstamp.KillSwitchFlat},
+		},
+		{
+			name:    "open orders cancelled",
+			resting: 3,
+			states:  []bitstamp.KillSwitchState{bitstamp.KillSwitchEngaged, bitstamp.KillSwitchFlat},
+		},
+		{
+			name:         "cancel retried",
+			resting:      2,
+			cancelFaults: 2,
+			states:       []bitstamp.KillSwitchState{bitstamp.KillSwitchEngaged, bitstamp.KillSwitchFlat},
+			errs:         2,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			srv := bitstamptest.NewServer("key", "secret")
+			defer srv.Close()
+
+			srv.SetBalance("usd", 1000)
+
+			ks := bitstamp.NewKillSwitch(srv.NewClient(), bitstamp.WithKillSwitchRetryDelay(time.Millisecond))
+			defer ks.Stop()
+
+			events := &killSwitchEvents{}
+			ks.OnStateChange(events.handle)
+
+			for i := 0; i < tt.resting; i++ {
+				if _, err := ks.PlaceOrder(limitOrder(bitstamp.Buy, 18000+float64(i), 0.01)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.cancelFaults > 0 {
+				srv.InjectFault(bitstamptest.Fault{
+					Path:   "/api/v2/cancel_all_orders/",
+					Status: http.StatusInternalServerError,
+					Code:   "500",
+					Reason: "Internal error",
+					Times:  tt.cancelFaults,
+				})
+			}
+
+			if err := ks.Engage("test"); err != nil {
+				t.Fatal(err)
+			}
+
+			if state, reason := ks.State(); state != bitstamp.KillSwitchFlat || reason != "test" {
+				t.Fatalf("state = %s (%s), want flat", state, reason)
+			}
+
+			if n := openOrders(t, srv); n != 0 {
+				t.Fatalf("%d orders still open", n)
+			}
+
+			states, errs := events.states()
+			if len(states) != len(tt.states) || errs != tt.errs {
+				t.Fatalf("events %v with %d errors, want %v with %d errors", states, errs, tt.states, tt.errs)
+			}
+
+			for i := range states {
+				if states[i] != tt.states[i] {
+					t.Fatalf("events %v, want %v", states, tt.states)
+				}
+			}
+
+			sent := len(srv.Orders())
+
+			if _, err := ks.PlaceOrder(limitOrder(bitstamp.Buy, 18000, 0.01)); !errors.Is(err, bitstamp.ErrKillSwitchEngaged) {
+				t.Fatalf("place after engage: %v, want ErrKillSwitchEngaged", err)
+			}
+
+			if got := len(srv.Orders()); got != sent {
+				t.Fatal("blocked order reached the server")
+			}
+
+			ks.Reset()
+
+			if _, err := ks.PlaceOrder(limitOrder(bitstamp.Buy, 18000, 0.01)); err != nil {
+				t.Fatalf("place after reset: %v", err)
+			}
+		})
+	}
+}
+
+func TestKillSwitchStopInterruptsRetries(t *testing.T) {
+	srv := bitstamptest.NewServer("key", "secret")
+	defer srv.Close()
+
+	srv.InjectFault(bitstamptest.Fault{
+		Path:   "/api/v2/cancel_all_orders/",
+		Status: http.StatusInternalServerError,
+		Code:   "500",
+		Reason: "Internal error",
+		Times:  1000,
+	})
+
+	ks := bitstamp.NewKillSwitch(srv.NewClient(), bitstamp.WithKillSwitchRetryDelay(10*time.Millisecond))
+
+	result := make(chan error, 1)
+
+	go func() {
+		result <- ks.Engage("test")
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	ks.Stop()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, bitstamp.ErrKillSwitchStopped) {
+			t.Fatalf("engage = %v, want ErrKillSwitchStopped", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("engage isn't interrupted by Stop")
+	}
+
+	if state, _ := ks.State(); state != bitstamp.KillSwitchEngaged {
+		t.Fatalf("state = %s, want engaged", state)
+	}
+}
+
+func TestKillSwitchDeadMan(t *testing.T) {
+	srv := bitstamptest.NewServer("key", "secret")
+	defer srv.Close()
+
+	srv.SetBalance("usd", 1000)
+
+	ks := bitstamp.NewKillSwitch(srv.NewClient(), bitstamp.WithKillSwitchRetryDelay(time.Millisecond))
+
+	flat := make(chan bitstamp.KillSwitchEvent, 1)
+	ks.OnStateChange(func(event bitstamp.KillSwitchEvent) {
+		if event.State == bitstamp.KillSwitchFlat {
+			flat <- event
+		}
+	})
+
+	if _, err := ks.PlaceOrder(limitOrder(bitstamp.Buy, 18000, 0.01)); err != nil {
+		t.Fatal(err)
+	}
+
+	const timeout = 100 * time.Millisecond
+
+	result := make(chan error, 1)
+
+	go func() {
+		result <- ks.RunDeadMan(timeout)
+	}()
+
+	// пока есть Heartbeat, торговля разрешена
+	for i := 0; i < 10; i++ {
+		ks.Heartbeat()
+		time.Sleep(timeout / 4)
+	}
+
+	if state, _ := ks.State(); state != bitstamp.KillSwitchArmed {
+		t.Fatalf("state with heartbeats = %s, want armed", state)
+	}
+
+	select {
+	case event := <-flat:
+		if event.Reason != bitstamp.DeadManReason {
+			t.Fatalf("reason = %q, want %q", event.Reason, bitstamp.DeadManReason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dead man's switch didn't engage")
+	}
+
+	if n := openOrders(t, srv); n != 0 {
+		t.Fatalf("%d orders still open", n)
+	}
+
+	ks.Stop()
+
+	if err := <-result; !errors.Is(err, bitstamp.ErrKillSwitchStopped) {
+		t.Fatalf("RunDeadMan = %v, want ErrKillSwitchStopped", err)
+	}
+
+	if err := ks.RunDeadMan(timeout); !errors.Is(err, bitstamp.ErrKillSwitchStopped) {
+		t.Fatalf("RunDeadMan after Stop = %v, want ErrKillSwitchStopped", err)
+	}
+}
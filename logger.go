@@ -0,0 +1,170 @@
+package bitstamp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Logger минимальный интерфейс логгера библиотеки.
+// Адаптеры: NewLogrusLogger, NewSlogLogger, bitstampzap.New
+type Logger interface {
+	WithField(key string, value interface{}) Logger
+	WithError(err error) Logger
+	Debug(msg string)
+	Info(msg string)
+	Warn(msg string)
+	Error(msg string)
+}
+
+type logrusLogger struct {
+	entry *logrus.Entry
+}
+
+// NewLogrusLogger адаптер для logrus
+func NewLogrusLogger(entry *logrus.Entry) Logger {
+	return logrusLogger{entry: entry}
+}
+
+func (l logrusLogger) WithField(key string, value interface{}) Logger {
+	return logrusLogger{entry: l.entry.WithField(key, value)}
+}
+
+func (l logrusLogger) WithError(err error) Logger {
+	return logrusLogger{entry: l.entry.WithError(err)}
+}
+
+func (l logrusLogger) Debug(msg string) { l.entry.Debug(msg) }
+func (l logrusLogger) Info(msg string)  { l.entry.Info(msg) }
+func (l logrusLogger) Warn(msg string)  { l.entry.Warn(msg) }
+func (l logrusLogger) Error(msg string) { l.entry.Error(msg) }
+
+// NopLogger логгер, который ничего не пишет
+type NopLogger struct{}
+
+func (l NopLogger) WithField(string, interface{}) Logger { return l }
+func (l NopLogger) WithError(error) Logger               { return l }
+func (NopLogger) Debug(string)                           {}
+func (NopLogger) Info(string)                            {}
+func (NopLogger) Warn(string)                            {}
+func (NopLogger) Error(string)                           {}
+
+const redacted = "[REDACTED]"
+
+// minSecretLength более короткие значения не считаются секретами, иначе "_" из примеров вырезался бы отовсюду
+const minSecretLength = 8
+
+// redactSecretsLimit сколько последних секретов (API ключи, WS токены) помнит redactor
+const redactSecretsLimit = 256
+
+// sensitiveKeys поля, значения которых никогда не логируются
+var sensitiveKeys = []string{"secret", "signature", "token", "auth", "api_key", "apikey", "password"}
+
+// redactor вычищает секреты из сообщений и полей. Один на пакет,
+// чтобы секрет, известный PrivateClient, не попал в логи Websocket и наоборот
+type redactor struct {
+	mu      sync.RWMutex
+	secrets map[string]struct{}
+	order   []string
+}
+
+var secrets = &redactor{secrets: make(map[string]struct{})}
+
+// add запоминает секрет, который нужно вырезать из логов
+func (r *redactor) add(secret string) {
+	if len(secret) < minSecretLength {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.secrets[secret]; ok {
+		return
+	}
+
+	r.secrets[secret] = struct{}{}
+	r.order = append(r.order, secret)
+
+	if len(r.order) > redactSecretsLimit {
+		delete(r.secrets, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+func (r *redactor) string(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for secret := range r.secrets {
+		s = strings.Replace(s, secret, redacted, -1)
+	}
+
+	return s
+}
+
+func (r *redactor) value(key string, value interface{}) interface{} {
+	lowerKey := strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(lowerKey, sensitive) {
+			return redacted
+		}
+	}
+
+	switch vv := value.(type) {
+	case string:
+		return r.string(vv)
+	case []byte:
+		return r.string(string(vv))
+	case error:
+		return r.string(vv.Error())
+	case fmt.Stringer:
+		return r.string(vv.String())
+	case bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+		return value
+	default:
+		formatted := fmt.Sprintf("%+v", value)
+		if cleaned := r.string(formatted); cleaned != formatted {
+			return cleaned
+		}
+
+		return value
+	}
+}
+
+// redactingLogger оборачивает любой Logger и вырезает секреты
+type redactingLogger struct {
+	next Logger
+}
+
+func newRedactingLogger(next Logger) Logger {
+	if _, ok := next.(redactingLogger); ok {
+		return next
+	}
+
+	return redactingLogger{next: next}
+}
+
+func (l redactingLogger) WithField(key string, value interface{}) Logger {
+	return redactingLogger{next: l.next.WithField(key, secrets.value(key, value))}
+}
+
+func (l redactingLogger) WithError(err error) Logger {
+	if err == nil {
+		return l
+	}
+
+	if cleaned := secrets.string(err.Error()); cleaned != err.Error() {
+		err = errors.New(cleaned)
+	}
+
+	return redactingLogger{next: l.next.WithError(err)}
+}
+
+func (l redactingLogger) Debug(msg string) { l.next.Debug(secrets.string(msg)) }
+func (l redactingLogger) Info(msg string)  { l.next.Info(secrets.string(msg)) }
+func (l redactingLogger) Warn(msg string)  { l.next.Warn(secrets.string(msg)) }
+func (l redactingLogger) Error(msg string) { l.next.Error(secrets.string(msg)) }
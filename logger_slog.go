@@ -0,0 +1,31 @@
+//go:build go1.21
+// +build go1.21
+
+package bitstamp
+
+import (
+	"context"
+	"log/slog"
+)
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger адаптер для log/slog
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return slogLogger{logger: logger}
+}
+
+func (l slogLogger) WithField(key string, value interface{}) Logger {
+	return slogLogger{logger: l.logger.With(key, value)}
+}
+
+func (l slogLogger) WithError(err error) Logger {
+	return slogLogger{logger: l.logger.With("error", err)}
+}
+
+func (l slogLogger) Debug(msg string) { l.logger.Log(context.Background(), slog.LevelDebug, msg) }
+func (l slogLogger) Info(msg string)  { l.logger.Log(context.Background(), slog.LevelInfo, msg) }
+func (l slogLogger) Warn(msg string)  { l.logger.Log(context.Background(), slog.LevelWarn, msg) }
+func (l slogLogger) Error(msg string) { l.logger.Log(context.Background(), slog.LevelError, msg) }
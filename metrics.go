@@ -0,0 +1,38 @@
+package bitstamp
+
+import "time"
+
+// Metrics хуки для сбора метрик. Реализация для Prometheus: bitstampprom.New
+type Metrics interface {
+	// ObserveRequest время выполнения запроса к приватному REST API
+	ObserveRequest(endpoint string, duration time.Duration, err error)
+	// IncAPIError ошибка, которую вернул Bitstamp, по коду ошибки
+	IncAPIError(endpoint string, code string)
+	// IncRateLimited запрос отклонен из-за превышения лимита
+	IncRateLimited(endpoint string)
+	// IncReconnect переподключение Websocket
+	IncReconnect()
+	// IncMessage сообщение из WebSocket'a по типу события
+	IncMessage(event string)
+	// IncFillsEmitted трейд доставлен в Fills()
+	IncFillsEmitted(symbol string)
+	// AddFillsDropped трейды, выброшенные из Fills() политикой переполнения
+	AddFillsDropped(count uint64)
+	// SetFillsDepth количество трейдов, ожидающих вычитывания из Fills()
+	SetFillsDepth(depth int)
+	// ObservePingRTT время между отправкой ping и получением pong
+	ObservePingRTT(rtt time.Duration)
+}
+
+// nopMetrics используется, если метрики не заданы
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveRequest(string, time.Duration, error) {}
+func (nopMetrics) IncAPIError(string, string)                  {}
+func (nopMetrics) IncRateLimited(string)                       {}
+func (nopMetrics) IncReconnect()                               {}
+func (nopMetrics) IncMessage(string)                           {}
+func (nopMetrics) IncFillsEmitted(string)                      {}
+func (nopMetrics) AddFillsDropped(uint64)                      {}
+func (nopMetrics) SetFillsDepth(int)                           {}
+func (nopMetrics) ObservePingRTT(time.Duration)                {}
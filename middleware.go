@@ -0,0 +1,35 @@
+package bitstamp
+
+import "net/http"
+
+// Request запрос к приватному REST API
+type Request struct {
+	Path   string
+	Params map[string]string
+}
+
+// Response ответ приватного REST API
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       string
+}
+
+// Handler выполняет запрос. Для ошибок Bitstamp возвращается и Response, и ErrorResult
+type Handler func(req *Request) (*Response, error)
+
+// Interceptor оборачивает Handler: аудит, ретраи, внедрение ошибок, зеркалирование запросов.
+// Запрос подписывается внутри последнего Handler'а при каждом вызове next,
+// поэтому повторный вызов next получает новый nonce и безопасен
+type Interceptor func(next Handler) Handler
+
+// chain собирает цепочку, первый интерсептор оказывается снаружи
+func chain(interceptors []Interceptor, last Handler) Handler {
+	handler := last
+
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		handler = interceptors[i](handler)
+	}
+
+	return handler
+}
@@ -0,0 +1,937 @@
+package bitstamp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrOCONotFound OCO с таким идентификатором нет
+	ErrOCONotFound = errors.New("oco not found")
+	// ErrOCOCompleted стоп не выставлен: тейк-профит исполнился целиком до отмены
+	ErrOCOCompleted = errors.New("oco already completed")
+)
+
+const (
+	ocoTakeProfitSuffix = "-tp"
+	ocoStopSuffix       = "-sl"
+)
+
+// OCOLeg нога OCO
+type OCOLeg string
+
+const (
+	LegEntry      OCOLeg = "entry"
+	LegTakeProfit OCOLeg = "take_profit"
+	LegStop       OCOLeg = "stop"
+)
+
+// OCOState состояние OCO
+type OCOState string
+
+const (
+	// OCOPending бракет ждет исполнения входного ордера
+	OCOPending OCOState = "pending"
+	// OCOActive тейк-профит выставлен, стоп ждет срабатывания
+	OCOActive OCOState = "active"
+	// OCOTriggered стоп сработал, тейк-профит отменен
+	OCOTriggered OCOState = "triggered"
+	// OCODone одна из ног исполнена целиком
+	OCODone OCOState = "done"
+	// OCOCancelled OCO отменен через Cancel или входной ордер снят без исполнения
+	OCOCancelled OCOState = "cancelled"
+	// OCOFailed ногу не удалось выставить
+	OCOFailed OCOState = "failed"
+)
+
+// OCOLegStatus состояние ноги
+type OCOLegStatus struct {
+	ClientOrderID string
+	// OrderID id ордера Bitstamp, 0 пока ордер не выставлен
+	OrderID int64
+	Amount  float64
+	Filled  float64
+	Done    bool
+}
+
+// OCO тейк-профит лимитным ордером и эмулируемый стоп, один отменяет другой
+type OCO struct {
+	ID     string
+	Symbol string
+	// Side сторона выходных ног
+	Side   OrderSide
+	Amount float64
+	State  OCOState
+
+	Entry      OCOLegStatus
+	TakeProfit OCOLegStatus
+	Stop       OCOLegStatus
+
+	// Overfill исполнено сверх Amount, если тейк-профит исполнился после срабатывания стопа
+	Overfill float64
+	Err      error
+}
+
+// OCOEventType тип события OCO
+type OCOEventType string
+
+const (
+	// OCOEventActive ноги выставлены
+	OCOEventActive OCOEventType = "active"
+	// OCOEventFill трейд по ноге Leg
+	OCOEventFill OCOEventType = "fill"
+	// OCOEventTriggered стоп сработал, тейк-профит отменяется
+	OCOEventTriggered OCOEventType = "triggered"
+	// OCOEventResized объем стопа уменьшен после частичного исполнения тейк-профита
+	OCOEventResized OCOEventType = "resized"
+	// OCOEventRace обе ноги исполнились: тейк-профит исполнился после срабатывания стопа
+	OCOEventRace OCOEventType = "race"
+	// OCOEventDone OCO завершен, State итоговое состояние
+	OCOEventDone OCOEventType = "done"
+	// OCOEventError ошибка отмены или выставления ноги Leg
+	OCOEventError OCOEventType = "error"
+)
+
+// OCOEvent изменение OCO
+type OCOEvent struct {
+	Type OCOEventType
+	Leg  OCOLeg
+	OCO  OCO
+	Fill *Fill
+	Err  error
+	At   time.Time
+}
+
+// OCORequest OCO на закрытие позиции: Side сторона выходных ног
+type OCORequest struct {
+	// ClientOrderID базовый идентификатор, ноги получают суффиксы -tp и -sl
+	ClientOrderID   string
+	Symbol          string
+	Side            OrderSide
+	Amount          float64
+	TakeProfitPrice float64
+	StopPrice       float64
+	// StopLimitPrice цена лимитного ордера стопа, 0 для рыночного
+	StopLimitPrice float64
+}
+
+// BracketRequest входной ордер и OCO на его исполненный объем
+type BracketRequest struct {
+	// Entry входной ордер, его ClientOrderID служит базовым идентификатором
+	Entry           PlaceOrderRequest
+	TakeProfitPrice float64
+	StopPrice       float64
+	StopLimitPrice  float64
+}
+
+type ocoOrder struct {
+	OCO
+	stopPrice      float64
+	stopLimitPrice float64
+	takeProfit     float64
+	// stopPlaced стоп выставлен на биржу, дальнейшие трейды тейк-профита - гонка
+	stopPlaced bool
+	// stopRetry тейк-профит отменен, но результат выставления стопа неизвестен
+	stopRetry bool
+	raced     bool
+}
+
+func (o *ocoOrder) snapshot() OCO {
+	return o.OCO
+}
+
+// OCOManager эмулирует OCO и бракеты поверх PlaceOrder и CancelOrder. Тейк-профит выставляется
+// лимитным ордером, стоп отслеживается собственным StopEngine по HandleTrade. Трейды ног приходят
+// через Track или HandleFill, события бракета через HandleOrderEvent.
+// Состояние OCO хранится в памяти и не переживает перезапуск
+//
+//	ocos := bitstamp.NewOCOManager(client)
+//	ocos.Track(ws)
+//	trades.OnTrade(ocos.HandleTrade)
+type OCOManager struct {
+	api            TradingAPI
+	stops          *StopEngine
+	logger         Logger
+	now            func() time.Time
+	clientOrderIDs ClientOrderIDFunc
+
+	mu   sync.Mutex
+	ocos map[string]*ocoOrder
+	// legs и byID OCO по ClientOrderID и id ордеров ног
+	legs   map[string]*ocoOrder
+	byID   map[int64]*ocoOrder
+	trades *recentTrades
+
+	handlersMu sync.Mutex
+	handlers   []func(OCOEvent)
+
+	wg sync.WaitGroup
+}
+
+// OCOOption настройка OCOManager
+type OCOOption func(*OCOManager)
+
+// WithOCOLogger задает логгер
+func WithOCOLogger(logger Logger) OCOOption {
+	return func(m *OCOManager) {
+		m.logger = logger
+	}
+}
+
+// WithOCOClock задает источник времени
+func WithOCOClock(now func() time.Time) OCOOption {
+	return func(m *OCOManager) {
+		m.now = now
+	}
+}
+
+// WithOCOClientOrderIDs задает генератор базового ClientOrderID для запросов без него
+func WithOCOClientOrderIDs(generate ClientOrderIDFunc) OCOOption {
+	return func(m *OCOManager) {
+		m.clientOrderIDs = generate
+	}
+}
+
+// NewOCOManager создает эмуляцию OCO поверх PrivateClient, PaperClient или другой реализации TradingAPI
+func NewOCOManager(api TradingAPI, opts ...OCOOption) *OCOManager {
+	m := &OCOManager{
+		api:    api,
+		logger: NewLogrusLogger(logrus.WithField("provider", "bitstamp").WithField("module", "oco")),
+		now:    time.Now,
+		ocos:   make(map[string]*ocoOrder),
+		legs:   make(map[string]*ocoOrder),
+		byID:   make(map[int64]*ocoOrder),
+		trades: newRecentTrades(recentTradesLimit),
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	m.logger = newRedactingLogger(m.logger)
+
+	// без хранилища NewStopEngine не возвращает ошибку
+	m.stops, _ = NewStopEngine(ocoStopAPI{TradingAPI: api, m: m}, WithStopLogger(m.logger), WithStopClock(m.now))
+
+	return m
+}
+
+// ocoStopAPI выставляет сработавший стоп через OCOManager: сначала отменяется тейк-профит,
+// затем стоп выставляется на неисполненный тейк-профитом объем
+type ocoStopAPI struct {
+	TradingAPI
+	m *OCOManager
+}
+
+func (a ocoStopAPI) PlaceOrder(opts PlaceOrderRequest) (PlaceOrderResult, error) {
+	return a.m.fireStop(opts)
+}
+
+// GetOrderStatusByClientOrderID запоминает стоп, который StopEngine нашел по client_order_id при повторе
+func (a ocoStopAPI) GetOrderStatusByClientOrderID(clientOrderID string) (OrderStatusResult, error) {
+	status, err := a.TradingAPI.GetOrderStatusByClientOrderID(clientOrderID)
+	if err != nil {
+		return status, err
+	}
+
+	a.m.mu.Lock()
+	if o, ok := a.m.legs[clientOrderID]; ok && o.Stop.ClientOrderID == clientOrderID {
+		o.Stop.OrderID = status.ID
+		o.stopPlaced = true
+		o.stopRetry = false
+		a.m.byID[status.ID] = o
+	}
+	a.m.mu.Unlock()
+
+	return status, nil
+}
+
+// OnEvent добавляет обработчик событий OCO
+func (m *OCOManager) OnEvent(handler func(OCOEvent)) {
+	m.handlersMu.Lock()
+	defer m.handlersMu.Unlock()
+
+	m.handlers = append(m.handlers, handler)
+}
+
+// Track подписывает OCOManager на трейды потока
+func (m *OCOManager) Track(stream FillStream) *FillSubscription {
+	return stream.OnFill(m.HandleFill)
+}
+
+// HandleTrade проверяет триггеры стопов. Подходит для TradeStream.OnTrade
+func (m *OCOManager) HandleTrade(trade Trade) {
+	m.stops.HandleTrade(trade)
+}
+
+// OCO возвращает снимок OCO
+func (m *OCOManager) OCO(id string) (OCO, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.ocos[id]
+	if !ok {
+		return OCO{}, false
+	}
+
+	return o.snapshot(), true
+}
+
+func (m *OCOManager) baseID(id string) (string, error) {
+	if id == "" && m.clientOrderIDs != nil {
+		generated, err := m.clientOrderIDs()
+		if err != nil {
+			return "", fmt.Errorf("could not generate client order id: %w", err)
+		}
+
+		id = generated
+	}
+
+	if id == "" {
+		return "", ErrNoClientOrderID
+	}
+
+	if len(id)+len(ocoTakeProfitSuffix) > MaxClientOrderIDLength {
+		return "", fmt.Errorf("client order id is too long: %q", id)
+	}
+
+	return id, nil
+}
+
+func validateOCOPrices(side OrderSide, takeProfit float64, stop float64) error {
+	if takeProfit <= 0 || stop <= 0 {
+		return fmt.Errorf("take profit and stop prices must be specified")
+	}
+
+	if side == Sell && takeProfit <= stop || side == Buy && takeProfit >= stop {
+		return fmt.Errorf("take profit %v must be on the other side of stop %v", takeProfit, stop)
+	}
+
+	return nil
+}
+
+// PlaceOCO выставляет тейк-профит и ставит стоп на отслеживание
+func (m *OCOManager) PlaceOCO(req OCORequest) (OCO, error) {
+	id, err := m.baseID(req.ClientOrderID)
+	if err != nil {
+		return OCO{}, err
+	}
+
+	if req.Side != Buy && req.Side != Sell {
+		return OCO{}, ErrNoSide
+	}
+
+	if req.Symbol == "" || req.Amount <= 0 {
+		return OCO{}, fmt.Errorf("symbol and amount must be specified")
+	}
+
+	if err := validateOCOPrices(req.Side, req.TakeProfitPrice, req.StopPrice); err != nil {
+		return OCO{}, err
+	}
+
+	o := &ocoOrder{
+		OCO: OCO{
+			ID:     id,
+			Symbol: req.Symbol,
+			Side:   req.Side,
+			Amount: req.Amount,
+			State:  OCOPending,
+		},
+		takeProfit:     req.TakeProfitPrice,
+		stopPrice:      req.StopPrice,
+		stopLimitPrice: req.StopLimitPrice,
+	}
+
+	if err := m.register(o); err != nil {
+		return OCO{}, err
+	}
+
+	if err := m.arm(o, req.Amount); err != nil {
+		return m.snapshot(o), err
+	}
+
+	return m.snapshot(o), nil
+}
+
+// PlaceBracket выставляет входной ордер. Тейк-профит и стоп выставляются на исполненный объем,
+// когда входной ордер исполнен целиком или снят с частичным исполнением
+func (m *OCOManager) PlaceBracket(req BracketRequest) (OCO, error) {
+	id, err := m.baseID(req.Entry.ClientOrderID)
+	if err != nil {
+		return OCO{}, err
+	}
+
+	if err := validateOrder(req.Entry); err != nil {
+		return OCO{}, err
+	}
+
+	side := Sell
+	if req.Entry.Side == Sell {
+		side = Buy
+	}
+
+	if err := validateOCOPrices(side, req.TakeProfitPrice, req.StopPrice); err != nil {
+		return OCO{}, err
+	}
+
+	req.Entry.ClientOrderID = id
+
+	o := &ocoOrder{
+		OCO: OCO{
+			ID:     id,
+			Symbol: req.Entry.Symbol,
+			Side:   side,
+			State:  OCOPending,
+			Entry:  OCOLegStatus{ClientOrderID: id, Amount: req.Entry.Amount},
+		},
+		takeProfit:     req.TakeProfitPrice,
+		stopPrice:      req.StopPrice,
+		stopLimitPrice: req.StopLimitPrice,
+	}
+
+	if err := m.register(o); err != nil {
+		return OCO{}, err
+	}
+
+	result, err := m.api.PlaceOrder(req.Entry)
+	if err != nil {
+		result, err = m.resolve(o, LegEntry, req.Entry, err)
+	}
+
+	m.mu.Lock()
+	if err != nil {
+		o.State = OCOFailed
+		o.Err = err
+		m.mu.Unlock()
+
+		m.notify(OCOEvent{Type: OCOEventDone, Leg: LegEntry, OCO: m.snapshot(o), Err: err, At: m.now()})
+
+		return m.snapshot(o), err
+	}
+
+	o.Entry.OrderID = result.ID
+	m.byID[result.ID] = o
+	m.mu.Unlock()
+
+	return m.snapshot(o), nil
+}
+
+func (m *OCOManager) register(o *ocoOrder) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.ocos[o.ID]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateClientOrderID, o.ID)
+	}
+
+	o.TakeProfit.ClientOrderID = o.ID + ocoTakeProfitSuffix
+	o.Stop.ClientOrderID = o.ID + ocoStopSuffix
+
+	m.ocos[o.ID] = o
+	m.legs[o.TakeProfit.ClientOrderID] = o
+	m.legs[o.Stop.ClientOrderID] = o
+
+	if o.Entry.ClientOrderID != "" {
+		m.legs[o.Entry.ClientOrderID] = o
+	}
+
+	return nil
+}
+
+// resolve разбирает ошибку выставления ноги. Отклоненный ордер не выставлен. Если результат неизвестен,
+// ордер ищется по client_order_id: найденный ордер отслеживается дальше, иначе он отменяется
+// по client_order_id на случай, если запрос еще дойдет до Bitstamp
+func (m *OCOManager) resolve(o *ocoOrder, leg OCOLeg, req PlaceOrderRequest, err error) (PlaceOrderResult, error) {
+	if IsRejected(err) {
+		return PlaceOrderResult{}, err
+	}
+
+	logger := m.logger.WithField("oco", o.ID).WithField("leg", leg).WithField("client_order_id", req.ClientOrderID)
+
+	status, serr := m.api.GetOrderStatusByClientOrderID(req.ClientOrderID)
+	if serr == nil {
+		logger.WithError(err).Warn("oco leg result was unknown, order found by client order id")
+		return placedResult(req, status), nil
+	}
+
+	if _, cerr := m.api.CancelOrderByClientOrderID(req.ClientOrderID); cerr != nil && !IsOrderNotFound(cerr) {
+		logger.WithError(cerr).Error("could not cancel oco leg by client order id")
+	}
+
+	return PlaceOrderResult{}, err
+}
+
+// arm выставляет тейк-профит на amount и ставит стоп на отслеживание
+func (m *OCOManager) arm(o *ocoOrder, amount float64) error {
+	m.mu.Lock()
+	if o.State == OCOCancelled {
+		m.mu.Unlock()
+		return nil
+	}
+
+	o.Amount = amount
+	o.TakeProfit.Amount = amount
+	o.Stop.Amount = amount
+	m.mu.Unlock()
+
+	req := PlaceOrderRequest{
+		Price:         o.takeProfit,
+		Amount:        amount,
+		Symbol:        o.Symbol,
+		Side:          o.Side,
+		Type:          Limit,
+		ClientOrderID: o.TakeProfit.ClientOrderID,
+	}
+
+	result, err := m.api.PlaceOrder(req)
+	if err != nil {
+		result, err = m.resolve(o, LegTakeProfit, req, err)
+	}
+
+	if err != nil {
+		m.fail(o, LegTakeProfit, err)
+		return err
+	}
+
+	stop := StopOrder{
+		ClientOrderID: o.Stop.ClientOrderID,
+		Kind:          StopMarket,
+		Symbol:        o.Symbol,
+		Side:          o.Side,
+		Amount:        amount,
+		TriggerPrice:  o.stopPrice,
+	}
+
+	if o.stopLimitPrice > 0 {
+		stop.Kind = StopLimit
+		stop.LimitPrice = o.stopLimitPrice
+	}
+
+	// OCO становится активным до постановки стопа: стоп может сработать сразу в Add,
+	// и fireStop выставляет его только для активного OCO
+	m.mu.Lock()
+	o.TakeProfit.OrderID = result.ID
+	m.byID[result.ID] = o
+
+	prev := o.State
+	if prev != OCODone && prev != OCOCancelled {
+		o.State = OCOActive
+	}
+	m.mu.Unlock()
+
+	switch prev {
+	case OCODone:
+		// тейк-профит исполнился целиком до постановки стопа
+		return nil
+	case OCOCancelled:
+		// Cancel не видел тейк-профит, он выставлен после отмены OCO
+		if _, err := m.api.CancelOrder(strconv.FormatInt(result.ID, 10)); err != nil && !IsOrderNotFound(err) {
+			m.logger.WithError(err).WithField("oco", o.ID).Error("could not cancel take profit")
+			return err
+		}
+
+		return nil
+	}
+
+	if _, err := m.stops.Add(stop); err != nil {
+		m.mu.Lock()
+		if o.State == OCOActive {
+			o.State = prev
+		}
+		m.mu.Unlock()
+
+		if _, cerr := m.api.CancelOrder(strconv.FormatInt(result.ID, 10)); cerr != nil {
+			m.logger.WithError(cerr).WithField("oco", o.ID).Error("could not cancel take profit")
+		}
+
+		m.fail(o, LegStop, err)
+
+		return err
+	}
+
+	m.mu.Lock()
+	// пока стоп ставился, тейк-профит мог исполниться целиком или OCO мог быть отменен:
+	// HandleFill и Cancel не нашли стоп для отмены
+	finished := o.State == OCODone || o.State == OCOCancelled
+	m.mu.Unlock()
+
+	if finished {
+		_ = m.stops.Cancel(o.Stop.ClientOrderID)
+		return nil
+	}
+
+	m.notify(OCOEvent{Type: OCOEventActive, OCO: m.snapshot(o), At: m.now()})
+
+	return nil
+}
+
+func (m *OCOManager) fail(o *ocoOrder, leg OCOLeg, err error) {
+	m.logger.WithError(err).WithField("oco", o.ID).WithField("leg", leg).Error("could not place oco leg")
+
+	m.mu.Lock()
+	o.State = OCOFailed
+	o.Err = err
+	m.mu.Unlock()
+
+	m.notify(OCOEvent{Type: OCOEventDone, Leg: leg, OCO: m.snapshot(o), Err: err, At: m.now()})
+}
+
+// fireStop вызывается StopEngine при срабатывании стопа. Стоп выставляется только после
+// подтвержденной отмены тейк-профита на объем, который остался неисполненным при отмене.
+// Если отмену не удалось подтвердить, OCO снова активен, а стоп выставляется повторно через Resume
+func (m *OCOManager) fireStop(opts PlaceOrderRequest) (PlaceOrderResult, error) {
+	m.mu.Lock()
+	o, ok := m.legs[opts.ClientOrderID]
+	if !ok || o.State != OCOActive && !(o.State == OCOTriggered && o.stopRetry) {
+		m.mu.Unlock()
+		return PlaceOrderResult{}, stopError{err: ErrOCOCompleted}
+	}
+
+	// повтор: тейк-профит уже отменен, объем стопа известен
+	retry := o.stopRetry
+	o.State = OCOTriggered
+	o.stopRetry = false
+	takeProfitID := o.TakeProfit.OrderID
+	amount := o.Stop.Amount
+	m.mu.Unlock()
+
+	if !retry {
+		m.notify(OCOEvent{Type: OCOEventTriggered, Leg: LegStop, OCO: m.snapshot(o), At: m.now()})
+
+		remaining, err := m.cancelTakeProfit(takeProfitID)
+		if err != nil {
+			m.logger.WithError(err).WithField("oco", o.ID).Error("could not cancel take profit, stop isn't placed")
+
+			m.mu.Lock()
+			if o.State == OCOTriggered {
+				o.State = OCOActive
+			}
+			m.mu.Unlock()
+
+			m.notify(OCOEvent{Type: OCOEventError, Leg: LegTakeProfit, OCO: m.snapshot(o), Err: err, At: m.now()})
+
+			return PlaceOrderResult{}, stopError{err: err, retry: true}
+		}
+
+		m.mu.Lock()
+		o.TakeProfit.Done = true
+
+		if remaining <= paperEpsilon {
+			// HandleFill уже завершил OCO, если трейды тейк-профита пришли до отмены
+			done := o.State == OCODone
+			o.State = OCODone
+			o.Stop.Amount = 0
+			m.mu.Unlock()
+
+			if !done {
+				m.notify(OCOEvent{Type: OCOEventDone, Leg: LegTakeProfit, OCO: m.snapshot(o), At: m.now()})
+			}
+
+			return PlaceOrderResult{}, stopError{err: ErrOCOCompleted}
+		}
+
+		o.Stop.Amount = remaining
+		o.stopPlaced = true
+		amount = remaining
+		m.mu.Unlock()
+	}
+
+	opts.Amount = amount
+
+	result, err := m.api.PlaceOrder(opts)
+
+	switch {
+	case err == nil:
+		m.mu.Lock()
+		o.Stop.OrderID = result.ID
+		m.byID[result.ID] = o
+		m.mu.Unlock()
+	case IsRejected(err):
+		m.fail(o, LegStop, err)
+	default:
+		// StopEngine проверит ордер по client_order_id и при необходимости оставит стоп для Resume
+		m.mu.Lock()
+		o.stopRetry = true
+		m.mu.Unlock()
+
+		m.notify(OCOEvent{Type: OCOEventError, Leg: LegStop, OCO: m.snapshot(o), Err: err, At: m.now()})
+	}
+
+	return result, err
+}
+
+// cancelTakeProfit отменяет тейк-профит и возвращает его неисполненный остаток.
+// Если ордер уже не открыт, остаток берется из статуса ордера
+func (m *OCOManager) cancelTakeProfit(id int64) (float64, error) {
+	result, err := m.api.CancelOrder(strconv.FormatInt(id, 10))
+	if err == nil {
+		return result.Amount, nil
+	}
+
+	if !IsOrderNotFound(err) {
+		return 0, err
+	}
+
+	status, err := m.api.GetOrderStatus(strconv.FormatInt(id, 10))
+	if err != nil {
+		return 0, fmt.Errorf("could not get take profit status: %w", err)
+	}
+
+	if status.Status == OrderStatusOpen {
+		return 0, fmt.Errorf("take profit %d is still open", id)
+	}
+
+	return status.AmountRemaining, nil
+}
+
+// Resume повторно выставляет стопы, которые сработали, но не были выставлены:
+// отмена тейк-профита не подтвердилась или результат выставления стопа неизвестен
+func (m *OCOManager) Resume() {
+	m.stops.Resume()
+}
+
+// HandleFill учитывает трейд по ноге. Трейды чужих ордеров пропускаются. Подходит для FillStream.OnFill
+func (m *OCOManager) HandleFill(fill Fill) {
+	m.mu.Lock()
+
+	o, leg := m.find(fill)
+	if o == nil {
+		m.mu.Unlock()
+		return
+	}
+
+	if !m.trades.remember(fill.TradeID) {
+		m.mu.Unlock()
+		return
+	}
+
+	var events []OCOEvent
+
+	event := func(t OCOEventType, leg OCOLeg) {
+		events = append(events, OCOEvent{Type: t, Leg: leg, OCO: o.snapshot(), At: m.now()})
+	}
+
+	var (
+		armAmount  float64
+		cancelStop bool
+	)
+
+	switch leg {
+	case LegEntry:
+		o.Entry.Filled += fill.Size
+		event(OCOEventFill, leg)
+
+		// бракет выставляется один раз: HandleOrderEvent мог выставить его раньше трейдов
+		if o.State == OCOPending && !o.Entry.Done && o.Entry.Filled >= o.Entry.Amount-paperEpsilon {
+			o.Entry.Done = true
+			armAmount = o.Entry.Filled
+		}
+	case LegTakeProfit:
+		o.TakeProfit.Filled += fill.Size
+		event(OCOEventFill, leg)
+
+		switch {
+		case o.stopPlaced:
+			// стоп уже выставлен на объем без этого трейда
+			o.Overfill = o.TakeProfit.Filled + o.Stop.Amount - o.Amount
+			if o.Overfill > paperEpsilon {
+				o.raced = true
+				event(OCOEventRace, leg)
+			}
+		case o.TakeProfit.Filled >= o.Amount-paperEpsilon:
+			o.TakeProfit.Done = true
+			o.Stop.Amount = 0
+			cancelStop = o.State == OCOActive
+			o.State = OCODone
+			event(OCOEventDone, leg)
+		default:
+			o.Stop.Amount = o.Amount - o.TakeProfit.Filled
+			event(OCOEventResized, LegStop)
+		}
+	case LegStop:
+		o.Stop.Filled += fill.Size
+		event(OCOEventFill, leg)
+
+		if o.TakeProfit.Filled > paperEpsilon && o.TakeProfit.Filled+o.Stop.Filled > o.Amount+paperEpsilon && !o.raced {
+			o.raced = true
+			o.Overfill = o.TakeProfit.Filled + o.Stop.Filled - o.Amount
+			event(OCOEventRace, leg)
+		}
+
+		if o.Stop.Filled >= o.Stop.Amount-paperEpsilon && o.State == OCOTriggered {
+			o.Stop.Done = true
+			o.State = OCODone
+			event(OCOEventDone, leg)
+		}
+	}
+
+	m.mu.Unlock()
+
+	if cancelStop {
+		// стоп мог сработать одновременно, тогда fireStop увидит исполненный тейк-профит
+		if err := m.stops.Cancel(o.Stop.ClientOrderID); err != nil && !errors.Is(err, ErrStopNotFound) {
+			m.logger.WithError(err).WithField("oco", o.ID).Error("could not cancel stop")
+		}
+	}
+
+	for i := range events {
+		if events[i].Type == OCOEventFill {
+			f := fill
+			events[i].Fill = &f
+		}
+
+		if events[i].Type == OCOEventRace {
+			m.logger.WithField("oco", o.ID).WithField("overfill", events[i].OCO.Overfill).Error("both oco legs filled")
+		}
+
+		m.notify(events[i])
+	}
+
+	if armAmount > 0 {
+		m.armAsync(o, armAmount)
+	}
+}
+
+// HandleOrderEvent выставляет ноги бракета, когда входной ордер удален: исполнен целиком или снят
+// с частичным исполнением. Подходит для WithOrderEvents
+func (m *OCOManager) HandleOrderEvent(event OrderEvent) {
+	if event.Type != OrderEventDeleted {
+		return
+	}
+
+	m.mu.Lock()
+
+	o := m.byID[event.ID]
+	if o == nil && event.ClientOrderID != "" {
+		o = m.legs[event.ClientOrderID]
+	}
+
+	if o == nil || o.State != OCOPending || o.Entry.ClientOrderID == "" || o.Entry.Done {
+		m.mu.Unlock()
+		return
+	}
+
+	o.Entry.Done = true
+	// event.Amount неисполненный остаток: трейды my_trades могут прийти позже order_deleted
+	filled := o.Entry.Amount - event.Amount
+	if filled < o.Entry.Filled {
+		filled = o.Entry.Filled
+	}
+
+	if filled <= paperEpsilon {
+		o.State = OCOCancelled
+	}
+	m.mu.Unlock()
+
+	if filled <= paperEpsilon {
+		m.notify(OCOEvent{Type: OCOEventDone, Leg: LegEntry, OCO: m.snapshot(o), At: m.now()})
+		return
+	}
+
+	m.armAsync(o, filled)
+}
+
+// armAsync выставляет ноги в отдельной горутине, чтобы не блокировать поток событий
+func (m *OCOManager) armAsync(o *ocoOrder, amount float64) {
+	m.wg.Add(1)
+
+	go func() {
+		defer m.wg.Done()
+
+		_ = m.arm(o, amount)
+	}()
+}
+
+// find нога трейда по client_order_id или id ордера. Вызывается под m.mu
+func (m *OCOManager) find(fill Fill) (*ocoOrder, OCOLeg) {
+	o := m.legs[fill.ClientOrderID]
+	if o == nil {
+		o = m.byID[fill.OrderID]
+	}
+
+	if o == nil {
+		return nil, ""
+	}
+
+	switch {
+	case fill.ClientOrderID == o.TakeProfit.ClientOrderID || fill.OrderID != 0 && fill.OrderID == o.TakeProfit.OrderID:
+		return o, LegTakeProfit
+	case fill.ClientOrderID == o.Stop.ClientOrderID || fill.OrderID != 0 && fill.OrderID == o.Stop.OrderID:
+		return o, LegStop
+	case o.Entry.ClientOrderID != "":
+		return o, LegEntry
+	}
+
+	return nil, ""
+}
+
+// Cancel отменяет ожидающие ноги OCO. Ноги, которые выставляются в этот момент, отменяются после выставления
+func (m *OCOManager) Cancel(id string) error {
+	m.mu.Lock()
+	o, ok := m.ocos[id]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrOCONotFound, id)
+	}
+
+	state := o.State
+	entryID, takeProfitID := o.Entry.OrderID, o.TakeProfit.OrderID
+	// входной ордер уже удален, ноги выставляются: arm отменит их после выставления
+	entryDone := o.Entry.Done
+
+	if state == OCOPending || state == OCOActive {
+		o.State = OCOCancelled
+	}
+	m.mu.Unlock()
+
+	var err error
+
+	switch state {
+	case OCOPending:
+		if entryID != 0 && !entryDone {
+			_, err = m.api.CancelOrder(strconv.FormatInt(entryID, 10))
+		}
+	case OCOActive:
+		if serr := m.stops.Cancel(o.Stop.ClientOrderID); serr != nil && !errors.Is(serr, ErrStopNotFound) {
+			err = serr
+		}
+
+		if _, cerr := m.api.CancelOrder(strconv.FormatInt(takeProfitID, 10)); cerr != nil && !IsOrderNotFound(cerr) {
+			err = cerr
+		}
+	default:
+		return fmt.Errorf("%w: %s is %s", ErrOCOCompleted, id, state)
+	}
+
+	m.notify(OCOEvent{Type: OCOEventDone, OCO: m.snapshot(o), Err: err, At: m.now()})
+
+	return err
+}
+
+// Close дожидается выставления ног и сработавших стопов
+func (m *OCOManager) Close() {
+	m.wg.Wait()
+	m.stops.Close()
+}
+
+func (m *OCOManager) snapshot(o *ocoOrder) OCO {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return o.snapshot()
+}
+
+func (m *OCOManager) notify(event OCOEvent) {
+	m.handlersMu.Lock()
+	handlers := m.handlers
+	m.handlersMu.Unlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
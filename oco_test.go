@@ -0,0 +1,407 @@
+package bitstamp_test
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/b2broker/bitstamp"
+	"github.com/b2broker/bitstamp/bitstamptest"
+)
+
+// ocoAPI PrivateClient с хуками для сценариев OCO
+type ocoAPI struct {
+	*bitstamp.PrivateClient
+	// cancelAmount остаток в ответе отмены вместо фактического, как если бы ответ не учел одновременный трейд
+	cancelAmount float64
+	// lostSuffix ответ на выставление ордера с таким суффиксом client_order_id теряется
+	lostSuffix string
+}
+
+func (a ocoAPI) CancelOrder(id string) (bitstamp.OrderCancelResult, error) {
+	result, err := a.PrivateClient.CancelOrder(id)
+	if err == nil && a.cancelAmount > 0 {
+		result.Amount = a.cancelAmount
+	}
+
+	return result, err
+}
+
+func (a ocoAPI) PlaceOrder(opts bitstamp.PlaceOrderRequest) (bitstamp.PlaceOrderResult, error) {
+	result, err := a.PrivateClient.PlaceOrder(opts)
+	if err == nil && a.lostSuffix != "" && strings.HasSuffix(opts.ClientOrderID, a.lostSuffix) {
+		return bitstamp.PlaceOrderResult{}, bitstamp.ErrorResult{Status: "error", Code: "502", Reason: "Bad gateway", HTTPStatus: http.StatusBadGateway}
+	}
+
+	return result, err
+}
+
+// ocoEvents собирает события OCOManager
+type ocoEvents struct {
+	mu     sync.Mutex
+	events []bitstamp.OCOEvent
+}
+
+func (e *ocoEvents) handle(event bitstamp.OCOEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.events = append(e.events, event)
+}
+
+func (e *ocoEvents) count(eventType bitstamp.OCOEventType) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var n int
+
+	for _, event := range e.events {
+		if event.Type == eventType {
+			n++
+		}
+	}
+
+	return n
+}
+
+// legFill трейд ноги OCO, исполненной на bitstamptest
+func legFill(t *testing.T, srv *bitstamptest.Server, clientOrderID string, tradeID int64, size float64) bitstamp.Fill {
+	t.Helper()
+
+	orders := serverOrders(srv, clientOrderID)
+	if len(orders) != 1 {
+		t.Fatalf("server orders for %s: %+v", clientOrderID, orders)
+	}
+
+	return bitstamp.Fill{
+		OrderID:       orders[0].ID,
+		TradeID:       tradeID,
+		ClientOrderID: clientOrderID,
+		Symbol:        "btcusd",
+		Side:          string(orders[0].Side),
+		Price:         orders[0].Price,
+		Size:          size,
+	}
+}
+
+func TestOCOTakeProfitFills(t *testing.T) {
+	tests := []struct {
+		name string
+		api  ocoAPI
+		// scenario исполняет ноги OCO на bitstamptest и передает трейды в OCOManager
+		scenario func(t *testing.T, srv *bitstamptest.Server, m *bitstamp.OCOManager)
+		state    bitstamp.OCOState
+		// stop объем выставленного стопа, 0 если стоп не выставлен
+		stop     float64
+		overfill float64
+		resized  int
+		races    int
+	}{
+		{
+			name: "take profit partially filled, stop resized",
+			scenario: func(t *testing.T, srv *bitstamptest.Server, m *bitstamp.OCOManager) {
+				srv.Trade("btcusd", bitstamp.Buy, 21000, 0.004)
+				m.HandleFill(legFill(t, srv, "oco-1-tp", 1, 0.004))
+
+				m.HandleTrade(bitstamp.Trade{Symbol: "btcusd", Price: 18990, Amount: 0.1})
+			},
+			state:   bitstamp.OCOTriggered,
+			stop:    0.006,
+			resized: 1,
+		},
+		{
+			name: "take profit filled, stop cancelled",
+			scenario: func(t *testing.T, srv *bitstamptest.Server, m *bitstamp.OCOManager) {
+				srv.Trade("btcusd", bitstamp.Buy, 21000, 0.01)
+				m.HandleFill(legFill(t, srv, "oco-1-tp", 1, 0.01))
+
+				m.HandleTrade(bitstamp.Trade{Symbol: "btcusd", Price: 18990, Amount: 0.1})
+			},
+			state: bitstamp.OCODone,
+		},
+		{
+			name: "take profit fill after stop trigger counted in stop amount",
+			scenario: func(t *testing.T, srv *bitstamptest.Server, m *bitstamp.OCOManager) {
+				srv.Trade("btcusd", bitstamp.Buy, 21000, 0.004)
+
+				m.HandleTrade(bitstamp.Trade{Symbol: "btcusd", Price: 18990, Amount: 0.1})
+				m.Close()
+
+				// трейд пришел после отмены, но отмена его уже учла
+				m.HandleFill(legFill(t, srv, "oco-1-tp", 1, 0.004))
+			},
+			state: bitstamp.OCOTriggered,
+			stop:  0.006,
+		},
+		{
+			name: "take profit fill missed by cancel response",
+			api:  ocoAPI{cancelAmount: 0.01},
+			scenario: func(t *testing.T, srv *bitstamptest.Server, m *bitstamp.OCOManager) {
+				srv.Trade("btcusd", bitstamp.Buy, 21000, 0.004)
+
+				m.HandleTrade(bitstamp.Trade{Symbol: "btcusd", Price: 18990, Amount: 0.1})
+				m.Close()
+
+				m.HandleFill(legFill(t, srv, "oco-1-tp", 1, 0.004))
+				// повтор трейда не увеличивает перебор
+				m.HandleFill(legFill(t, srv, "oco-1-tp", 1, 0.004))
+			},
+			state:    bitstamp.OCOTriggered,
+			stop:     0.01,
+			overfill: 0.004,
+			races:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			srv := bitstamptest.NewServer("key", "secret")
+			defer srv.Close()
+
+			srv.SetBalance("btc", 1)
+			srv.SetBalance("usd", 1000)
+
+			api := tt.api
+			api.PrivateClient = srv.NewClient()
+
+			m := bitstamp.NewOCOManager(api)
+
+			events := &ocoEvents{}
+			m.OnEvent(events.handle)
+
+			_, err := m.PlaceOCO(bitstamp.OCORequest{
+				ClientOrderID:   "oco-1",
+				Symbol:          "btcusd",
+				Side:            bitstamp.Sell,
+				Amount:          0.01,
+				TakeProfitPrice: 21000,
+				StopPrice:       19000,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.scenario(t, srv, m)
+			m.Close()
+
+			o, _ := m.OCO("oco-1")
+			if o.State != tt.state {
+				t.Fatalf("state = %s, want %s (%+v)", o.State, tt.state, o)
+			}
+
+			stops := serverOrders(srv, "oco-1-sl")
+
+			switch {
+			case tt.stop == 0 && len(stops) != 0:
+				t.Fatalf("stop placed: %+v", stops)
+			case tt.stop > 0 && (len(stops) != 1 || math.Abs(stops[0].Amount-tt.stop) > 1e-9):
+				t.Fatalf("stop orders %+v, want one for %v", stops, tt.stop)
+			}
+
+			if math.Abs(o.Overfill-tt.overfill) > 1e-9 {
+				t.Fatalf("overfill = %v, want %v", o.Overfill, tt.overfill)
+			}
+
+			if got := events.count(bitstamp.OCOEventResized); got != tt.resized {
+				t.Fatalf("resized events = %d, want %d", got, tt.resized)
+			}
+
+			if got := events.count(bitstamp.OCOEventRace); got != tt.races {
+				t.Fatalf("race events = %d, want %d", got, tt.races)
+			}
+		})
+	}
+}
+
+func TestOCOBracketEntry(t *testing.T) {
+	tests := []struct {
+		name string
+		api  ocoAPI
+		// fault ошибка выставления входного ордера
+		fault *bitstamptest.Fault
+		// scenario исполняет входной ордер и передает события в OCOManager
+		scenario func(t *testing.T, srv *bitstamptest.Server, m *bitstamp.OCOManager, client *bitstamp.PrivateClient)
+		state    bitstamp.OCOState
+		// takeProfit объем выставленного тейк-профита, 0 если он не выставлен
+		takeProfit float64
+	}{
+		{
+			name: "order deleted before trades",
+			scenario: func(t *testing.T, srv *bitstamptest.Server, m *bitstamp.OCOManager, client *bitstamp.PrivateClient) {
+				srv.Trade("btcusd", bitstamp.Sell, 20000, 0.004)
+
+				entry := serverOrders(srv, "br-1")[0]
+				if _, err := client.CancelOrderByClientOrderID("br-1"); err != nil {
+					t.Fatal(err)
+				}
+
+				m.HandleOrderEvent(bitstamp.OrderEvent{Type: bitstamp.OrderEventDeleted, ID: entry.ID, ClientOrderID: "br-1", Symbol: "btcusd", Side: bitstamp.Buy, Price: 20000, Amount: 0.006})
+				m.Close()
+
+				m.HandleFill(legFill(t, srv, "br-1", 1, 0.004))
+			},
+			state:      bitstamp.OCOActive,
+			takeProfit: 0.004,
+		},
+		{
+			name: "filled entry armed once",
+			scenario: func(t *testing.T, srv *bitstamptest.Server, m *bitstamp.OCOManager, client *bitstamp.PrivateClient) {
+				srv.Trade("btcusd", bitstamp.Sell, 20000, 0.01)
+				// трейд приходит, пока тейк-профит выставляется по order_deleted
+				srv.InjectFault(bitstamptest.Fault{Path: "/api/v2/sell/btcusd/", Delay: 100 * time.Millisecond})
+
+				fill := legFill(t, srv, "br-1", 1, 0.01)
+				m.HandleOrderEvent(bitstamp.OrderEvent{Type: bitstamp.OrderEventDeleted, ID: fill.OrderID, ClientOrderID: "br-1", Symbol: "btcusd", Side: bitstamp.Buy, Price: 20000})
+				m.HandleFill(fill)
+			},
+			state:      bitstamp.OCOActive,
+			takeProfit: 0.01,
+		},
+		{
+			name: "cancelled without fills",
+			scenario: func(t *testing.T, srv *bitstamptest.Server, m *bitstamp.OCOManager, client *bitstamp.PrivateClient) {
+				entry := serverOrders(srv, "br-1")[0]
+				m.HandleOrderEvent(bitstamp.OrderEvent{Type: bitstamp.OrderEventDeleted, ID: entry.ID, ClientOrderID: "br-1", Symbol: "btcusd", Side: bitstamp.Buy, Price: 20000, Amount: 0.01})
+			},
+			state: bitstamp.OCOCancelled,
+		},
+		{
+			name:  "entry rejected",
+			fault: &bitstamptest.Fault{Path: "/api/v2/buy/btcusd/", Status: http.StatusBadRequest, Code: "400.001", Reason: "Not enough balance"},
+			state: bitstamp.OCOFailed,
+		},
+		{
+			name:  "entry result unknown, order not found",
+			fault: &bitstamptest.Fault{Path: "/api/v2/buy/btcusd/", Status: http.StatusBadGateway, Code: "502", Reason: "Bad gateway"},
+			state: bitstamp.OCOFailed,
+		},
+		{
+			name:  "entry result unknown, order found",
+			api:   ocoAPI{lostSuffix: "br-1"},
+			state: bitstamp.OCOPending,
+		},
+		{
+			name: "take profit result unknown, order found",
+			api:  ocoAPI{lostSuffix: "-tp"},
+			scenario: func(t *testing.T, srv *bitstamptest.Server, m *bitstamp.OCOManager, client *bitstamp.PrivateClient) {
+				srv.Trade("btcusd", bitstamp.Sell, 20000, 0.01)
+				m.HandleFill(legFill(t, srv, "br-1", 1, 0.01))
+			},
+			state:      bitstamp.OCOActive,
+			takeProfit: 0.01,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			srv := bitstamptest.NewServer("key", "secret")
+			defer srv.Close()
+
+			srv.SetBalance("usd", 1000)
+
+			client := srv.NewClient()
+
+			api := tt.api
+			api.PrivateClient = client
+
+			m := bitstamp.NewOCOManager(api)
+
+			if tt.fault != nil {
+				srv.InjectFault(*tt.fault)
+			}
+
+			_, err := m.PlaceBracket(bitstamp.BracketRequest{
+				Entry:           bitstamp.PlaceOrderRequest{Symbol: "btcusd", Side: bitstamp.Buy, Type: bitstamp.Limit, Price: 20000, Amount: 0.01, ClientOrderID: "br-1"},
+				TakeProfitPrice: 21000,
+				StopPrice:       19000,
+			})
+			if (err != nil) != (tt.state == bitstamp.OCOFailed) {
+				t.Fatalf("PlaceBracket error = %v, want state %s", err, tt.state)
+			}
+
+			if tt.scenario != nil {
+				tt.scenario(t, srv, m, client)
+			}
+
+			m.Close()
+
+			o, _ := m.OCO("br-1")
+			if o.State != tt.state {
+				t.Fatalf("state = %s, want %s (%+v)", o.State, tt.state, o)
+			}
+
+			if tt.state == bitstamp.OCOPending && o.Entry.OrderID == 0 {
+				t.Fatal("entry found by client order id isn't tracked")
+			}
+
+			takeProfits := serverOrders(srv, "br-1-tp")
+
+			switch {
+			case tt.takeProfit == 0 && len(takeProfits) != 0:
+				t.Fatalf("take profit placed: %+v", takeProfits)
+			case tt.takeProfit > 0 && (len(takeProfits) != 1 || math.Abs(takeProfits[0].Amount-tt.takeProfit) > 1e-9):
+				t.Fatalf("take profit orders %+v, want one for %v", takeProfits, tt.takeProfit)
+			}
+
+			if tt.takeProfit > 0 && o.TakeProfit.OrderID != takeProfits[0].ID {
+				t.Fatalf("take profit order id = %d, want %d", o.TakeProfit.OrderID, takeProfits[0].ID)
+			}
+		})
+	}
+}
+
+// TestOCOCancelWhileArming Cancel во время выставления тейк-профита отменяет его после выставления
+func TestOCOCancelWhileArming(t *testing.T) {
+	srv := bitstamptest.NewServer("key", "secret")
+	defer srv.Close()
+
+	srv.SetBalance("usd", 1000)
+
+	m := bitstamp.NewOCOManager(srv.NewClient())
+
+	if _, err := m.PlaceBracket(bitstamp.BracketRequest{
+		Entry:           bitstamp.PlaceOrderRequest{Symbol: "btcusd", Side: bitstamp.Buy, Type: bitstamp.Limit, Price: 20000, Amount: 0.01, ClientOrderID: "br-1"},
+		TakeProfitPrice: 21000,
+		StopPrice:       19000,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Trade("btcusd", bitstamp.Sell, 20000, 0.01)
+	srv.InjectFault(bitstamptest.Fault{Path: "/api/v2/sell/btcusd/", Delay: 200 * time.Millisecond})
+
+	m.HandleFill(legFill(t, srv, "br-1", 1, 0.01))
+
+	// тейк-профит выставляется
+	time.Sleep(50 * time.Millisecond)
+
+	if err := m.Cancel("br-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Close()
+
+	takeProfits := serverOrders(srv, "br-1-tp")
+	if len(takeProfits) != 1 || takeProfits[0].Status != bitstamp.OrderStatusCanceled {
+		t.Fatalf("take profit orders %+v, want one cancelled", takeProfits)
+	}
+
+	// стоп не поставлен на отслеживание
+	m.HandleTrade(bitstamp.Trade{Symbol: "btcusd", Price: 18990, Amount: 0.1})
+	m.Close()
+
+	if stops := serverOrders(srv, "br-1-sl"); len(stops) != 0 {
+		t.Fatalf("stop placed after cancel: %+v", stops)
+	}
+
+	if o, _ := m.OCO("br-1"); o.State != bitstamp.OCOCancelled {
+		t.Fatalf("state = %s, want cancelled", o.State)
+	}
+}
@@ -0,0 +1,647 @@
+package bitstamp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrNoClientOrderID OrderManager отслеживает ордера только по ClientOrderID
+	ErrNoClientOrderID = errors.New("client order id isn't specified")
+	// ErrDuplicateClientOrderID ордер с таким ClientOrderID уже отслеживается
+	ErrDuplicateClientOrderID = errors.New("duplicate client order id")
+	// ErrOrderNotTracked ордер не отслеживается OrderManager
+	ErrOrderNotTracked = errors.New("order isn't tracked")
+)
+
+// OrderState состояние ордера в OrderManager
+type OrderState string
+
+const (
+	// OrderPendingNew ордер отправлен, ответа еще нет
+	OrderPendingNew OrderState = "pending_new"
+	OrderOpen       OrderState = "open"
+	// OrderPartiallyFilled ордер исполнен частично и еще стоит в книге
+	OrderPartiallyFilled OrderState = "partially_filled"
+	OrderFilled          OrderState = "filled"
+	OrderCancelled       OrderState = "cancelled"
+	// OrderRejected Bitstamp отклонил ордер
+	OrderRejected OrderState = "rejected"
+	// OrderUnknown запрос завершился ошибкой транспорта, ордер мог быть выставлен
+	OrderUnknown OrderState = "unknown"
+)
+
+// Terminal ордер в этом состоянии больше не изменится, кроме поздних трейдов отмененного ордера
+func (s OrderState) Terminal() bool {
+	switch s {
+	case OrderFilled, OrderCancelled, OrderRejected:
+		return true
+	default:
+		return false
+	}
+}
+
+// ManagedOrder снимок ордера, отслеживаемого OrderManager
+type ManagedOrder struct {
+	ClientOrderID string
+	ID            int64
+	Symbol        string
+	Side          OrderSide
+	Type          OrderType
+	Price         float64
+	Amount        float64
+	Filled        float64
+	State         OrderState
+	// Fills трейды ордера в порядке получения
+	Fills []Fill
+	// Err причина OrderRejected или OrderUnknown
+	Err       error
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// tradesFilled сумма уникальных трейдов, statusFilled объем по статусу или событию удаления.
+	// Filled наибольший из них: статус может уже включать трейды, которые придут позже
+	tradesFilled float64
+	statusFilled float64
+}
+
+// Remaining неисполненный остаток
+func (o ManagedOrder) Remaining() float64 {
+	if remaining := o.Amount - o.Filled; remaining > paperEpsilon {
+		return remaining
+	}
+
+	return 0
+}
+
+// AvgPrice средняя цена исполнения, 0 если трейдов не было
+func (o ManagedOrder) AvgPrice() float64 {
+	var size, notional float64
+
+	for _, fill := range o.Fills {
+		size += fill.Size
+		notional += fill.Price * fill.Size
+	}
+
+	if size == 0 {
+		return 0
+	}
+
+	return notional / size
+}
+
+// updateFilled пересчитывает Filled
+func (o *ManagedOrder) updateFilled() {
+	o.Filled = math.Max(o.tradesFilled, o.statusFilled)
+}
+
+func (o *ManagedOrder) snapshot() ManagedOrder {
+	result := *o
+	result.Fills = append([]Fill(nil), o.Fills...)
+
+	return result
+}
+
+// OrderUpdate изменение ордера
+type OrderUpdate struct {
+	Order ManagedOrder
+	// Prev состояние до изменения. Для нового ордера пустое
+	Prev OrderState
+}
+
+// OrderManager ведет жизненный цикл ордеров по ответам PlaceOrder, трейдам my_trades,
+// событиям my_orders и опросу GetOrderStatus. Ордера отслеживаются по ClientOrderID.
+//
+//	om := bitstamp.NewOrderManager(client)
+//	ws := bitstamp.NewWSClientWithOptions(symbols, bitstamp.WithOrderEvents(om.HandleOrderEvent))
+//	om.Track(ws)
+type OrderManager struct {
+	api    TradingAPI
+	logger Logger
+	now    func() time.Time
+
+	clientOrderIDs ClientOrderIDFunc
+
+	mu     sync.Mutex
+	orders map[string]*ManagedOrder
+	byID   map[int64]*ManagedOrder
+	trades *recentTrades
+
+	// notifyMu защищает очередь уведомлений. Берется под om.mu, поэтому под notifyMu нельзя брать om.mu
+	notifyMu   sync.Mutex
+	notifying  bool
+	updates    []OrderUpdate
+	handlersMu sync.Mutex
+	handlers   []func(OrderUpdate)
+}
+
+// OrderManagerOption настройка OrderManager
+type OrderManagerOption func(*OrderManager)
+
+// WithOrderManagerLogger задает логгер
+func WithOrderManagerLogger(logger Logger) OrderManagerOption {
+	return func(om *OrderManager) {
+		om.logger = logger
+	}
+}
+
+// WithOrderManagerClock задает источник времени для CreatedAt и UpdatedAt
+func WithOrderManagerClock(now func() time.Time) OrderManagerOption {
+	return func(om *OrderManager) {
+		om.now = now
+	}
+}
+
+// WithOrderManagerClientOrderIDs задает генератор ClientOrderID для PlaceOrder без него
+func WithOrderManagerClientOrderIDs(generate ClientOrderIDFunc) OrderManagerOption {
+	return func(om *OrderManager) {
+		om.clientOrderIDs = generate
+	}
+}
+
+// NewOrderManager создает OrderManager поверх PrivateClient, PaperClient или другой реализации TradingAPI
+func NewOrderManager(api TradingAPI, opts ...OrderManagerOption) *OrderManager {
+	om := &OrderManager{
+		api:    api,
+		logger: NewLogrusLogger(logrus.WithField("provider", "bitstamp").WithField("module", "oms")),
+		now:    time.Now,
+		orders: make(map[string]*ManagedOrder),
+		byID:   make(map[int64]*ManagedOrder),
+		trades: newRecentTrades(recentTradesLimit),
+	}
+
+	for _, opt := range opts {
+		opt(om)
+	}
+
+	om.logger = newRedactingLogger(om.logger)
+
+	return om
+}
+
+// OnUpdate добавляет обработчик изменений ордеров. Обработчики вызываются по очереди в порядке изменений
+// в отдельной горутине, поэтому уведомление может прийти после возврата из метода, изменившего ордер.
+// Из обработчика можно вызывать любые методы OrderManager
+func (om *OrderManager) OnUpdate(handler func(OrderUpdate)) {
+	om.handlersMu.Lock()
+	defer om.handlersMu.Unlock()
+
+	om.handlers = append(om.handlers, handler)
+}
+
+// Track подписывает OrderManager на трейды потока
+func (om *OrderManager) Track(stream FillStream) *FillSubscription {
+	return stream.OnFill(om.HandleFill)
+}
+
+// Order возвращает снимок ордера по ClientOrderID
+func (om *OrderManager) Order(clientOrderID string) (ManagedOrder, bool) {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	o, ok := om.orders[clientOrderID]
+	if !ok {
+		return ManagedOrder{}, false
+	}
+
+	return o.snapshot(), true
+}
+
+// OrderByID возвращает снимок ордера по id Bitstamp
+func (om *OrderManager) OrderByID(id int64) (ManagedOrder, bool) {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	o, ok := om.byID[id]
+	if !ok {
+		return ManagedOrder{}, false
+	}
+
+	return o.snapshot(), true
+}
+
+// Orders возвращает снимки всех отслеживаемых ордеров
+func (om *OrderManager) Orders() []ManagedOrder {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	result := make([]ManagedOrder, 0, len(om.orders))
+	for _, o := range om.orders {
+		result = append(result, o.snapshot())
+	}
+
+	return result
+}
+
+// Active возвращает снимки ордеров, которые еще не в конечном состоянии
+func (om *OrderManager) Active() []ManagedOrder {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	var result []ManagedOrder
+	for _, o := range om.orders {
+		if !o.State.Terminal() {
+			result = append(result, o.snapshot())
+		}
+	}
+
+	return result
+}
+
+// PlaceOrder регистрирует ордер как OrderPendingNew и выставляет его.
+// Отказ Bitstamp (IsRejected) переводит ордер в OrderRejected, любая другая ошибка в OrderUnknown.
+// Пустой ClientOrderID заполняется генератором из WithOrderManagerClientOrderIDs
+func (om *OrderManager) PlaceOrder(req PlaceOrderRequest) (ManagedOrder, error) {
+	req, err := withClientOrderID(req, om.clientOrderIDs)
+	if err != nil {
+		return ManagedOrder{}, err
+	}
+
+	if req.ClientOrderID == "" {
+		return ManagedOrder{}, ErrNoClientOrderID
+	}
+
+	if err := validateOrder(req); err != nil {
+		return ManagedOrder{}, err
+	}
+
+	now := om.now()
+
+	om.mu.Lock()
+	if _, ok := om.orders[req.ClientOrderID]; ok {
+		om.mu.Unlock()
+		return ManagedOrder{}, fmt.Errorf("%w: %s", ErrDuplicateClientOrderID, req.ClientOrderID)
+	}
+
+	o := &ManagedOrder{
+		ClientOrderID: req.ClientOrderID,
+		Symbol:        req.Symbol,
+		Side:          req.Side,
+		Type:          req.Type,
+		Price:         req.Price,
+		Amount:        req.Amount,
+		State:         OrderPendingNew,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	om.orders[o.ClientOrderID] = o
+	om.release(o, "", false)
+
+	result, err := om.api.PlaceOrder(req)
+
+	om.mu.Lock()
+	prev := o.State
+
+	switch {
+	case IsRejected(err):
+		o.Err = err
+		om.setState(o, OrderRejected)
+	case err != nil:
+		o.Err = err
+		om.setState(o, OrderUnknown)
+	default:
+		om.bind(o, result.ID)
+
+		// трейды и события могли прийти раньше ответа. Рыночный, IOC и FOK ордер тоже
+		// считается открытым, пока завершение не придет событием или опросом
+		if o.State == OrderPendingNew || o.State == OrderUnknown {
+			om.setState(o, om.fillState(o, OrderOpen))
+		}
+	}
+
+	return om.release(o, prev, false), err
+}
+
+// Cancel отменяет ордер. Если ордер уже исполнен, состояние не меняется
+func (om *OrderManager) Cancel(clientOrderID string) (ManagedOrder, error) {
+	om.mu.Lock()
+	o, ok := om.orders[clientOrderID]
+	if !ok {
+		om.mu.Unlock()
+		return ManagedOrder{}, fmt.Errorf("%w: %s", ErrOrderNotTracked, clientOrderID)
+	}
+
+	id := o.ID
+	om.mu.Unlock()
+
+	if id == 0 {
+		return ManagedOrder{}, fmt.Errorf("order %s has no exchange id yet", clientOrderID)
+	}
+
+	_, err := om.api.CancelOrder(strconv.FormatInt(id, 10))
+	if err != nil {
+		return om.snapshotOf(o), err
+	}
+
+	om.mu.Lock()
+	prev := o.State
+	if !o.State.Terminal() {
+		om.setState(o, OrderCancelled)
+	}
+	return om.release(o, prev, false), nil
+}
+
+// Poll запрашивает статус ордера через GetOrderStatus и применяет его
+func (om *OrderManager) Poll(clientOrderID string) (ManagedOrder, error) {
+	om.mu.Lock()
+	o, ok := om.orders[clientOrderID]
+	if !ok {
+		om.mu.Unlock()
+		return ManagedOrder{}, fmt.Errorf("%w: %s", ErrOrderNotTracked, clientOrderID)
+	}
+
+	id := o.ID
+	om.mu.Unlock()
+
+	if id == 0 {
+		return om.snapshotOf(o), fmt.Errorf("order %s has no exchange id yet", clientOrderID)
+	}
+
+	status, err := om.api.GetOrderStatus(strconv.FormatInt(id, 10))
+	if err != nil {
+		return om.snapshotOf(o), err
+	}
+
+	return om.ApplyStatus(clientOrderID, status)
+}
+
+// PollActive опрашивает все ордера, которые еще не в конечном состоянии и уже имеют id
+func (om *OrderManager) PollActive() error {
+	var errs []string
+
+	for _, o := range om.Active() {
+		if o.ID == 0 {
+			continue
+		}
+
+		if _, err := om.Poll(o.ClientOrderID); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", o.ClientOrderID, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("could not poll orders: %v", errs)
+	}
+
+	return nil
+}
+
+// ApplyStatus применяет результат GetOrderStatus. Трейды из статуса не добавляются в Fills,
+// потому что у них нет всех полей Fill, но исполненный объем учитывается
+func (om *OrderManager) ApplyStatus(clientOrderID string, status OrderStatusResult) (ManagedOrder, error) {
+	return om.applyStatus(clientOrderID, status, false)
+}
+
+// applyStatus exact задает объем по статусу даже если он меньше полученного ранее статуса.
+// Объем по трейдам не уменьшается
+func (om *OrderManager) applyStatus(clientOrderID string, status OrderStatusResult, exact bool) (ManagedOrder, error) {
+	om.mu.Lock()
+	o, ok := om.orders[clientOrderID]
+	if !ok {
+		om.mu.Unlock()
+		return ManagedOrder{}, fmt.Errorf("%w: %s", ErrOrderNotTracked, clientOrderID)
+	}
+
+	prev := o.State
+
+	if o.ID == 0 && status.ID != 0 {
+		om.bind(o, status.ID)
+	}
+
+	if filled := o.Amount - status.AmountRemaining; filled > o.statusFilled || exact {
+		o.statusFilled = filled
+		o.updateFilled()
+		o.UpdatedAt = om.now()
+	}
+
+	switch status.Status {
+	case OrderStatusOpen:
+		if !o.State.Terminal() {
+			om.setState(o, om.fillState(o, OrderOpen))
+		}
+	case OrderStatusFinished:
+		om.setState(o, OrderFilled)
+	case OrderStatusCanceled, OrderStatusExpired:
+		if o.State != OrderFilled {
+			om.setState(o, OrderCancelled)
+		}
+	}
+
+	return om.release(o, prev, false), nil
+}
+
+// HandleFill учитывает трейд из my_trades. Трейды неизвестных ордеров и повторы пропускаются
+func (om *OrderManager) HandleFill(fill Fill) {
+	om.mu.Lock()
+
+	o := om.find(fill.ClientOrderID, fill.OrderID)
+	if o == nil {
+		om.mu.Unlock()
+		om.logger.WithField("order", fill.OrderID).Debug("fill of untracked order")
+		return
+	}
+
+	if !om.trades.remember(fill.TradeID) {
+		om.mu.Unlock()
+		return
+	}
+
+	if o.ID == 0 {
+		om.bind(o, fill.OrderID)
+	}
+
+	prev := o.State
+
+	o.Fills = append(o.Fills, fill)
+	o.tradesFilled += fill.Size
+	o.updateFilled()
+	o.UpdatedAt = om.now()
+
+	// поздний трейд отмененного ордера учитывается, ордер становится исполненным только целиком
+	if o.State != OrderRejected && o.State != OrderFilled {
+		if o.State == OrderCancelled {
+			if o.Remaining() == 0 {
+				om.setState(o, OrderFilled)
+			}
+		} else {
+			om.setState(o, om.fillState(o, OrderOpen))
+		}
+	}
+
+	om.release(o, prev, true)
+}
+
+// HandleOrderEvent учитывает событие my_orders, подходит для WithOrderEvents
+func (om *OrderManager) HandleOrderEvent(event OrderEvent) {
+	om.mu.Lock()
+
+	o := om.find(event.ClientOrderID, event.ID)
+	if o == nil {
+		om.mu.Unlock()
+		om.logger.WithField("order", event.ID).Debug("event of untracked order")
+		return
+	}
+
+	if o.ID == 0 {
+		om.bind(o, event.ID)
+	}
+
+	prev := o.State
+
+	switch event.Type {
+	case OrderEventCreated, OrderEventChanged:
+		if !o.State.Terminal() {
+			om.setState(o, om.fillState(o, OrderOpen))
+		}
+	case OrderEventDeleted:
+		// нулевой остаток: ордер исполнен, трейды могут прийти позже события
+		if event.Amount <= paperEpsilon {
+			o.statusFilled = o.Amount
+			o.updateFilled()
+
+			om.setState(o, OrderFilled)
+		} else if o.State != OrderFilled {
+			om.setState(o, OrderCancelled)
+		}
+	}
+
+	om.release(o, prev, false)
+}
+
+// adopt связывает отслеживаемый ордер без id с открытым ордером биржи по ClientOrderID.
+// Возвращает false, если ордер не отслеживается или уже связан с другим id
+func (om *OrderManager) adopt(clientOrderID string, id int64) bool {
+	om.mu.Lock()
+	o, ok := om.orders[clientOrderID]
+	if !ok || (o.ID != 0 && o.ID != id) {
+		om.mu.Unlock()
+		return false
+	}
+
+	prev := o.State
+
+	if o.ID == 0 {
+		om.bind(o, id)
+	}
+
+	if o.State == OrderPendingNew || o.State == OrderUnknown {
+		om.setState(o, om.fillState(o, OrderOpen))
+	}
+
+	om.release(o, prev, false)
+
+	return true
+}
+
+// find ищет ордер по ClientOrderID, затем по id. Вызывается под om.mu
+func (om *OrderManager) find(clientOrderID string, id int64) *ManagedOrder {
+	if clientOrderID != "" {
+		if o, ok := om.orders[clientOrderID]; ok {
+			return o
+		}
+	}
+
+	return om.byID[id]
+}
+
+// bind связывает ордер с id Bitstamp. Вызывается под om.mu
+func (om *OrderManager) bind(o *ManagedOrder, id int64) {
+	if id == 0 {
+		return
+	}
+
+	o.ID = id
+	om.byID[id] = o
+}
+
+// fillState состояние живого ордера по исполненному объему. Вызывается под om.mu
+func (om *OrderManager) fillState(o *ManagedOrder, unfilled OrderState) OrderState {
+	switch {
+	case o.Filled <= 0:
+		return unfilled
+	case o.Remaining() == 0:
+		return OrderFilled
+	default:
+		return OrderPartiallyFilled
+	}
+}
+
+// setState меняет состояние ордера. Вызывается под om.mu
+func (om *OrderManager) setState(o *ManagedOrder, state OrderState) {
+	if o.State == state {
+		return
+	}
+
+	om.logger.
+		WithField("client_order_id", o.ClientOrderID).
+		WithField("from", o.State).
+		WithField("to", state).
+		Debug("order state changed")
+
+	o.State = state
+	o.UpdatedAt = om.now()
+}
+
+func (om *OrderManager) snapshotOf(o *ManagedOrder) ManagedOrder {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	return o.snapshot()
+}
+
+// release отпускает om.mu и ставит уведомление в очередь, если состояние изменилось или force.
+// Очередь пополняется под om.mu, поэтому уведомления идут в порядке изменений. Вызывается под om.mu
+func (om *OrderManager) release(o *ManagedOrder, prev OrderState, force bool) ManagedOrder {
+	snapshot := o.snapshot()
+
+	if !force && prev == snapshot.State {
+		om.mu.Unlock()
+		return snapshot
+	}
+
+	om.notifyMu.Lock()
+	om.updates = append(om.updates, OrderUpdate{Order: snapshot, Prev: prev})
+	start := !om.notifying
+	om.notifying = true
+	om.notifyMu.Unlock()
+
+	om.mu.Unlock()
+
+	if start {
+		go om.notify()
+	}
+
+	return snapshot
+}
+
+// notify доставляет уведомления из очереди обработчикам, пока очередь не опустеет.
+// Одновременно работает не больше одной горутины notify
+func (om *OrderManager) notify() {
+	for {
+		om.notifyMu.Lock()
+		if len(om.updates) == 0 {
+			om.notifying = false
+			om.notifyMu.Unlock()
+			return
+		}
+
+		update := om.updates[0]
+		om.updates[0] = OrderUpdate{}
+		om.updates = om.updates[1:]
+		om.notifyMu.Unlock()
+
+		om.handlersMu.Lock()
+		handlers := om.handlers
+		om.handlersMu.Unlock()
+
+		for _, handler := range handlers {
+			handler(update)
+		}
+	}
+}
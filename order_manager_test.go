@@ -0,0 +1,235 @@
+package bitstamp_test
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/b2broker/bitstamp"
+	"github.com/b2broker/bitstamp/bitstamptest"
+)
+
+func TestOrderManagerStates(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(srv *bitstamptest.Server)
+		req    bitstamp.PlaceOrderRequest
+		after  func(om *bitstamp.OrderManager, clientOrderID string) error
+		state  bitstamp.OrderState
+		filled float64
+	}{
+		{
+			name:  "resting limit",
+			req:   bitstamp.PlaceOrderRequest{Symbol: "btcusd", Side: bitstamp.Buy, Type: bitstamp.Limit, Price: 19000, Amount: 0.01},
+			state: bitstamp.OrderOpen,
+		},
+		{
+			name: "rejected by exchange",
+			setup: func(srv *bitstamptest.Server) {
+				srv.InjectFault(bitstamptest.Fault{Path: "/api/v2/buy/btcusd/", Status: http.StatusBadRequest, Code: "400.001", Reason: "Not enough balance"})
+			},
+			req:   bitstamp.PlaceOrderRequest{Symbol: "btcusd", Side: bitstamp.Buy, Type: bitstamp.Limit, Price: 19000, Amount: 0.01},
+			state: bitstamp.OrderRejected,
+		},
+		{
+			name: "rate limited",
+			setup: func(srv *bitstamptest.Server) {
+				srv.InjectFault(bitstamptest.Fault{Path: "/api/v2/buy/btcusd/", Status: http.StatusTooManyRequests})
+			},
+			req:   bitstamp.PlaceOrderRequest{Symbol: "btcusd", Side: bitstamp.Buy, Type: bitstamp.Limit, Price: 19000, Amount: 0.01},
+			state: bitstamp.OrderRejected,
+		},
+		{
+			name: "server error",
+			setup: func(srv *bitstamptest.Server) {
+				srv.InjectFault(bitstamptest.Fault{Path: "/api/v2/buy/btcusd/", Status: http.StatusInternalServerError, Code: "500", Reason: "Internal error"})
+			},
+			req:   bitstamp.PlaceOrderRequest{Symbol: "btcusd", Side: bitstamp.Buy, Type: bitstamp.Limit, Price: 19000, Amount: 0.01},
+			state: bitstamp.OrderUnknown,
+		},
+		{
+			name: "partially filled",
+			setup: func(srv *bitstamptest.Server) {
+				srv.AddLiquidity("btcusd", bitstamp.Sell, 20000, 0.004)
+			},
+			req: bitstamp.PlaceOrderRequest{Symbol: "btcusd", Side: bitstamp.Buy, Type: bitstamp.Limit, Price: 20000, Amount: 0.01},
+			after: func(om *bitstamp.OrderManager, clientOrderID string) error {
+				_, err := om.Poll(clientOrderID)
+				return err
+			},
+			state:  bitstamp.OrderPartiallyFilled,
+			filled: 0.004,
+		},
+		{
+			name: "filled",
+			setup: func(srv *bitstamptest.Server) {
+				srv.AddLiquidity("btcusd", bitstamp.Sell, 20000, 0.01)
+			},
+			req: bitstamp.PlaceOrderRequest{Symbol: "btcusd", Side: bitstamp.Buy, Type: bitstamp.Limit, Price: 20000, Amount: 0.01},
+			after: func(om *bitstamp.OrderManager, clientOrderID string) error {
+				_, err := om.Poll(clientOrderID)
+				return err
+			},
+			state:  bitstamp.OrderFilled,
+			filled: 0.01,
+		},
+		{
+			name: "cancelled",
+			req:  bitstamp.PlaceOrderRequest{Symbol: "btcusd", Side: bitstamp.Buy, Type: bitstamp.Limit, Price: 19000, Amount: 0.01},
+			after: func(om *bitstamp.OrderManager, clientOrderID string) error {
+				_, err := om.Cancel(clientOrderID)
+				return err
+			},
+			state: bitstamp.OrderCancelled,
+		},
+		{
+			name: "expired",
+			req:  bitstamp.PlaceOrderRequest{Symbol: "btcusd", Side: bitstamp.Buy, Type: bitstamp.Limit, Price: 19000, Amount: 0.01},
+			after: func(om *bitstamp.OrderManager, clientOrderID string) error {
+				_, err := om.ApplyStatus(clientOrderID, bitstamp.OrderStatusResult{Status: bitstamp.OrderStatusExpired, AmountRemaining: 0.01})
+				return err
+			},
+			state: bitstamp.OrderCancelled,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			srv := bitstamptest.NewServer("key", "secret")
+			defer srv.Close()
+
+			srv.SetBalance("usd", 1000)
+
+			if tt.setup != nil {
+				tt.setup(srv)
+			}
+
+			om := bitstamp.NewOrderManager(srv.NewClient())
+
+			req := tt.req
+			req.ClientOrderID = "oms-1"
+
+			_, _ = om.PlaceOrder(req)
+
+			if tt.after != nil {
+				if err := tt.after(om, req.ClientOrderID); err != nil {
+					t.Fatalf("after place: %v", err)
+				}
+			}
+
+			o, ok := om.Order(req.ClientOrderID)
+			if !ok {
+				t.Fatal("order isn't tracked")
+			}
+
+			if o.State != tt.state {
+				t.Fatalf("state = %s, want %s (err %v)", o.State, tt.state, o.Err)
+			}
+
+			if diff := o.Filled - tt.filled; diff > 1e-9 || diff < -1e-9 {
+				t.Fatalf("filled = %v, want %v", o.Filled, tt.filled)
+			}
+		})
+	}
+}
+
+// TestOrderManagerHandlerReadsOrders обработчик читает ордера, пока трейды приходят из нескольких горутин
+func TestOrderManagerHandlerReadsOrders(t *testing.T) {
+	srv := bitstamptest.NewServer("key", "secret")
+	defer srv.Close()
+
+	srv.SetBalance("usd", 100000)
+
+	om := bitstamp.NewOrderManager(srv.NewClient())
+
+	const (
+		orders = 4
+		fills  = 50
+	)
+
+	done := make(chan struct{})
+
+	var (
+		mu     sync.Mutex
+		filled = make(map[string]bool)
+		last   = make(map[string]bitstamp.OrderState)
+	)
+
+	om.OnUpdate(func(update bitstamp.OrderUpdate) {
+		// даем другим горутинам изменить ордер, пока обработчик работает
+		time.Sleep(100 * time.Microsecond)
+
+		if _, ok := om.Order(update.Order.ClientOrderID); !ok {
+			t.Errorf("order %s isn't tracked", update.Order.ClientOrderID)
+		}
+
+		_ = om.Orders()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if update.Prev != last[update.Order.ClientOrderID] {
+			t.Errorf("%s: update from %s after %s", update.Order.ClientOrderID, update.Prev, last[update.Order.ClientOrderID])
+		}
+
+		last[update.Order.ClientOrderID] = update.Order.State
+
+		if update.Order.State == bitstamp.OrderFilled && !filled[update.Order.ClientOrderID] {
+			filled[update.Order.ClientOrderID] = true
+			if len(filled) == orders {
+				close(done)
+			}
+		}
+	})
+
+	placed := make([]bitstamp.ManagedOrder, 0, orders)
+
+	for i := 0; i < orders; i++ {
+		o, err := om.PlaceOrder(bitstamp.PlaceOrderRequest{
+			Symbol:        "btcusd",
+			Side:          bitstamp.Buy,
+			Type:          bitstamp.Limit,
+			Price:         19000,
+			Amount:        fills * 0.001,
+			ClientOrderID: "race-" + string(rune('a'+i)),
+		})
+		if err != nil {
+			t.Fatalf("place order: %v", err)
+		}
+
+		placed = append(placed, o)
+	}
+
+	var wg sync.WaitGroup
+
+	for i, o := range placed {
+		wg.Add(1)
+
+		go func(i int, o bitstamp.ManagedOrder) {
+			defer wg.Done()
+
+			for j := 0; j < fills; j++ {
+				om.HandleFill(bitstamp.Fill{
+					OrderID:       o.ID,
+					TradeID:       int64(i*fills + j + 1),
+					ClientOrderID: o.ClientOrderID,
+					Symbol:        "btcusd",
+					Side:          string(bitstamp.Buy),
+					Price:         19000,
+					Size:          0.001,
+				})
+			}
+		}(i, o)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("orders aren't filled, notifications are stuck")
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,763 @@
+package bitstamp
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	paperDatetimeLayout = "2006-01-02 15:04:05"
+	// paperEpsilon остаток меньше этого значения считается нулевым
+	paperEpsilon = 1e-9
+)
+
+// PaperFees комиссии симуляции в долях: 0.003 - 0.3%
+type PaperFees struct {
+	Maker float64
+	Taker float64
+}
+
+func (f PaperFees) max() float64 {
+	return math.Max(f.Maker, f.Taker)
+}
+
+// OrderBookSource источник стакана для симуляции, например PublicClient
+type OrderBookSource interface {
+	GetOrderBook(pair string) (OrderBookResult, error)
+}
+
+type paperOrder struct {
+	id            int64
+	clientOrderID string
+	pair          string
+	side          OrderSide
+	price         float64
+	amount        float64
+	remaining     float64
+	// reserved зарезервированная сумма в котируемой валюте для покупок и в базовой для продаж
+	reserved     float64
+	status       string
+	createdAt    time.Time
+	transactions []OrderStatus
+}
+
+type paperTransaction struct {
+	pair   string
+	at     time.Time
+	result TransactionResult
+}
+
+// PaperClient симуляция PrivateClient без отправки ордеров. Ордера исполняются по живому стакану
+// при выставлении и по публичным сделкам, пока стоят в книге. Трейды доставляются так же, как в Websocket
+type PaperClient struct {
+	book   OrderBookSource
+	fees   PaperFees
+	logger Logger
+	now    func() time.Time
+
+	clientOrderIDs ClientOrderIDFunc
+
+	mu       sync.Mutex
+	nextID   int64
+	balances map[string]float64
+	reserved map[string]float64
+	orders   map[int64]*paperOrder
+	open     []int64
+	// transactions история исполнений в формате user_transactions
+	transactions []paperTransaction
+
+	// emitMu сохраняет порядок трейдов, fillQueue.push вызывается из одной горутины
+	emitMu      sync.Mutex
+	fills       *fillQueue
+	fillsBuffer int
+	fillsPolicy OverflowPolicy
+	subs        subscriptions
+	stopOnce    sync.Once
+}
+
+// PaperOption настройка PaperClient
+type PaperOption func(*PaperClient)
+
+// WithPaperFees задает уровень комиссий аккаунта
+func WithPaperFees(fees PaperFees) PaperOption {
+	return func(pc *PaperClient) {
+		pc.fees = fees
+	}
+}
+
+// WithPaperBalance задает начальный баланс валюты
+func WithPaperBalance(currency string, amount float64) PaperOption {
+	return func(pc *PaperClient) {
+		pc.balances[strings.ToLower(currency)] = amount
+	}
+}
+
+// WithPaperLogger задает логгер
+func WithPaperLogger(logger Logger) PaperOption {
+	return func(pc *PaperClient) {
+		pc.logger = logger
+	}
+}
+
+// WithPaperClock задает источник времени для ордеров и трейдов
+func WithPaperClock(now func() time.Time) PaperOption {
+	return func(pc *PaperClient) {
+		pc.now = now
+	}
+}
+
+// WithPaperClientOrderIDs задает генератор client_order_id для ордеров без него
+func WithPaperClientOrderIDs(generate ClientOrderIDFunc) PaperOption {
+	return func(pc *PaperClient) {
+		pc.clientOrderIDs = generate
+	}
+}
+
+// WithPaperFillsBuffer задает размер буфера канала Fills()
+func WithPaperFillsBuffer(size int) PaperOption {
+	return func(pc *PaperClient) {
+		pc.fillsBuffer = size
+	}
+}
+
+// WithPaperOverflowPolicy задает поведение при заполненном буфере Fills()
+func WithPaperOverflowPolicy(policy OverflowPolicy) PaperOption {
+	return func(pc *PaperClient) {
+		pc.fillsPolicy = policy
+	}
+}
+
+// NewPaperClient создает симуляцию. Чтобы стоящие ордера исполнялись, HandleTrade
+// нужно подписать на публичные сделки: trades.OnTrade(paper.HandleTrade)
+func NewPaperClient(book OrderBookSource, opts ...PaperOption) *PaperClient {
+	pc := &PaperClient{
+		book:        book,
+		fees:        PaperFees{Maker: 0.003, Taker: 0.004},
+		logger:      NewLogrusLogger(logrus.WithField("provider", "bitstamp").WithField("module", "paper")),
+		now:         time.Now,
+		nextID:      time.Now().UnixNano() / int64(time.Microsecond),
+		balances:    make(map[string]float64),
+		reserved:    make(map[string]float64),
+		orders:      make(map[int64]*paperOrder),
+		fillsBuffer: defaultFillsBuffer,
+	}
+
+	for _, opt := range opts {
+		opt(pc)
+	}
+
+	pc.logger = newRedactingLogger(pc.logger)
+	pc.subs.logger = pc.logger
+	pc.fills = newFillQueue(pc.fillsBuffer, pc.fillsPolicy)
+
+	return pc
+}
+
+func (pc *PaperClient) id() int64 {
+	pc.nextID++
+	return pc.nextID
+}
+
+func (pc *PaperClient) available(currency string) float64 {
+	return pc.balances[currency] - pc.reserved[currency]
+}
+
+func orderNotFound() error {
+	return ErrorResult{Status: "error", Reason: "Order not found", Code: codeOrderNotFound}
+}
+
+func insufficientFunds(currency string, need float64, available float64) error {
+	currency = strings.ToUpper(currency)
+
+	return ErrorResult{
+		Status: "error",
+		Reason: map[string][]string{"__all__": {fmt.Sprintf(
+			"You need %.8f %s to open that order. You have only %.8f %s available. Check your account balance for details.",
+			need, currency, available, currency,
+		)}},
+	}
+}
+
+// match уровни стакана, с которыми пересекается ордер, в порядке исполнения
+func match(book OrderBookResult, side OrderSide, price float64, amount float64) []PriceLevel {
+	levels := book.Asks
+	if side == Sell {
+		levels = book.Bids
+	}
+
+	var result []PriceLevel
+
+	for _, level := range levels {
+		if amount <= paperEpsilon {
+			break
+		}
+
+		if price > 0 && (side == Buy && level.Price > price || side == Sell && level.Price < price) {
+			break
+		}
+
+		size := math.Min(level.Amount, amount)
+		result = append(result, PriceLevel{Price: level.Price, Amount: size})
+		amount -= size
+	}
+
+	return result
+}
+
+func paperPlaceOrderResult(o *paperOrder, price float64) PlaceOrderResult {
+	return PlaceOrderResult{
+		ID:            o.id,
+		DateTime:      o.createdAt.UTC().Format(paperDatetimeLayout),
+		Type:          sideType(o.side),
+		Price:         price,
+		Amount:        o.amount,
+		ClientOrderID: o.clientOrderID,
+	}
+}
+
+func sideType(side OrderSide) int {
+	if side == Sell {
+		return OrderSideSell
+	}
+
+	return OrderSideBuy
+}
+
+// PlaceOrder исполняет ордер по текущему стакану. Неисполненный остаток лимитного ордера
+// встает в книгу, остаток рыночного, IOC и FOK ордера отменяется
+func (pc *PaperClient) PlaceOrder(opts PlaceOrderRequest) (PlaceOrderResult, error) {
+	if err := validateOrder(opts); err != nil {
+		return PlaceOrderResult{}, err
+	}
+
+	opts, err := withClientOrderID(opts, pc.clientOrderIDs)
+	if err != nil {
+		return PlaceOrderResult{}, err
+	}
+
+	base, quote := SplitSymbol(opts.Symbol)
+	if quote == "" {
+		return PlaceOrderResult{}, fmt.Errorf("unknown quote currency of %s", opts.Symbol)
+	}
+
+	book, err := pc.book.GetOrderBook(opts.Symbol)
+	if err != nil {
+		return PlaceOrderResult{}, fmt.Errorf("could not get order book: %w", err)
+	}
+
+	price := 0.0
+	if opts.Type == Limit {
+		price = opts.Price
+	}
+
+	levels := match(book, opts.Side, price, opts.Amount)
+
+	matched := 0.0
+	cost := 0.0
+	for _, level := range levels {
+		matched += level.Amount
+		cost += level.Price * level.Amount
+	}
+
+	if opts.ExecType == ExecFOK && matched < opts.Amount-paperEpsilon {
+		levels = nil
+	}
+
+	rests := opts.Type == Limit && opts.ExecType != ExecFOK && opts.ExecType != ExecIOC
+
+	pc.mu.Lock()
+
+	// резерв на весь ордер: по цене ордера для лимитных, по стакану для рыночных
+	var need float64
+	var currency string
+
+	switch {
+	case opts.Side == Sell:
+		currency, need = base, opts.Amount
+	case opts.Type == Market:
+		currency, need = quote, cost*(1+pc.fees.Taker)
+	default:
+		currency, need = quote, opts.Price*opts.Amount*(1+pc.fees.max())
+	}
+
+	if available := pc.available(currency); need > available+paperEpsilon {
+		pc.mu.Unlock()
+		return PlaceOrderResult{}, insufficientFunds(currency, need, available)
+	}
+
+	o := &paperOrder{
+		id:            pc.id(),
+		clientOrderID: opts.ClientOrderID,
+		pair:          opts.Symbol,
+		side:          opts.Side,
+		price:         price,
+		amount:        opts.Amount,
+		remaining:     opts.Amount,
+		reserved:      need,
+		status:        OrderStatusOpen,
+		createdAt:     pc.now(),
+	}
+
+	pc.orders[o.id] = o
+	pc.reserved[currency] += need
+
+	var fills []Fill
+	for _, level := range levels {
+		fills = append(fills, pc.fill(o, 0, level.Price, level.Amount, LiquidityTaker))
+	}
+
+	if o.status == OrderStatusOpen {
+		if rests {
+			pc.open = append(pc.open, o.id)
+		} else {
+			pc.cancel(o)
+		}
+	}
+
+	if price == 0 && len(fills) > 0 {
+		price = fills[len(fills)-1].Price
+	}
+
+	result := paperPlaceOrderResult(o, price)
+
+	pc.mu.Unlock()
+
+	pc.emit(fills)
+
+	return result, nil
+}
+
+// fill исполняет часть ордера и пересчитывает балансы. Вызывается под pc.mu
+func (pc *PaperClient) fill(o *paperOrder, counterID int64, price float64, size float64, liquidity Liquidity) Fill {
+	base, quote := SplitSymbol(o.pair)
+
+	rate := pc.fees.Taker
+	if liquidity == LiquidityMaker {
+		rate = pc.fees.Maker
+	}
+
+	notional := price * size
+	fee := notional * rate
+	now := pc.now()
+
+	// резерв освобождается пропорционально исполненному объему
+	release := o.reserved * size / o.remaining
+	if size >= o.remaining-paperEpsilon {
+		release = o.reserved
+	}
+
+	o.reserved -= release
+	o.remaining -= size
+
+	switch o.side {
+	case Buy:
+		pc.reserved[quote] -= release
+		pc.balances[quote] -= notional + fee
+		pc.balances[base] += size
+	default:
+		pc.reserved[base] -= release
+		pc.balances[base] -= size
+		pc.balances[quote] += notional - fee
+	}
+
+	if o.remaining <= paperEpsilon {
+		o.remaining = 0
+		o.status = OrderStatusFinished
+		pc.removeOpen(o.id)
+	}
+
+	tradeID := pc.id()
+
+	o.transactions = append(o.transactions, OrderStatus{
+		Fee:      fee,
+		Price:    price,
+		Datetime: now.UTC(),
+		Tid:      tradeID,
+		Type:     TransactionTrade,
+		Currencies: map[string]float64{
+			base:  size,
+			quote: notional,
+		},
+	})
+
+	// в user_transactions знак сумм показывает направление движения средств
+	baseAmount, quoteAmount := size, -notional
+	if o.side == Sell {
+		baseAmount, quoteAmount = -size, notional
+	}
+
+	pc.transactions = append(pc.transactions, paperTransaction{
+		pair: o.pair,
+		at:   now,
+		result: TransactionResult{
+			transactionBody: transactionBody{
+				ID:       tradeID,
+				OrderID:  o.id,
+				DateTime: now.UTC().Format(transactionTimeLayout),
+				Type:     TransactionTrade,
+				Fee:      fee,
+			},
+			Amounts: map[string]float64{
+				base:               baseAmount,
+				quote:              quoteAmount,
+				base + "_" + quote: price,
+			},
+		},
+	})
+
+	f := Fill{
+		OrderID:       o.id,
+		TradeID:       tradeID,
+		ClientOrderID: o.clientOrderID,
+		Symbol:        o.pair,
+		Price:         price,
+		Size:          size,
+		Fee:           fee,
+		FeeCurrency:   quote,
+		Side:          string(o.side),
+		Liquidity:     liquidity,
+		FilledAt:      now.UTC().Truncate(time.Microsecond),
+	}
+
+	if o.side == Buy {
+		f.BuyOrderID, f.SellOrderID = o.id, counterID
+	} else {
+		f.BuyOrderID, f.SellOrderID = counterID, o.id
+	}
+
+	return f
+}
+
+// cancel снимает ордер и освобождает резерв. Вызывается под pc.mu
+func (pc *PaperClient) cancel(o *paperOrder) {
+	base, quote := SplitSymbol(o.pair)
+
+	if o.side == Buy {
+		pc.reserved[quote] -= o.reserved
+	} else {
+		pc.reserved[base] -= o.reserved
+	}
+
+	o.reserved = 0
+	o.status = OrderStatusCanceled
+	pc.removeOpen(o.id)
+}
+
+func (pc *PaperClient) removeOpen(id int64) {
+	for i, openID := range pc.open {
+		if openID == id {
+			pc.open = append(pc.open[:i], pc.open[i+1:]...)
+			return
+		}
+	}
+}
+
+func (pc *PaperClient) emit(fills []Fill) {
+	if len(fills) == 0 {
+		return
+	}
+
+	pc.emitMu.Lock()
+	defer pc.emitMu.Unlock()
+
+	for _, f := range fills {
+		pc.subs.publish(f)
+
+		if err := pc.fills.push(f); err != nil {
+			pc.logger.WithError(err).Warn("could not deliver paper fill")
+		}
+	}
+}
+
+// HandleTrade исполняет стоящие ордера, через цену которых прошла публичная сделка.
+// Ордер по цене сделки исполняется, только если агрессор был с противоположной стороны.
+// Объем сделки распределяется по ордерам в порядке цены и времени, исполнение по цене ордера
+func (pc *PaperClient) HandleTrade(trade Trade) {
+	pc.mu.Lock()
+
+	var candidates []*paperOrder
+
+	for _, id := range pc.open {
+		o := pc.orders[id]
+		if o.pair != trade.Symbol {
+			continue
+		}
+
+		crossed := o.side == Buy && (trade.Price < o.price || trade.Price == o.price && trade.Side == Sell) ||
+			o.side == Sell && (trade.Price > o.price || trade.Price == o.price && trade.Side == Buy)
+
+		if crossed {
+			candidates = append(candidates, o)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].side == Buy {
+			return candidates[i].price > candidates[j].price
+		}
+
+		return candidates[i].price < candidates[j].price
+	})
+
+	left := trade.Amount
+
+	var fills []Fill
+
+	for _, o := range candidates {
+		if left <= paperEpsilon {
+			break
+		}
+
+		counterID := trade.SellOrderID
+		if o.side == Sell {
+			counterID = trade.BuyOrderID
+		}
+
+		size := math.Min(o.remaining, left)
+		left -= size
+
+		fills = append(fills, pc.fill(o, counterID, o.price, size, LiquidityMaker))
+	}
+
+	pc.mu.Unlock()
+
+	pc.emit(fills)
+}
+
+func (pc *PaperClient) findOrder(id string) (*paperOrder, error) {
+	orderID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, orderNotFound()
+	}
+
+	o, ok := pc.orders[orderID]
+	if !ok {
+		return nil, orderNotFound()
+	}
+
+	return o, nil
+}
+
+func (pc *PaperClient) CancelOrder(id string) (OrderCancelResult, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	o, err := pc.findOrder(id)
+	if err != nil {
+		return OrderCancelResult{}, err
+	}
+
+	return pc.cancelOpen(o)
+}
+
+func (pc *PaperClient) CancelOrderByClientOrderID(clientOrderID string) (OrderCancelResult, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	o := pc.findByClientOrderID(clientOrderID)
+	if o == nil {
+		return OrderCancelResult{}, orderNotFound()
+	}
+
+	return pc.cancelOpen(o)
+}
+
+// cancelOpen отменяет открытый ордер. Вызывается под pc.mu
+func (pc *PaperClient) cancelOpen(o *paperOrder) (OrderCancelResult, error) {
+	if o.status != OrderStatusOpen {
+		return OrderCancelResult{}, orderNotFound()
+	}
+
+	pc.cancel(o)
+
+	return OrderCancelResult{
+		ID:     strconv.FormatInt(o.id, 10),
+		Amount: o.remaining,
+		Price:  o.price,
+		Type:   sideType(o.side),
+	}, nil
+}
+
+func (pc *PaperClient) CancelAllOrders() (CancelAllOrdersResult, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	result := CancelAllOrdersResult{Success: true, Canceled: []interface{}{}}
+
+	for _, id := range append([]int64(nil), pc.open...) {
+		o := pc.orders[id]
+		pc.cancel(o)
+
+		result.Canceled = append(result.Canceled, map[string]interface{}{
+			"id":            o.id,
+			"amount":        o.remaining,
+			"price":         o.price,
+			"type":          sideType(o.side),
+			"currency_pair": currencyPair(o.pair),
+		})
+	}
+
+	return result, nil
+}
+
+func currencyPair(pair string) string {
+	base, quote := SplitSymbol(pair)
+	return strings.ToUpper(base) + "/" + strings.ToUpper(quote)
+}
+
+func (pc *PaperClient) GetOpenOrders() ([]OpenOrderResult, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	result := make([]OpenOrderResult, 0, len(pc.open))
+
+	for _, id := range pc.open {
+		o := pc.orders[id]
+
+		result = append(result, OpenOrderResult{
+			ID:            o.id,
+			DateTime:      o.createdAt.UTC().Format(paperDatetimeLayout),
+			Type:          sideType(o.side),
+			Price:         o.price,
+			Amount:        o.remaining,
+			CurrencyPair:  currencyPair(o.pair),
+			ClientOrderID: o.clientOrderID,
+		})
+	}
+
+	return result, nil
+}
+
+func (pc *PaperClient) GetOrderStatus(id string) (OrderStatusResult, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	o, err := pc.findOrder(id)
+	if err != nil {
+		return OrderStatusResult{}, err
+	}
+
+	return OrderStatusResult{
+		Status:          o.status,
+		ID:              o.id,
+		AmountRemaining: o.remaining,
+		Transactions:    append([]OrderStatus(nil), o.transactions...),
+	}, nil
+}
+
+func (pc *PaperClient) GetOrderStatusByClientOrderID(clientOrderID string) (OrderStatusResult, error) {
+	pc.mu.Lock()
+	found := pc.findByClientOrderID(clientOrderID)
+	pc.mu.Unlock()
+
+	if found == nil {
+		return OrderStatusResult{}, orderNotFound()
+	}
+
+	return pc.GetOrderStatus(strconv.FormatInt(found.id, 10))
+}
+
+// findByClientOrderID последний ордер с client_order_id. Вызывается под pc.mu
+func (pc *PaperClient) findByClientOrderID(clientOrderID string) *paperOrder {
+	var found *paperOrder
+
+	for _, o := range pc.orders {
+		if clientOrderID != "" && o.clientOrderID == clientOrderID && (found == nil || o.id > found.id) {
+			found = o
+		}
+	}
+
+	return found
+}
+
+// GetBalances возвращает полные балансы с учетом исполненных ордеров
+func (pc *PaperClient) GetBalances() (BalanceResult, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	result := make(BalanceResult, len(pc.balances))
+	for currency, amount := range pc.balances {
+		result[currency] = amount
+	}
+
+	return result, nil
+}
+
+// GetAccountBalances возвращает балансы с резервом открытых ордеров. Комиссия за вывод не симулируется
+func (pc *PaperClient) GetAccountBalances() (Balances, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	result := make(Balances, len(pc.balances))
+	for currency, amount := range pc.balances {
+		result[currency] = Balance{
+			Total:     amount,
+			Available: pc.available(currency),
+			Reserved:  pc.reserved[currency],
+		}
+	}
+
+	return result, nil
+}
+
+// GetTransactions возвращает симулированные трейды, новые первыми, как user_transactions
+func (pc *PaperClient) GetTransactions() ([]TransactionResult, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	result := make([]TransactionResult, 0, len(pc.transactions))
+	for i := len(pc.transactions) - 1; i >= 0; i-- {
+		result = append(result, pc.transactions[i].result)
+	}
+
+	return result, nil
+}
+
+// GetPairTransactions возвращает симулированные трейды по паре начиная с момента since в порядке возрастания
+func (pc *PaperClient) GetPairTransactions(pair string, since time.Time) ([]TransactionResult, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	var result []TransactionResult
+
+	for _, transaction := range pc.transactions {
+		if transaction.pair == pair && !transaction.at.Before(since) {
+			result = append(result, transaction.result)
+		}
+	}
+
+	return result, nil
+}
+
+// Fills возвращает канал симулированных трейдов. Канал закрывается после Stop()
+func (pc *PaperClient) Fills() <-chan Fill {
+	return pc.fills.ch
+}
+
+// NewFillSubscription создает подписку на все симулированные трейды
+func (pc *PaperClient) NewFillSubscription(buffer int, policy OverflowPolicy) *FillSubscription {
+	return pc.subs.add(buffer, policy)
+}
+
+// OnFill вызывает handler для каждого симулированного трейда в отдельной горутине
+func (pc *PaperClient) OnFill(handler func(Fill)) *FillSubscription {
+	return pc.subs.onFill(handler)
+}
+
+// Stop закрывает канал Fills() и подписки после доставки оставшихся трейдов
+func (pc *PaperClient) Stop() {
+	pc.stopOnce.Do(func() {
+		pc.emitMu.Lock()
+		defer pc.emitMu.Unlock()
+
+		pc.fills.close()
+		pc.subs.close()
+	})
+}
@@ -0,0 +1,418 @@
+package bitstamp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrPositionTrackerStopped Run завершился после Stop()
+var ErrPositionTrackerStopped = errors.New("position tracker stopped")
+
+// CostingMethod способ списания себестоимости при закрытии позиции
+type CostingMethod string
+
+const (
+	// CostFIFO закрываются самые ранние открытия
+	CostFIFO CostingMethod = "fifo"
+	// CostLIFO закрываются самые поздние открытия
+	CostLIFO CostingMethod = "lifo"
+	// CostAverage закрытие по средней цене входа
+	CostAverage CostingMethod = "average"
+)
+
+// TickerSource источник тикера для оценки позиций. Реализует PublicClient
+type TickerSource interface {
+	GetTicker(pair string) (TickerResult, error)
+}
+
+// Position позиция по символу. PnL в валюте котировки
+type Position struct {
+	Symbol string
+	// Quantity чистая позиция в базовой валюте, отрицательная для короткой
+	Quantity float64
+	// AvgEntryPrice средняя цена входа открытой части позиции
+	AvgEntryPrice float64
+	// RealizedPnL реализованный результат за вычетом комиссий
+	RealizedPnL float64
+	Fees        float64
+	// UnrealizedPnL результат открытой части по MarkPrice
+	UnrealizedPnL float64
+	MarkPrice     float64
+	MarkedAt      time.Time
+}
+
+// lot открытие позиции. quantity со знаком позиции
+type lot struct {
+	quantity float64
+	price    float64
+}
+
+type positionState struct {
+	lots     []lot
+	realized float64
+	fees     float64
+	mark     float64
+	markedAt time.Time
+}
+
+// PositionTracker ведет позиции и PnL по трейдам HandleFill и истории LoadTransactions.
+// id транзакций user_transactions не совпадают с id трейдов my_trades, поэтому трейд, полученный
+// из обоих источников, сопоставляется по ордеру, стороне, цене и объему, как при восстановлении трейдов.
+// Для сопоставления хранятся последние recentTradesLimit трейдов.
+// GetTransactions возвращает одну страницу, история загружается через GetPairTransactions
+//
+//	positions := bitstamp.NewPositionTracker(bitstamp.WithPositionTicker(public))
+//	ws.OnFill(positions.HandleFill)
+//	transactions, _ := client.GetPairTransactions("btcusd", time.Time{})
+//	positions.LoadTransactions(transactions)
+//	go positions.Run(time.Second * 10)
+type PositionTracker struct {
+	method CostingMethod
+	ticker TickerSource
+	logger Logger
+
+	mu        sync.Mutex
+	positions map[string]*positionState
+	dedup     *fillDedup
+
+	stopMu sync.Mutex
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// PositionOption настройка PositionTracker
+type PositionOption func(*PositionTracker)
+
+// WithPositionCosting задает способ списания себестоимости, по умолчанию CostFIFO
+func WithPositionCosting(method CostingMethod) PositionOption {
+	return func(pt *PositionTracker) {
+		pt.method = method
+	}
+}
+
+// WithPositionTicker задает тикер для MarkAll и Run
+func WithPositionTicker(ticker TickerSource) PositionOption {
+	return func(pt *PositionTracker) {
+		pt.ticker = ticker
+	}
+}
+
+// WithPositionLogger задает логгер
+func WithPositionLogger(logger Logger) PositionOption {
+	return func(pt *PositionTracker) {
+		pt.logger = logger
+	}
+}
+
+// NewPositionTracker создает трекер позиций
+func NewPositionTracker(opts ...PositionOption) *PositionTracker {
+	pt := &PositionTracker{
+		method:    CostFIFO,
+		logger:    NewLogrusLogger(logrus.WithField("provider", "bitstamp").WithField("module", "positions")),
+		positions: make(map[string]*positionState),
+		dedup:     newFillDedup(recentTradesLimit),
+		stop:      make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(pt)
+	}
+
+	pt.logger = newRedactingLogger(pt.logger)
+
+	return pt
+}
+
+// HandleFill применяет трейд. Повторный трейд и трейд, уже загруженный LoadTransactions, пропускаются.
+// Подходит для FillStream.OnFill
+func (pt *PositionTracker) HandleFill(fill Fill) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	pt.apply(fill)
+}
+
+// LoadTransactions применяет трейды из GetPairTransactions или GetTransactions в порядке времени.
+// Остальные транзакции и трейды, уже полученные HandleFill, пропускаются
+func (pt *PositionTracker) LoadTransactions(transactions []TransactionResult) error {
+	fills := make([]Fill, 0, len(transactions))
+
+	for _, transaction := range transactions {
+		if transaction.Type != TransactionTrade {
+			continue
+		}
+
+		symbol := transactionSymbol(transaction)
+		if symbol == "" {
+			return fmt.Errorf("no currency pair in transaction %d", transaction.ID)
+		}
+
+		fill, err := transactionToFill(symbol, transaction)
+		if err != nil {
+			return fmt.Errorf("transaction %d: %w", transaction.ID, err)
+		}
+
+		fills = append(fills, fill)
+	}
+
+	sort.SliceStable(fills, func(a, b int) bool {
+		if fills[a].FilledAt.Equal(fills[b].FilledAt) {
+			return fills[a].TradeID < fills[b].TradeID
+		}
+
+		return fills[a].FilledAt.Before(fills[b].FilledAt)
+	})
+
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	for _, fill := range fills {
+		pt.apply(fill)
+	}
+
+	return nil
+}
+
+// transactionSymbol символ трейда по ключу цены вида btc_usd
+func transactionSymbol(transaction TransactionResult) string {
+	for key := range transaction.Amounts {
+		parts := strings.Split(key, "_")
+		if len(parts) != 2 {
+			continue
+		}
+
+		if _, ok := transaction.Amounts[parts[0]]; !ok {
+			continue
+		}
+
+		if _, ok := transaction.Amounts[parts[1]]; !ok {
+			continue
+		}
+
+		return parts[0] + parts[1]
+	}
+
+	return ""
+}
+
+// apply вызывается под pt.mu
+func (pt *PositionTracker) apply(fill Fill) {
+	if !pt.dedup.remember(fill) {
+		return
+	}
+
+	state, ok := pt.positions[fill.Symbol]
+	if !ok {
+		state = &positionState{}
+		pt.positions[fill.Symbol] = state
+	}
+
+	fee := fill.Fee
+	if base, _ := SplitSymbol(fill.Symbol); fill.FeeCurrency == base {
+		fee *= fill.Price
+	}
+
+	state.fees += fee
+	state.realized -= fee
+
+	quantity := fill.Size
+	if fill.Side == string(Sell) {
+		quantity = -quantity
+	}
+
+	// закрытие позиции, противоположной трейду
+	for len(state.lots) > 0 && quantity != 0 && sign(state.lots[0].quantity) != sign(quantity) {
+		i := 0
+		if pt.method == CostLIFO {
+			i = len(state.lots) - 1
+		}
+
+		l := &state.lots[i]
+		closed := math.Min(math.Abs(quantity), math.Abs(l.quantity)) * sign(l.quantity)
+
+		state.realized += closed * (fill.Price - l.price)
+		l.quantity -= closed
+		quantity += closed
+
+		if math.Abs(l.quantity) <= paperEpsilon {
+			state.lots = append(state.lots[:i], state.lots[i+1:]...)
+		}
+
+		if math.Abs(quantity) <= paperEpsilon {
+			quantity = 0
+		}
+	}
+
+	if quantity == 0 {
+		return
+	}
+
+	if pt.method == CostAverage && len(state.lots) > 0 {
+		l := &state.lots[0]
+		total := l.quantity + quantity
+		l.price = (l.quantity*l.price + quantity*fill.Price) / total
+		l.quantity = total
+
+		return
+	}
+
+	state.lots = append(state.lots, lot{quantity: quantity, price: fill.Price})
+}
+
+func sign(x float64) float64 {
+	if x < 0 {
+		return -1
+	}
+
+	return 1
+}
+
+// Mark задает цену оценки позиции
+func (pt *PositionTracker) Mark(symbol string, price float64) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	state, ok := pt.positions[symbol]
+	if !ok {
+		state = &positionState{}
+		pt.positions[symbol] = state
+	}
+
+	state.mark = price
+	state.markedAt = time.Now()
+}
+
+// MarkAll оценивает все позиции по последней цене тикера из WithPositionTicker
+func (pt *PositionTracker) MarkAll() error {
+	if pt.ticker == nil {
+		return fmt.Errorf("ticker isn't specified")
+	}
+
+	pt.mu.Lock()
+	symbols := make([]string, 0, len(pt.positions))
+	for symbol := range pt.positions {
+		symbols = append(symbols, symbol)
+	}
+	pt.mu.Unlock()
+
+	var errs []string
+
+	for _, symbol := range symbols {
+		ticker, err := pt.ticker.GetTicker(symbol)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", symbol, err))
+			continue
+		}
+
+		pt.Mark(symbol, ticker.Last)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("could not get tickers: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Run оценивает позиции каждые interval до вызова Stop(). Ошибки логируются, цикл продолжается
+func (pt *PositionTracker) Run(interval time.Duration) error {
+	pt.stopMu.Lock()
+	select {
+	case <-pt.stop:
+		pt.stopMu.Unlock()
+		return ErrPositionTrackerStopped
+	default:
+		pt.wg.Add(1)
+		defer pt.wg.Done()
+	}
+	pt.stopMu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := pt.MarkAll(); err != nil {
+			pt.logger.WithError(err).Error("could not mark positions")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-pt.stop:
+			return ErrPositionTrackerStopped
+		}
+	}
+}
+
+// Stop останавливает Run
+func (pt *PositionTracker) Stop() {
+	pt.stopMu.Lock()
+	select {
+	case <-pt.stop:
+	default:
+		close(pt.stop)
+	}
+	pt.stopMu.Unlock()
+	pt.wg.Wait()
+}
+
+// Position возвращает позицию по символу
+func (pt *PositionTracker) Position(symbol string) Position {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	state, ok := pt.positions[symbol]
+	if !ok {
+		return Position{Symbol: symbol}
+	}
+
+	return state.position(symbol)
+}
+
+// Positions возвращает все позиции
+func (pt *PositionTracker) Positions() []Position {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	result := make([]Position, 0, len(pt.positions))
+	for symbol, state := range pt.positions {
+		result = append(result, state.position(symbol))
+	}
+
+	sort.Slice(result, func(a, b int) bool { return result[a].Symbol < result[b].Symbol })
+
+	return result
+}
+
+func (s *positionState) position(symbol string) Position {
+	p := Position{
+		Symbol:      symbol,
+		RealizedPnL: s.realized,
+		Fees:        s.fees,
+		MarkPrice:   s.mark,
+		MarkedAt:    s.markedAt,
+	}
+
+	var cost float64
+
+	for _, l := range s.lots {
+		p.Quantity += l.quantity
+		cost += l.quantity * l.price
+	}
+
+	if p.Quantity != 0 {
+		p.AvgEntryPrice = cost / p.Quantity
+	}
+
+	if s.mark > 0 {
+		p.UnrealizedPnL = p.Quantity*s.mark - cost
+	}
+
+	return p
+}
@@ -0,0 +1,166 @@
+package bitstamp_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/b2broker/bitstamp"
+	"github.com/b2broker/bitstamp/bitstamptest"
+)
+
+func positionFill(id int64, side bitstamp.OrderSide, price float64, size float64, fee float64) bitstamp.Fill {
+	return bitstamp.Fill{
+		OrderID:     id,
+		TradeID:     id,
+		Symbol:      "btcusd",
+		Side:        string(side),
+		Price:       price,
+		Size:        size,
+		Fee:         fee,
+		FeeCurrency: "usd",
+	}
+}
+
+func TestPositionTrackerPnL(t *testing.T) {
+	longThenSell := []bitstamp.Fill{
+		positionFill(1, bitstamp.Buy, 100, 1, 1),
+		positionFill(2, bitstamp.Buy, 200, 1, 1),
+		positionFill(3, bitstamp.Sell, 300, 1, 1),
+	}
+
+	flip := []bitstamp.Fill{
+		positionFill(1, bitstamp.Buy, 100, 1, 0),
+		positionFill(2, bitstamp.Buy, 200, 1, 0),
+		positionFill(3, bitstamp.Sell, 300, 3, 0),
+	}
+
+	tests := []struct {
+		name     string
+		method   bitstamp.CostingMethod
+		fills    []bitstamp.Fill
+		mark     float64
+		quantity float64
+		entry    float64
+		realized float64
+		fees     float64
+		// unrealized результат открытой части по mark
+		unrealized float64
+	}{
+		{name: "fifo", method: bitstamp.CostFIFO, fills: longThenSell, mark: 250, quantity: 1, entry: 200, realized: 197, fees: 3, unrealized: 50},
+		{name: "lifo", method: bitstamp.CostLIFO, fills: longThenSell, mark: 250, quantity: 1, entry: 100, realized: 97, fees: 3, unrealized: 150},
+		{name: "average", method: bitstamp.CostAverage, fills: longThenSell, mark: 250, quantity: 1, entry: 150, realized: 147, fees: 3, unrealized: 100},
+		{name: "fifo flip to short", method: bitstamp.CostFIFO, fills: flip, mark: 250, quantity: -1, entry: 300, realized: 300, unrealized: 50},
+		{name: "lifo flip to short", method: bitstamp.CostLIFO, fills: flip, mark: 250, quantity: -1, entry: 300, realized: 300, unrealized: 50},
+		{name: "average flip to short", method: bitstamp.CostAverage, fills: flip, mark: 250, quantity: -1, entry: 300, realized: 300, unrealized: 50},
+		{
+			name:   "fee in base currency",
+			method: bitstamp.CostFIFO,
+			fills: []bitstamp.Fill{
+				{OrderID: 1, TradeID: 1, Symbol: "btcusd", Side: "buy", Price: 100, Size: 1, Fee: 0.01, FeeCurrency: "btc"},
+				{OrderID: 2, TradeID: 2, Symbol: "btcusd", Side: "sell", Price: 110, Size: 1},
+			},
+			realized: 9,
+			fees:     1,
+		},
+		{
+			name:   "duplicated fills",
+			method: bitstamp.CostFIFO,
+			fills: []bitstamp.Fill{
+				positionFill(1, bitstamp.Buy, 100, 1, 0),
+				positionFill(1, bitstamp.Buy, 100, 1, 0),
+				positionFill(2, bitstamp.Sell, 150, 1, 0),
+				positionFill(2, bitstamp.Sell, 150, 1, 0),
+			},
+			realized: 50,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			pt := bitstamp.NewPositionTracker(bitstamp.WithPositionCosting(tt.method))
+
+			for _, fill := range tt.fills {
+				pt.HandleFill(fill)
+			}
+
+			if tt.mark > 0 {
+				pt.Mark("btcusd", tt.mark)
+			}
+
+			p := pt.Position("btcusd")
+
+			checks := []struct {
+				field     string
+				got, want float64
+			}{
+				{"quantity", p.Quantity, tt.quantity},
+				{"entry", p.AvgEntryPrice, tt.entry},
+				{"realized", p.RealizedPnL, tt.realized},
+				{"fees", p.Fees, tt.fees},
+				{"unrealized", p.UnrealizedPnL, tt.unrealized},
+			}
+
+			for _, c := range checks {
+				if math.Abs(c.got-c.want) > 1e-9 {
+					t.Fatalf("%s = %v, want %v (%+v)", c.field, c.got, c.want, p)
+				}
+			}
+		})
+	}
+}
+
+// TestPositionTrackerHistoryAndLive трейды из user_transactions и из my_trades учитываются один раз
+func TestPositionTrackerHistoryAndLive(t *testing.T) {
+	srv := bitstamptest.NewServer("key", "secret")
+	defer srv.Close()
+
+	srv.SetBalance("usd", 10000)
+	srv.SetBalance("btc", 1)
+
+	client := srv.NewClient()
+	since := time.Now().Add(-time.Minute)
+
+	srv.AddLiquidity("btcusd", bitstamp.Sell, 20000, 0.1)
+
+	buy, err := client.PlaceOrder(bitstamp.PlaceOrderRequest{Symbol: "btcusd", Side: bitstamp.Buy, Type: bitstamp.Market, Amount: 0.1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.AddLiquidity("btcusd", bitstamp.Buy, 21000, 0.05)
+
+	sell, err := client.PlaceOrder(bitstamp.PlaceOrderRequest{Symbol: "btcusd", Side: bitstamp.Sell, Type: bitstamp.Market, Amount: 0.05})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transactions, err := client.GetPairTransactions("btcusd", since)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pt := bitstamp.NewPositionTracker()
+
+	// live трейды пришли до загрузки истории, но с другими id
+	pt.HandleFill(bitstamp.Fill{OrderID: buy.ID, TradeID: 1 << 40, Symbol: "btcusd", Side: "buy", Price: 20000, Size: 0.1})
+
+	if err := pt.LoadTransactions(transactions); err != nil {
+		t.Fatal(err)
+	}
+
+	pt.HandleFill(bitstamp.Fill{OrderID: sell.ID, TradeID: 1<<40 + 1, Symbol: "btcusd", Side: "sell", Price: 21000, Size: 0.05})
+
+	// повторная загрузка истории ничего не меняет
+	if err := pt.LoadTransactions(transactions); err != nil {
+		t.Fatal(err)
+	}
+
+	p := pt.Position("btcusd")
+
+	if math.Abs(p.Quantity-0.05) > 1e-9 || math.Abs(p.RealizedPnL-50) > 1e-6 {
+		t.Fatalf("position = %+v, want quantity 0.05 and realized 50", p)
+	}
+}
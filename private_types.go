@@ -33,11 +33,53 @@ type OpenOrderResult struct {
 	Price        float64 `json:"price,string"`
 	Amount       float64 `json:"amount,string"`
 	CurrencyPair string  `json:"currency_pair"`
+	// ClientOrderID пустой, если ордер выставлен без client_order_id
+	ClientOrderID string `json:"client_order_id"`
 }
 
 func (br *BalanceResult) UnmarshalJSON(data []byte) error {
+	var balances Balances
+
+	if err := json.Unmarshal(data, &balances); err != nil {
+		return err
+	}
+
+	*br = make(map[string]float64, len(balances))
+
+	for currency, balance := range balances {
+		(*br)[currency] = balance.Total
+	}
+
+	return nil
+}
+
+// Balance баланс валюты. Available = Total - Reserved, Reserved заблокировано открытыми ордерами
+type Balance struct {
+	Total         float64
+	Available     float64
+	Reserved      float64
+	WithdrawalFee float64
+}
+
+// Balances полные балансы по валютам
+type Balances map[string]Balance
+
+// balanceFields суффиксы ключей ответа /api/v2/balance/. _withdrawal_fee проверяется раньше, чем
+// комиссия пары вида btcusd_fee, которая пропускается
+var balanceFields = []struct {
+	suffix string
+	set    func(b *Balance, value float64)
+}{
+	{"_withdrawal_fee", func(b *Balance, value float64) { b.WithdrawalFee = value }},
+	{"_balance", func(b *Balance, value float64) { b.Total = value }},
+	{"_available", func(b *Balance, value float64) { b.Available = value }},
+	{"_reserved", func(b *Balance, value float64) { b.Reserved = value }},
+}
+
+// {"usd_balance": "100.00", "usd_available": "90.00", "usd_reserved": "10.00", "usd_withdrawal_fee": "25.00", "btcusd_fee": "0.400"}
+func (b *Balances) UnmarshalJSON(data []byte) error {
 	t := make(map[string]interface{})
-	*br = make(map[string]float64)
+	*b = make(Balances)
 
 	err := json.Unmarshal(data, &t)
 	if err != nil {
@@ -47,28 +89,33 @@ func (br *BalanceResult) UnmarshalJSON(data []byte) error {
 	for key, value := range t {
 		key = strings.ToLower(key)
 
-		if !strings.HasSuffix(key, "_balance") {
-			continue
-		}
+		for _, field := range balanceFields {
+			if !strings.HasSuffix(key, field.suffix) {
+				continue
+			}
 
-		key = strings.Replace(key, "_balance", "", 1)
+			var parsedValue float64
 
-		var parsedValue float64
+			switch pp := value.(type) {
+			case string:
+				tmpFloat, err := strconv.ParseFloat(pp, 64)
+				if err != nil {
+					return err
+				}
 
-		switch pp := value.(type) {
-		case string:
-			tmpFloat, err := strconv.ParseFloat(pp, 64)
-			if err != nil {
-				return err
+				parsedValue = tmpFloat
+
+			case float64:
+				parsedValue = pp
 			}
 
-			parsedValue = tmpFloat
+			currency := strings.TrimSuffix(key, field.suffix)
+			balance := (*b)[currency]
+			field.set(&balance, parsedValue)
+			(*b)[currency] = balance
 
-		case float64:
-			parsedValue = pp
+			break
 		}
-
-		(*br)[key] = parsedValue
 	}
 
 	return nil
@@ -304,6 +351,8 @@ const (
 	OrderStatusFinished = "Finished"
 	OrderStatusOpen     = "Open"
 	OrderStatusCanceled = "Canceled"
+	// OrderStatusExpired ордер снят по истечении срока (daily, GTD)
+	OrderStatusExpired = "Expired"
 
 	TransactionDeposit    = 0
 	TransactionWithdrawal = 1
@@ -0,0 +1,173 @@
+package bitstamp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/crxfoz/webclient"
+)
+
+// PublicClient клиент публичного REST API, не требует ключей
+type PublicClient struct {
+	client  *webclient.Webclient
+	baseURL string
+}
+
+// PublicOption настройка PublicClient
+type PublicOption func(*PublicClient)
+
+// WithPublicBaseURL задает адрес API, например фейкового сервера из bitstamptest
+func WithPublicBaseURL(baseURL string) PublicOption {
+	return func(c *PublicClient) {
+		c.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// NewPublicClient создает клиент публичного API
+func NewPublicClient(opts ...PublicOption) *PublicClient {
+	c := &PublicClient{
+		client: webclient.Config{
+			Timeout:        time.Second * 10,
+			UseKeepAlive:   false,
+			FollowRedirect: false,
+		}.New(),
+		baseURL: bitstampAPI,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// TickerResult тикер пары.
+// {"last": "20512.00", "bid": "20511.00", "ask": "20513.00", "high": "20700.00", "low": "20300.00", "open": "20400.00", "vwap": "20480.11", "volume": "1520.11000000", "timestamp": "1667474425"}
+type TickerResult struct {
+	Last      float64 `json:"last,string"`
+	Bid       float64 `json:"bid,string"`
+	Ask       float64 `json:"ask,string"`
+	High      float64 `json:"high,string"`
+	Low       float64 `json:"low,string"`
+	Open      float64 `json:"open,string"`
+	VWAP      float64 `json:"vwap,string"`
+	Volume    float64 `json:"volume,string"`
+	Timestamp int64   `json:"timestamp,string"`
+}
+
+// PriceLevel уровень стакана
+type PriceLevel struct {
+	Price  float64
+	Amount float64
+}
+
+// OrderBookResult стакан пары. Bids по убыванию цены, Asks по возрастанию.
+// {"timestamp": "1667474425", "microtimestamp": "1667474425458123", "bids": [["20511.00", "0.10000000"]], "asks": [["20513.00", "0.25000000"]]}
+type OrderBookResult struct {
+	Timestamp time.Time
+	Bids      []PriceLevel
+	Asks      []PriceLevel
+}
+
+type orderBookBody struct {
+	Microtimestamp json.Number `json:"microtimestamp"`
+	Bids           [][]string  `json:"bids"`
+	Asks           [][]string  `json:"asks"`
+}
+
+func (ob *OrderBookResult) UnmarshalJSON(data []byte) error {
+	var body orderBookBody
+
+	if err := json.Unmarshal(data, &body); err != nil {
+		return err
+	}
+
+	micro, err := numberToInt(body.Microtimestamp)
+	if err != nil {
+		return err
+	}
+
+	ob.Timestamp = time.Unix(0, micro*int64(time.Microsecond)).UTC()
+
+	if ob.Bids, err = parseLevels(body.Bids); err != nil {
+		return err
+	}
+
+	if ob.Asks, err = parseLevels(body.Asks); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func parseLevels(raw [][]string) ([]PriceLevel, error) {
+	levels := make([]PriceLevel, 0, len(raw))
+
+	for _, level := range raw {
+		if len(level) < 2 {
+			return nil, fmt.Errorf("wrong order book level %v", level)
+		}
+
+		price, err := interfaceToFloat(level[0])
+		if err != nil {
+			return nil, err
+		}
+
+		amount, err := interfaceToFloat(level[1])
+		if err != nil {
+			return nil, err
+		}
+
+		levels = append(levels, PriceLevel{Price: price, Amount: amount})
+	}
+
+	return levels, nil
+}
+
+func (c *PublicClient) get(path string, result interface{}) error {
+	resp, body, err := c.client.Get(c.baseURL + path).Do()
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return ErrRateLimited
+	}
+
+	var errBody ErrorResult
+
+	if err := json.Unmarshal([]byte(body), &errBody); err == nil && errBody.Status == "error" {
+		return errBody
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %d", ErrStatus, resp.StatusCode)
+	}
+
+	return json.Unmarshal([]byte(body), result)
+}
+
+// GetTicker возвращает тикер пары
+func (c *PublicClient) GetTicker(pair string) (TickerResult, error) {
+	var ticker TickerResult
+
+	if err := c.get(fmt.Sprintf("/api/v2/ticker/%s/", pair), &ticker); err != nil {
+		return TickerResult{}, err
+	}
+
+	return ticker, nil
+}
+
+// GetOrderBook возвращает стакан пары
+func (c *PublicClient) GetOrderBook(pair string) (OrderBookResult, error) {
+	var book OrderBookResult
+
+	if err := c.get(fmt.Sprintf("/api/v2/order_book/%s/", pair), &book); err != nil {
+		return OrderBookResult{}, err
+	}
+
+	return book, nil
+}
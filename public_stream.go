@@ -0,0 +1,290 @@
+package bitstamp
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// Trade публичная сделка из канала live_trades
+type Trade struct {
+	ID     int64
+	Symbol string
+	Price  float64
+	Amount float64
+	// Side сторона агрессора
+	Side        OrderSide
+	BuyOrderID  int64
+	SellOrderID int64
+	At          time.Time
+}
+
+// bitstampTrade событие канала live_trades.
+// {"data": {"id": 257950614, "timestamp": "1667474425", "amount": 0.0002, "amount_str": "0.00020000", "price": 20512, "price_str": "20512", "type": 0, "microtimestamp": "1667474425458123", "buy_order_id": 1551917787021312, "sell_order_id": 1551917734531072}, "channel": "live_trades_btcusd", "event": "trade"}
+type bitstampTrade struct {
+	Channel string `json:"channel"`
+	Data    struct {
+		ID             json.Number `json:"id"`
+		AmountStr      json.Number `json:"amount_str"`
+		PriceStr       json.Number `json:"price_str"`
+		Type           int         `json:"type"`
+		Microtimestamp json.Number `json:"microtimestamp"`
+		BuyOrderID     json.Number `json:"buy_order_id"`
+		SellOrderID    json.Number `json:"sell_order_id"`
+	} `json:"data"`
+	Event string `json:"event"`
+}
+
+func convertTrade(msg *bitstampTrade) (Trade, error) {
+	var (
+		trade Trade
+		err   error
+	)
+
+	trade.Symbol = strings.TrimPrefix(msg.Channel, "live_trades_")
+
+	if trade.ID, err = numberToInt(msg.Data.ID); err != nil {
+		return Trade{}, fmt.Errorf("wrong id: %w", err)
+	}
+
+	if trade.Price, err = numberToFloat(msg.Data.PriceStr); err != nil {
+		return Trade{}, fmt.Errorf("wrong price: %w", err)
+	}
+
+	if trade.Amount, err = numberToFloat(msg.Data.AmountStr); err != nil {
+		return Trade{}, fmt.Errorf("wrong amount: %w", err)
+	}
+
+	if trade.BuyOrderID, err = numberToInt(msg.Data.BuyOrderID); err != nil {
+		return Trade{}, fmt.Errorf("wrong buy order id: %w", err)
+	}
+
+	if trade.SellOrderID, err = numberToInt(msg.Data.SellOrderID); err != nil {
+		return Trade{}, fmt.Errorf("wrong sell order id: %w", err)
+	}
+
+	micro, err := numberToInt(msg.Data.Microtimestamp)
+	if err != nil {
+		return Trade{}, fmt.Errorf("wrong microtimestamp: %w", err)
+	}
+
+	trade.At = time.Unix(0, micro*int64(time.Microsecond)).UTC()
+
+	trade.Side = Buy
+	if msg.Data.Type == OrderSideSell {
+		trade.Side = Sell
+	}
+
+	return trade, nil
+}
+
+// TradeStream подписка на публичные сделки. Не требует ключей
+type TradeStream struct {
+	symbols []string
+	url     string
+	logger  Logger
+	backoff Backoff
+
+	handlersMu sync.Mutex
+	handlers   []func(Trade)
+
+	stopMu sync.Mutex
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// TradeStreamOption настройка TradeStream
+type TradeStreamOption func(*TradeStream)
+
+// WithTradeStreamURL задает адрес WebSocket'a, например фейкового сервера из bitstamptest
+func WithTradeStreamURL(url string) TradeStreamOption {
+	return func(ts *TradeStream) {
+		ts.url = url
+	}
+}
+
+// WithTradeStreamLogger задает логгер
+func WithTradeStreamLogger(logger Logger) TradeStreamOption {
+	return func(ts *TradeStream) {
+		ts.logger = logger
+	}
+}
+
+// WithTradeStreamBackoff задает задержку между переподключениями
+func WithTradeStreamBackoff(backoff Backoff) TradeStreamOption {
+	return func(ts *TradeStream) {
+		ts.backoff = backoff
+	}
+}
+
+// NewTradeStream создает подписку на публичные сделки по парам
+func NewTradeStream(symbols []string, opts ...TradeStreamOption) *TradeStream {
+	ts := &TradeStream{
+		symbols: symbols,
+		url:     bitstampWS,
+		logger:  NewLogrusLogger(logrus.WithField("provider", "bitstamp").WithField("module", "trades")),
+		stop:    make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(ts)
+	}
+
+	ts.logger = newRedactingLogger(ts.logger)
+
+	return ts
+}
+
+// OnTrade добавляет обработчик сделок. Обработчики вызываются по очереди в горутине чтения,
+// поэтому не должны блокироваться
+func (ts *TradeStream) OnTrade(handler func(Trade)) {
+	ts.handlersMu.Lock()
+	defer ts.handlersMu.Unlock()
+
+	ts.handlers = append(ts.handlers, handler)
+}
+
+func (ts *TradeStream) dispatch(trade Trade) {
+	ts.handlersMu.Lock()
+	handlers := ts.handlers
+	ts.handlersMu.Unlock()
+
+	for _, handler := range handlers {
+		handler(trade)
+	}
+}
+
+// Run подключается к WebSocket'у и переподключается при дисконекте до вызова Stop().
+// reconnectDelay используется как начальная задержка, если она не задана через WithTradeStreamBackoff
+func (ts *TradeStream) Run(reconnectDelay time.Duration) error {
+	ts.stopMu.Lock()
+	select {
+	case <-ts.stop:
+		ts.stopMu.Unlock()
+		return ErrWSClientStopped
+	default:
+		ts.wg.Add(1)
+		defer ts.wg.Done()
+	}
+	ts.stopMu.Unlock()
+
+	backoff := ts.backoff
+	if backoff.Initial == 0 {
+		backoff.Initial = reconnectDelay
+	}
+
+	attempt := 0
+
+	for {
+		err := ts.run(func() { attempt = 0 })
+		if !errors.Is(err, errDoReconnect) {
+			return err
+		}
+
+		if backoff.MaxAttempts > 0 && attempt+1 >= backoff.MaxAttempts {
+			return fmt.Errorf("%w: %v", ErrMaxReconnectAttempts, err)
+		}
+
+		delay := backoff.delay(attempt)
+		attempt++
+
+		ts.logger.WithError(err).WithField("attempt", attempt).WithField("delay", delay).Info("reconnecting")
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-ts.stop:
+			timer.Stop()
+			return ErrWSClientStopped
+		}
+	}
+}
+
+// Stop останавливает подписку и дожидается завершения Run
+func (ts *TradeStream) Stop() {
+	ts.stopMu.Lock()
+	select {
+	case <-ts.stop:
+	default:
+		close(ts.stop)
+	}
+	ts.stopMu.Unlock()
+	ts.wg.Wait()
+}
+
+func (ts *TradeStream) run(subscribed func()) error {
+	if len(ts.symbols) == 0 {
+		return fmt.Errorf("no symbols to subscribe")
+	}
+
+	dialer := websocket.DefaultDialer
+
+	dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint
+
+	wsConn, _, err := dialer.Dial(ts.url, nil)
+	if err != nil {
+		ts.logger.WithError(err).Error("connection to websocket failed")
+		return doReconnect(err)
+	}
+
+	conn := NewWSConn(wsConn, WithConnLogger(ts.logger.WithField("module", "wsconn")))
+	defer conn.Stop()
+
+	for _, symbol := range ts.symbols {
+		msg, err := json.Marshal(map[string]interface{}{
+			"event": "bts:subscribe",
+			"data":  map[string]string{"channel": "live_trades_" + symbol},
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := conn.SendMessage(string(msg)); err != nil {
+			return doReconnect(err)
+		}
+	}
+
+	incoming := conn.RunReader(time.Second * 15)
+	subscribed()
+
+	for {
+		select {
+		case msg, ok := <-incoming:
+			if !ok {
+				return doReconnect(errors.New("connection closed"))
+			}
+
+			ts.handleMessage(msg)
+		case <-ts.stop:
+			return ErrWSClientStopped
+		}
+	}
+}
+
+func (ts *TradeStream) handleMessage(msg []byte) {
+	var raw bitstampTrade
+	if err := json.Unmarshal(msg, &raw); err != nil {
+		ts.logger.WithError(err).Error("could not unmarshal message")
+		return
+	}
+
+	if raw.Event != eventTrade {
+		return
+	}
+
+	trade, err := convertTrade(&raw)
+	if err != nil {
+		ts.logger.WithError(err).Error("could not convert trade")
+		return
+	}
+
+	ts.dispatch(trade)
+}
@@ -0,0 +1,366 @@
+package bitstamp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrReconcilerStopped Run завершился после Stop()
+var ErrReconcilerStopped = errors.New("reconciler stopped")
+
+// DiscrepancyKind тип расхождения локального состояния ордеров с биржей
+type DiscrepancyKind string
+
+const (
+	// DiscrepancyOrphanOrder открытый ордер на бирже, которого нет в OrderManager
+	DiscrepancyOrphanOrder DiscrepancyKind = "orphan_order"
+	// DiscrepancyMissingFill на бирже исполнено больше, чем пришло трейдов
+	DiscrepancyMissingFill DiscrepancyKind = "missing_fill"
+	// DiscrepancyAmountMismatch локально исполнено больше, чем на бирже
+	DiscrepancyAmountMismatch DiscrepancyKind = "amount_mismatch"
+)
+
+// ReconcilePolicy что делать с расхождением
+type ReconcilePolicy int
+
+const (
+	// PolicyAlert только сообщить о расхождении
+	PolicyAlert ReconcilePolicy = iota
+	// PolicyCorrect исправить: для осиротевшего ордера отменить его на бирже,
+	// для остальных принять состояние биржи
+	PolicyCorrect
+)
+
+// Discrepancy найденное расхождение
+type Discrepancy struct {
+	Kind          DiscrepancyKind
+	ClientOrderID string
+	OrderID       int64
+	Symbol        string
+	// LocalRemaining и ExchangeRemaining неисполненный остаток по OrderManager и по бирже
+	LocalRemaining    float64
+	ExchangeRemaining float64
+	// Corrected расхождение исправлено согласно PolicyCorrect
+	Corrected bool
+	// Err ошибка исправления
+	Err error
+}
+
+// Reconciler периодически сверяет открытые ордера OrderManager с GetOpenOrders.
+// Ордера, пропавшие из открытых на бирже, разрешаются через GetOrderStatus
+type Reconciler struct {
+	om       *OrderManager
+	api      TradingAPI
+	logger   Logger
+	policies map[DiscrepancyKind]ReconcilePolicy
+
+	handlersMu sync.Mutex
+	handlers   []func(Discrepancy)
+
+	stopMu sync.Mutex
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// ReconcilerOption настройка Reconciler
+type ReconcilerOption func(*Reconciler)
+
+// WithReconcilePolicy задает политику для типа расхождений. По умолчанию осиротевшие ордера
+// только сообщаются, остальные расхождения исправляются
+func WithReconcilePolicy(kind DiscrepancyKind, policy ReconcilePolicy) ReconcilerOption {
+	return func(r *Reconciler) {
+		r.policies[kind] = policy
+	}
+}
+
+// WithReconcilerLogger задает логгер
+func WithReconcilerLogger(logger Logger) ReconcilerOption {
+	return func(r *Reconciler) {
+		r.logger = logger
+	}
+}
+
+// NewReconciler создает сверку для OrderManager. api обычно тот же, что у OrderManager
+func NewReconciler(om *OrderManager, api TradingAPI, opts ...ReconcilerOption) *Reconciler {
+	r := &Reconciler{
+		om:     om,
+		api:    api,
+		logger: NewLogrusLogger(logrus.WithField("provider", "bitstamp").WithField("module", "reconciler")),
+		policies: map[DiscrepancyKind]ReconcilePolicy{
+			DiscrepancyOrphanOrder:    PolicyAlert,
+			DiscrepancyMissingFill:    PolicyCorrect,
+			DiscrepancyAmountMismatch: PolicyCorrect,
+		},
+		stop: make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	r.logger = newRedactingLogger(r.logger)
+
+	return r
+}
+
+// OnDiscrepancy добавляет обработчик расхождений. Вызывается после попытки исправления
+func (r *Reconciler) OnDiscrepancy(handler func(Discrepancy)) {
+	r.handlersMu.Lock()
+	defer r.handlersMu.Unlock()
+
+	r.handlers = append(r.handlers, handler)
+}
+
+// Run сверяет ордера каждые interval до вызова Stop(). Ошибки сверки логируются, цикл продолжается
+func (r *Reconciler) Run(interval time.Duration) error {
+	r.stopMu.Lock()
+	select {
+	case <-r.stop:
+		r.stopMu.Unlock()
+		return ErrReconcilerStopped
+	default:
+		r.wg.Add(1)
+		defer r.wg.Done()
+	}
+	r.stopMu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Reconcile(); err != nil {
+			r.logger.WithError(err).Error("reconciliation failed")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return ErrReconcilerStopped
+		}
+	}
+}
+
+// Stop останавливает Run и дожидается завершения текущей сверки
+func (r *Reconciler) Stop() {
+	r.stopMu.Lock()
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+	r.stopMu.Unlock()
+	r.wg.Wait()
+}
+
+// Reconcile выполняет одну сверку и возвращает найденные расхождения
+func (r *Reconciler) Reconcile() ([]Discrepancy, error) {
+	// локальные ордера берутся до запроса, а осиротевшие проверяются после него,
+	// чтобы ордер, выставленный во время запроса, не попал в расхождения
+	active := r.om.Active()
+
+	open, err := r.api.GetOpenOrders()
+	if err != nil {
+		return nil, fmt.Errorf("could not get open orders: %w", err)
+	}
+
+	exchange := make(map[int64]OpenOrderResult, len(open))
+	for _, o := range open {
+		exchange[o.ID] = o
+	}
+
+	var (
+		found []Discrepancy
+		errs  []string
+	)
+
+	for _, o := range open {
+		if _, ok := r.om.OrderByID(o.ID); ok {
+			continue
+		}
+
+		// ответ PlaceOrder мог потеряться, тогда ордер известен только по client_order_id
+		if o.ClientOrderID != "" && r.om.adopt(o.ClientOrderID, o.ID) {
+			continue
+		}
+
+		found = append(found, r.orphan(o))
+	}
+
+	for _, snapshot := range active {
+		// ордер без id еще не подтвержден биржей
+		if snapshot.ID == 0 {
+			continue
+		}
+
+		// трейды могли прийти во время запроса, поэтому сравнивается текущее состояние ордера
+		local, ok := r.om.Order(snapshot.ClientOrderID)
+		if !ok || local.State.Terminal() {
+			continue
+		}
+
+		remote, ok := exchange[local.ID]
+		if ok {
+			if d, ok := r.compare(local, remote.Amount); ok {
+				if d, ok := r.correct(d); ok {
+					found = append(found, d)
+				}
+			}
+
+			continue
+		}
+
+		d, ok, err := r.resolve(local)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", local.ClientOrderID, err))
+			continue
+		}
+
+		if ok {
+			found = append(found, d)
+		}
+	}
+
+	for _, d := range found {
+		r.report(d)
+	}
+
+	if len(errs) > 0 {
+		return found, fmt.Errorf("could not resolve orders: %s", strings.Join(errs, "; "))
+	}
+
+	return found, nil
+}
+
+func (r *Reconciler) orphan(o OpenOrderResult) Discrepancy {
+	d := Discrepancy{
+		Kind:              DiscrepancyOrphanOrder,
+		OrderID:           o.ID,
+		Symbol:            strings.ToLower(strings.Replace(o.CurrencyPair, "/", "", 1)),
+		ExchangeRemaining: o.Amount,
+	}
+
+	if r.policies[d.Kind] != PolicyCorrect {
+		return d
+	}
+
+	_, d.Err = r.api.CancelOrder(strconv.FormatInt(o.ID, 10))
+	d.Corrected = d.Err == nil
+
+	return d
+}
+
+// compare сравнивает остаток ордера с остатком на бирже
+func (r *Reconciler) compare(local ManagedOrder, exchangeRemaining float64) (Discrepancy, bool) {
+	diff := exchangeRemaining - local.Remaining()
+	if math.Abs(diff) <= paperEpsilon {
+		return Discrepancy{}, false
+	}
+
+	d := Discrepancy{
+		Kind:              DiscrepancyMissingFill,
+		ClientOrderID:     local.ClientOrderID,
+		OrderID:           local.ID,
+		Symbol:            local.Symbol,
+		LocalRemaining:    local.Remaining(),
+		ExchangeRemaining: exchangeRemaining,
+	}
+
+	if diff > 0 {
+		d.Kind = DiscrepancyAmountMismatch
+	}
+
+	return d, true
+}
+
+// correct принимает состояние биржи по GetOrderStatus, если для расхождения задан PolicyCorrect.
+// Возвращает false, если по свежему статусу и текущему состоянию ордера расхождения уже нет
+func (r *Reconciler) correct(d Discrepancy) (Discrepancy, bool) {
+	if r.policies[d.Kind] != PolicyCorrect {
+		return d, true
+	}
+
+	status, err := r.api.GetOrderStatus(strconv.FormatInt(d.OrderID, 10))
+	if err != nil {
+		d.Err = err
+		return d, true
+	}
+
+	if local, ok := r.om.Order(d.ClientOrderID); ok {
+		if _, mismatch := r.compare(local, status.AmountRemaining); !mismatch {
+			return d, false
+		}
+	}
+
+	_, d.Err = r.om.applyStatus(d.ClientOrderID, status, true)
+	d.Corrected = d.Err == nil
+
+	return d, true
+}
+
+// resolve разрешает ордер, пропавший из открытых на бирже: исполненный (Finished), отмененный (Canceled)
+// или снятый по сроку (Expired). Статус применяется всегда, кроме случая недополученных трейдов с PolicyAlert
+func (r *Reconciler) resolve(local ManagedOrder) (Discrepancy, bool, error) {
+	status, err := r.api.GetOrderStatus(strconv.FormatInt(local.ID, 10))
+	if err != nil {
+		return Discrepancy{}, false, err
+	}
+
+	switch status.Status {
+	case OrderStatusOpen, OrderStatusFinished, OrderStatusCanceled, OrderStatusExpired:
+	default:
+		return Discrepancy{}, false, fmt.Errorf("unknown order status %q", status.Status)
+	}
+
+	// трейды могли прийти во время запросов
+	if fresh, ok := r.om.Order(local.ClientOrderID); ok {
+		local = fresh
+	}
+
+	d, mismatch := r.compare(local, status.AmountRemaining)
+
+	if mismatch && r.policies[d.Kind] != PolicyCorrect {
+		return d, true, nil
+	}
+
+	if _, err := r.om.applyStatus(local.ClientOrderID, status, mismatch); err != nil {
+		if mismatch {
+			d.Err = err
+			return d, true, nil
+		}
+
+		return Discrepancy{}, false, err
+	}
+
+	d.Corrected = mismatch
+
+	return d, mismatch, nil
+}
+
+func (r *Reconciler) report(d Discrepancy) {
+	logger := r.logger.
+		WithField("kind", d.Kind).
+		WithField("order", d.OrderID).
+		WithField("client_order_id", d.ClientOrderID).
+		WithField("corrected", d.Corrected)
+
+	if d.Err != nil {
+		logger.WithError(d.Err).Error("could not correct order discrepancy")
+	} else {
+		logger.Warn("order discrepancy")
+	}
+
+	r.handlersMu.Lock()
+	handlers := r.handlers
+	r.handlersMu.Unlock()
+
+	for _, handler := range handlers {
+		handler(d)
+	}
+}
@@ -0,0 +1,235 @@
+package bitstamp_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/b2broker/bitstamp"
+	"github.com/b2broker/bitstamp/bitstamptest"
+)
+
+// reconcileAPI PrivateClient с хуками для сценариев сверки
+type reconcileAPI struct {
+	*bitstamp.PrivateClient
+	// expire отдает отмененные ордера как снятые по сроку
+	expire bool
+	// afterOpenOrders вызывается после ответа GetOpenOrders
+	afterOpenOrders func()
+}
+
+func (a reconcileAPI) GetOpenOrders() ([]bitstamp.OpenOrderResult, error) {
+	open, err := a.PrivateClient.GetOpenOrders()
+	if a.afterOpenOrders != nil {
+		a.afterOpenOrders()
+	}
+
+	return open, err
+}
+
+func (a reconcileAPI) GetOrderStatus(id string) (bitstamp.OrderStatusResult, error) {
+	status, err := a.PrivateClient.GetOrderStatus(id)
+	if err == nil && a.expire && status.Status == bitstamp.OrderStatusCanceled {
+		status.Status = bitstamp.OrderStatusExpired
+	}
+
+	return status, err
+}
+
+func TestReconcilerDiscrepancies(t *testing.T) {
+	restingBuy := bitstamp.PlaceOrderRequest{
+		Symbol:        "btcusd",
+		Side:          bitstamp.Buy,
+		Type:          bitstamp.Limit,
+		Price:         19000,
+		Amount:        0.01,
+		ClientOrderID: "rec-1",
+	}
+
+	tests := []struct {
+		name string
+		opts []bitstamp.ReconcilerOption
+		// expire GetOrderStatus отдает Expired вместо Canceled
+		expire bool
+		// scenario меняет состояние биржи после выставления restingBuy через OrderManager
+		scenario func(t *testing.T, srv *bitstamptest.Server, om *bitstamp.OrderManager, local bitstamp.ManagedOrder)
+		// during вызывается во время сверки, после ответа GetOpenOrders
+		during func(om *bitstamp.OrderManager, local bitstamp.ManagedOrder)
+		kinds  []bitstamp.DiscrepancyKind
+		state  bitstamp.OrderState
+		filled float64
+	}{
+		{
+			name:  "in sync",
+			state: bitstamp.OrderOpen,
+		},
+		{
+			name: "missing fill on open order",
+			scenario: func(t *testing.T, srv *bitstamptest.Server, om *bitstamp.OrderManager, local bitstamp.ManagedOrder) {
+				srv.Trade("btcusd", bitstamp.Sell, 19000, 0.004)
+			},
+			kinds:  []bitstamp.DiscrepancyKind{bitstamp.DiscrepancyMissingFill},
+			state:  bitstamp.OrderPartiallyFilled,
+			filled: 0.004,
+		},
+		{
+			name: "missing fill alert only",
+			opts: []bitstamp.ReconcilerOption{bitstamp.WithReconcilePolicy(bitstamp.DiscrepancyMissingFill, bitstamp.PolicyAlert)},
+			scenario: func(t *testing.T, srv *bitstamptest.Server, om *bitstamp.OrderManager, local bitstamp.ManagedOrder) {
+				srv.Trade("btcusd", bitstamp.Sell, 19000, 0.004)
+			},
+			kinds: []bitstamp.DiscrepancyKind{bitstamp.DiscrepancyMissingFill},
+			state: bitstamp.OrderOpen,
+		},
+		{
+			name: "fill arrived during reconciliation",
+			scenario: func(t *testing.T, srv *bitstamptest.Server, om *bitstamp.OrderManager, local bitstamp.ManagedOrder) {
+				srv.Trade("btcusd", bitstamp.Sell, 19000, 0.004)
+			},
+			during: func(om *bitstamp.OrderManager, local bitstamp.ManagedOrder) {
+				om.HandleFill(bitstamp.Fill{OrderID: local.ID, TradeID: 1, ClientOrderID: local.ClientOrderID, Symbol: "btcusd", Side: "buy", Price: 19000, Size: 0.004})
+			},
+			state:  bitstamp.OrderPartiallyFilled,
+			filled: 0.004,
+		},
+		{
+			name: "fill of finished order arrived during reconciliation",
+			scenario: func(t *testing.T, srv *bitstamptest.Server, om *bitstamp.OrderManager, local bitstamp.ManagedOrder) {
+				srv.Trade("btcusd", bitstamp.Sell, 19000, 0.01)
+			},
+			during: func(om *bitstamp.OrderManager, local bitstamp.ManagedOrder) {
+				om.HandleFill(bitstamp.Fill{OrderID: local.ID, TradeID: 1, ClientOrderID: local.ClientOrderID, Symbol: "btcusd", Side: "buy", Price: 19000, Size: 0.01})
+			},
+			state:  bitstamp.OrderFilled,
+			filled: 0.01,
+		},
+		{
+			name: "filled off the stream",
+			scenario: func(t *testing.T, srv *bitstamptest.Server, om *bitstamp.OrderManager, local bitstamp.ManagedOrder) {
+				srv.Trade("btcusd", bitstamp.Sell, 19000, 0.01)
+			},
+			kinds:  []bitstamp.DiscrepancyKind{bitstamp.DiscrepancyMissingFill},
+			state:  bitstamp.OrderFilled,
+			filled: 0.01,
+		},
+		{
+			name: "amount mismatch",
+			scenario: func(t *testing.T, srv *bitstamptest.Server, om *bitstamp.OrderManager, local bitstamp.ManagedOrder) {
+				om.HandleFill(bitstamp.Fill{OrderID: local.ID, TradeID: 1, ClientOrderID: local.ClientOrderID, Symbol: "btcusd", Side: "buy", Price: 19000, Size: 0.004})
+			},
+			kinds:  []bitstamp.DiscrepancyKind{bitstamp.DiscrepancyAmountMismatch},
+			state:  bitstamp.OrderPartiallyFilled,
+			filled: 0.004,
+		},
+		{
+			name: "cancelled outside",
+			scenario: func(t *testing.T, srv *bitstamptest.Server, om *bitstamp.OrderManager, local bitstamp.ManagedOrder) {
+				if _, err := srv.NewClient().CancelOrder(strconv.FormatInt(local.ID, 10)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			state: bitstamp.OrderCancelled,
+		},
+		{
+			name:   "expired",
+			expire: true,
+			scenario: func(t *testing.T, srv *bitstamptest.Server, om *bitstamp.OrderManager, local bitstamp.ManagedOrder) {
+				if _, err := srv.NewClient().CancelOrder(strconv.FormatInt(local.ID, 10)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			state: bitstamp.OrderCancelled,
+		},
+		{
+			name: "orphan order",
+			scenario: func(t *testing.T, srv *bitstamptest.Server, om *bitstamp.OrderManager, local bitstamp.ManagedOrder) {
+				if _, err := srv.NewClient().PlaceOrder(bitstamp.PlaceOrderRequest{Symbol: "btcusd", Side: bitstamp.Buy, Type: bitstamp.Limit, Price: 18000, Amount: 0.01}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			kinds: []bitstamp.DiscrepancyKind{bitstamp.DiscrepancyOrphanOrder},
+			state: bitstamp.OrderOpen,
+		},
+		{
+			name: "orphan with known client order id is adopted",
+			scenario: func(t *testing.T, srv *bitstamptest.Server, om *bitstamp.OrderManager, local bitstamp.ManagedOrder) {
+				req := restingBuy
+				req.ClientOrderID = "rec-lost"
+				req.Price = 18000
+
+				// ответ PlaceOrder потерян: ордер отслеживается без id
+				srv.InjectFault(bitstamptest.Fault{Path: "/api/v2/buy/btcusd/", Status: 500, Code: "500", Reason: "Internal error"})
+				if _, err := om.PlaceOrder(req); err == nil {
+					t.Fatal("expected place error")
+				}
+
+				if _, err := srv.NewClient().PlaceOrder(req); err != nil {
+					t.Fatal(err)
+				}
+			},
+			state: bitstamp.OrderOpen,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			srv := bitstamptest.NewServer("key", "secret")
+			defer srv.Close()
+
+			srv.SetBalance("usd", 1000)
+
+			api := &reconcileAPI{PrivateClient: srv.NewClient(), expire: tt.expire}
+
+			om := bitstamp.NewOrderManager(api)
+			reconciler := bitstamp.NewReconciler(om, api, tt.opts...)
+
+			local, err := om.PlaceOrder(restingBuy)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.scenario != nil {
+				tt.scenario(t, srv, om, local)
+			}
+
+			if tt.during != nil {
+				api.afterOpenOrders = func() { tt.during(om, local) }
+			}
+
+			found, err := reconciler.Reconcile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(found) != len(tt.kinds) {
+				t.Fatalf("found %+v, want %v", found, tt.kinds)
+			}
+
+			for i, d := range found {
+				if d.Kind != tt.kinds[i] {
+					t.Fatalf("discrepancy %d kind = %s, want %s", i, d.Kind, tt.kinds[i])
+				}
+
+				if d.Err != nil {
+					t.Fatalf("discrepancy %d: %v", i, d.Err)
+				}
+			}
+
+			o, _ := om.Order(restingBuy.ClientOrderID)
+			if o.State != tt.state {
+				t.Fatalf("state = %s, want %s", o.State, tt.state)
+			}
+
+			if diff := o.Filled - tt.filled; diff > 1e-9 || diff < -1e-9 {
+				t.Fatalf("filled = %v, want %v", o.Filled, tt.filled)
+			}
+
+			for _, lost := range om.Orders() {
+				if lost.ClientOrderID == "rec-lost" && (lost.ID == 0 || lost.State != bitstamp.OrderOpen) {
+					t.Fatalf("lost order isn't adopted: %+v", lost)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,602 @@
+package bitstamp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrRiskRejected ордер отклонен проверкой риска. errors.As(err, &RiskError{}) возвращает подробности
+var ErrRiskRejected = errors.New("order rejected by risk check")
+
+// RiskCheck проверка риска
+type RiskCheck string
+
+const (
+	// RiskOrderNotional объем ордера в валюте котировки больше MaxOrderNotional
+	RiskOrderNotional RiskCheck = "max_order_notional"
+	// RiskFatFinger количество в ордере больше MaxOrderAmount
+	RiskFatFinger RiskCheck = "fat_finger"
+	// RiskOpenOrders открытых ордеров уже MaxOpenOrders
+	RiskOpenOrders RiskCheck = "max_open_orders"
+	// RiskPosition позиция с учетом открытых ордеров превысит MaxPosition
+	RiskPosition RiskCheck = "max_position"
+	// RiskPriceCollar цена дальше PriceCollar от опорной
+	RiskPriceCollar RiskCheck = "price_collar"
+	// RiskReferencePrice опорная цена неизвестна, а проверка без нее невозможна
+	RiskReferencePrice RiskCheck = "reference_price"
+	// RiskOrderRate ордеров за последнюю минуту уже MaxOrdersPerMinute
+	RiskOrderRate RiskCheck = "order_rate"
+	// RiskSelfTrade ордер исполнится о собственный открытый ордер
+	RiskSelfTrade RiskCheck = "self_trade"
+)
+
+// RiskError ордер отклонен проверкой Check. errors.Is(err, ErrRiskRejected) == true
+type RiskError struct {
+	Check  RiskCheck
+	Symbol string
+	// Value значение, нарушившее Limit
+	Value float64
+	Limit float64
+}
+
+func (e RiskError) Error() string {
+	return fmt.Sprintf("%s: %s %s: %g, limit %g", ErrRiskRejected, e.Check, e.Symbol, e.Value, e.Limit)
+}
+
+func (e RiskError) Is(target error) bool {
+	return target == ErrRiskRejected
+}
+
+// PriceReference опорная цена для PriceCollar и объема рыночных ордеров
+type PriceReference string
+
+const (
+	// ReferenceLastTrade цена последнего трейда из HandleTrade
+	ReferenceLastTrade PriceReference = "last_trade"
+	// ReferenceMid середина спреда из OrderBookSource, при его отсутствии последний трейд
+	ReferenceMid PriceReference = "mid"
+)
+
+// SymbolLimits лимиты по символу. Нулевое значение отключает проверку
+type SymbolLimits struct {
+	// MaxOrderNotional максимальный объем ордера в валюте котировки
+	MaxOrderNotional float64 `json:"max_order_notional"`
+	// MaxOrderAmount максимальное количество в одном ордере
+	MaxOrderAmount float64 `json:"max_order_amount"`
+	// MaxPosition максимальная абсолютная позиция в базовой валюте с учетом открытых ордеров
+	MaxPosition float64 `json:"max_position"`
+	// PriceCollar допустимое отклонение цены лимитного ордера от опорной, 0.05 = 5%
+	PriceCollar float64 `json:"price_collar"`
+}
+
+// RiskLimits лимиты RiskEngine. Нулевое значение отключает проверку
+type RiskLimits struct {
+	// Default лимиты для символов, которых нет в Symbols
+	Default SymbolLimits `json:"default"`
+	// Symbols лимиты по символам целиком заменяют Default
+	Symbols map[string]SymbolLimits `json:"symbols"`
+
+	MaxOpenOrders      int            `json:"max_open_orders"`
+	MaxOrdersPerMinute int            `json:"max_orders_per_minute"`
+	SelfTradeGuard     bool           `json:"self_trade_guard"`
+	Reference          PriceReference `json:"reference"`
+}
+
+func (l RiskLimits) symbol(symbol string) SymbolLimits {
+	if limits, ok := l.Symbols[symbol]; ok {
+		return limits
+	}
+
+	return l.Default
+}
+
+// LoadRiskLimits читает лимиты из JSON-файла. Вместе с RiskEngine.SetLimits позволяет
+// перечитывать лимиты без перезапуска, например по SIGHUP
+func LoadRiskLimits(path string) (RiskLimits, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return RiskLimits{}, err
+	}
+
+	var limits RiskLimits
+
+	if err := json.Unmarshal(data, &limits); err != nil {
+		return RiskLimits{}, fmt.Errorf("could not parse risk limits: %w", err)
+	}
+
+	return limits, nil
+}
+
+// restingOrder собственный открытый ордер. id 0 у ордера, ответ на который еще не получен
+type restingOrder struct {
+	id            int64
+	clientOrderID string
+	symbol        string
+	side          OrderSide
+	price         float64
+	remaining     float64
+}
+
+// RiskEngine проверки до отправки ордера. Открытые ордера и позиция ведутся по ордерам,
+// прошедшим через RiskClient, трейдам HandleFill и событиям HandleOrderEvent
+type RiskEngine struct {
+	book   OrderBookSource
+	logger Logger
+	now    func() time.Time
+
+	mu        sync.Mutex
+	limits    RiskLimits
+	last      map[string]float64
+	positions map[string]float64
+	resting   map[*restingOrder]struct{}
+	sent      []time.Time
+	trades    *recentTrades
+}
+
+// RiskOption настройка RiskEngine
+type RiskOption func(*RiskEngine)
+
+// WithRiskBook задает стакан для ReferenceMid, например PublicClient
+func WithRiskBook(book OrderBookSource) RiskOption {
+	return func(re *RiskEngine) {
+		re.book = book
+	}
+}
+
+// WithRiskClock задает источник времени для MaxOrdersPerMinute
+func WithRiskClock(now func() time.Time) RiskOption {
+	return func(re *RiskEngine) {
+		re.now = now
+	}
+}
+
+// WithRiskLogger задает логгер
+func WithRiskLogger(logger Logger) RiskOption {
+	return func(re *RiskEngine) {
+		re.logger = logger
+	}
+}
+
+// NewRiskEngine создает проверки риска с лимитами limits
+//
+//	risk := bitstamp.NewRiskEngine(limits, bitstamp.WithRiskBook(public))
+//	trades.OnTrade(risk.HandleTrade)
+//	ws.OnFill(risk.HandleFill)
+//	client := bitstamp.NewRiskClient(private, risk)
+func NewRiskEngine(limits RiskLimits, opts ...RiskOption) *RiskEngine {
+	re := &RiskEngine{
+		logger:    NewLogrusLogger(logrus.WithField("provider", "bitstamp").WithField("module", "risk")),
+		now:       time.Now,
+		limits:    limits,
+		last:      make(map[string]float64),
+		positions: make(map[string]float64),
+		resting:   make(map[*restingOrder]struct{}),
+		trades:    newRecentTrades(recentTradesLimit),
+	}
+
+	for _, opt := range opts {
+		opt(re)
+	}
+
+	re.logger = newRedactingLogger(re.logger)
+
+	return re
+}
+
+// SetLimits заменяет лимиты. Применяется к следующей проверке
+func (re *RiskEngine) SetLimits(limits RiskLimits) {
+	re.mu.Lock()
+	re.limits = limits
+	re.mu.Unlock()
+
+	re.logger.Info("risk limits updated")
+}
+
+// Limits возвращает текущие лимиты
+func (re *RiskEngine) Limits() RiskLimits {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	return re.limits
+}
+
+// SetPosition задает позицию по символу, например по балансам при старте
+func (re *RiskEngine) SetPosition(symbol string, position float64) {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	re.positions[symbol] = position
+}
+
+// Position возвращает позицию по символу
+func (re *RiskEngine) Position(symbol string) float64 {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	return re.positions[symbol]
+}
+
+// SyncOpenOrders заменяет открытые ордера результатом GetOpenOrders, например при старте
+func (re *RiskEngine) SyncOpenOrders(orders []OpenOrderResult) {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	re.resting = make(map[*restingOrder]struct{}, len(orders))
+
+	for _, o := range orders {
+		side := Buy
+		if o.Type == OrderSideSell {
+			side = Sell
+		}
+
+		re.resting[&restingOrder{
+			id:        o.ID,
+			symbol:    strings.ToLower(strings.Replace(o.CurrencyPair, "/", "", 1)),
+			side:      side,
+			price:     o.Price,
+			remaining: o.Amount,
+		}] = struct{}{}
+	}
+}
+
+// HandleTrade обновляет цену последнего трейда. Подходит для TradeStream.OnTrade
+func (re *RiskEngine) HandleTrade(trade Trade) {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	re.last[trade.Symbol] = trade.Price
+}
+
+// HandleFill обновляет позицию и остаток собственного ордера. Подходит для FillStream.OnFill
+func (re *RiskEngine) HandleFill(fill Fill) {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	if !re.trades.remember(fill.TradeID) {
+		return
+	}
+
+	if fill.Side == string(Sell) {
+		re.positions[fill.Symbol] -= fill.Size
+	} else {
+		re.positions[fill.Symbol] += fill.Size
+	}
+
+	if o := re.findResting(fill.OrderID, fill.ClientOrderID); o != nil {
+		o.remaining -= fill.Size
+		if o.remaining <= paperEpsilon {
+			delete(re.resting, o)
+		}
+	}
+}
+
+// HandleOrderEvent обновляет открытые ордера по событиям my_orders. Подходит для WithOrderEvents
+func (re *RiskEngine) HandleOrderEvent(event OrderEvent) {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	o := re.findResting(event.ID, event.ClientOrderID)
+
+	switch event.Type {
+	case OrderEventDeleted:
+		if o != nil {
+			delete(re.resting, o)
+		}
+	case OrderEventCreated, OrderEventChanged:
+		if o == nil {
+			o = &restingOrder{id: event.ID, symbol: event.Symbol, side: event.Side, price: event.Price}
+			re.resting[o] = struct{}{}
+		}
+
+		o.remaining = event.Amount
+	}
+}
+
+// findResting ищет ордер по id или, пока ответ на выставление не получен, по client_order_id
+func (re *RiskEngine) findResting(id int64, clientOrderID string) *restingOrder {
+	for o := range re.resting {
+		if id != 0 && o.id == id {
+			return o
+		}
+
+		if o.id == 0 && clientOrderID != "" && o.clientOrderID == clientOrderID {
+			o.id = id
+			return o
+		}
+	}
+
+	return nil
+}
+
+// Check проверяет ордер по текущим лимитам без учета его в открытых ордерах и частоте
+func (re *RiskEngine) Check(req PlaceOrderRequest) error {
+	reference, err := re.reference(req.Symbol)
+	if err != nil {
+		return err
+	}
+
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	return re.check(req, reference)
+}
+
+// reference опорная цена. Стакан запрашивается без блокировки. 0, если цена неизвестна
+func (re *RiskEngine) reference(symbol string) (float64, error) {
+	re.mu.Lock()
+	mode := re.limits.Reference
+	last := re.last[symbol]
+	re.mu.Unlock()
+
+	if mode != ReferenceMid || re.book == nil {
+		return last, nil
+	}
+
+	book, err := re.book.GetOrderBook(symbol)
+	if err != nil {
+		return 0, fmt.Errorf("could not get order book: %w", err)
+	}
+
+	if len(book.Bids) == 0 || len(book.Asks) == 0 {
+		return last, nil
+	}
+
+	return (book.Bids[0].Price + book.Asks[0].Price) / 2, nil
+}
+
+// check вызывается под re.mu
+func (re *RiskEngine) check(req PlaceOrderRequest, reference float64) error {
+	limits := re.limits.symbol(req.Symbol)
+
+	reject := func(check RiskCheck, value float64, limit float64) error {
+		return RiskError{Check: check, Symbol: req.Symbol, Value: value, Limit: limit}
+	}
+
+	if limits.MaxOrderAmount > 0 && req.Amount > limits.MaxOrderAmount {
+		return reject(RiskFatFinger, req.Amount, limits.MaxOrderAmount)
+	}
+
+	price := req.Price
+	if req.Type == Market {
+		price = reference
+	}
+
+	if price <= 0 && (limits.MaxOrderNotional > 0 || limits.PriceCollar > 0) {
+		return reject(RiskReferencePrice, 0, 0)
+	}
+
+	if limits.MaxOrderNotional > 0 && price*req.Amount > limits.MaxOrderNotional {
+		return reject(RiskOrderNotional, price*req.Amount, limits.MaxOrderNotional)
+	}
+
+	if limits.PriceCollar > 0 && req.Type == Limit {
+		if reference <= 0 {
+			return reject(RiskReferencePrice, 0, 0)
+		}
+
+		if deviation := math.Abs(req.Price-reference) / reference; deviation > limits.PriceCollar {
+			return reject(RiskPriceCollar, deviation, limits.PriceCollar)
+		}
+	}
+
+	if limit := re.limits.MaxOrdersPerMinute; limit > 0 {
+		if sent := re.sentSince(re.now().Add(-time.Minute)); sent >= limit {
+			return reject(RiskOrderRate, float64(sent), float64(limit))
+		}
+	}
+
+	if limit := re.limits.MaxOpenOrders; limit > 0 && len(re.resting) >= limit {
+		return reject(RiskOpenOrders, float64(len(re.resting)), float64(limit))
+	}
+
+	if limits.MaxPosition > 0 {
+		// худший случай: исполнятся все открытые ордера той же стороны
+		position := re.positions[req.Symbol]
+
+		for o := range re.resting {
+			if o.symbol != req.Symbol || o.side != req.Side {
+				continue
+			}
+
+			if o.side == Buy {
+				position += o.remaining
+			} else {
+				position -= o.remaining
+			}
+		}
+
+		if req.Side == Buy {
+			position += req.Amount
+		} else {
+			position -= req.Amount
+		}
+
+		if math.Abs(position) > limits.MaxPosition {
+			return reject(RiskPosition, math.Abs(position), limits.MaxPosition)
+		}
+	}
+
+	if re.limits.SelfTradeGuard {
+		for o := range re.resting {
+			if o.symbol != req.Symbol || o.side == req.Side {
+				continue
+			}
+
+			crosses := req.Type == Market ||
+				req.Side == Buy && req.Price >= o.price ||
+				req.Side == Sell && req.Price <= o.price
+			if crosses {
+				return reject(RiskSelfTrade, req.Price, o.price)
+			}
+		}
+	}
+
+	return nil
+}
+
+// sentSince число ордеров после since. Старые отметки удаляются. Вызывается под re.mu
+func (re *RiskEngine) sentSince(since time.Time) int {
+	i := 0
+	for i < len(re.sent) && !re.sent[i].After(since) {
+		i++
+	}
+
+	re.sent = re.sent[i:]
+
+	return len(re.sent)
+}
+
+// admit проверяет ордер и учитывает его в частоте и открытых ордерах до отправки
+func (re *RiskEngine) admit(req PlaceOrderRequest) (*restingOrder, error) {
+	reference, err := re.reference(req.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	if err := re.check(req, reference); err != nil {
+		re.logger.WithError(err).WithField("client_order_id", req.ClientOrderID).Warn("order rejected by risk check")
+		return nil, err
+	}
+
+	re.sent = append(re.sent, re.now())
+
+	o := &restingOrder{clientOrderID: req.ClientOrderID, symbol: req.Symbol, side: req.Side, price: req.Price, remaining: req.Amount}
+	re.resting[o] = struct{}{}
+
+	return o, nil
+}
+
+// placed связывает ордер с id или убирает его, если ордер точно отклонен или не остается в стакане.
+// После ошибки сети или 5xx ордер мог быть выставлен: он учитывается, пока его не удалит событие my_orders
+// или SyncOpenOrders
+func (re *RiskEngine) placed(o *restingOrder, req PlaceOrderRequest, result PlaceOrderResult, err error) {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	if IsRejected(err) || req.Type == Market || req.ExecType == ExecFOK || req.ExecType == ExecIOC {
+		delete(re.resting, o)
+		return
+	}
+
+	if err != nil {
+		re.logger.WithError(err).WithField("client_order_id", req.ClientOrderID).Warn("order result is unknown, keep it open")
+		return
+	}
+
+	// трейды по ордеру могли прийти раньше ответа
+	if _, ok := re.resting[o]; ok {
+		o.id = result.ID
+	}
+}
+
+func (re *RiskEngine) cancelled(id int64) {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	if o := re.findResting(id, ""); o != nil {
+		delete(re.resting, o)
+	}
+}
+
+func (re *RiskEngine) cancelledAll() {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	re.resting = make(map[*restingOrder]struct{})
+}
+
+// RiskClient проверяет ордера RiskEngine до подписи и отправки
+type RiskClient struct {
+	TradingAPI
+	engine *RiskEngine
+
+	clientOrderIDs ClientOrderIDFunc
+}
+
+// RiskClientOption настройка RiskClient
+type RiskClientOption func(*RiskClient)
+
+// WithRiskClientOrderIDs задает генератор ClientOrderID для PlaceOrder без него. ClientOrderID нужен
+// до отправки, чтобы ордер с неизвестным результатом нашелся по событию my_orders
+func WithRiskClientOrderIDs(generate ClientOrderIDFunc) RiskClientOption {
+	return func(rc *RiskClient) {
+		rc.clientOrderIDs = generate
+	}
+}
+
+// NewRiskClient оборачивает api проверками engine
+func NewRiskClient(api TradingAPI, engine *RiskEngine, opts ...RiskClientOption) *RiskClient {
+	rc := &RiskClient{
+		TradingAPI: api,
+		engine:     engine,
+	}
+
+	for _, opt := range opts {
+		opt(rc)
+	}
+
+	return rc
+}
+
+// PlaceOrder возвращает RiskError, не отправляя ордер, если он нарушает лимиты
+func (rc *RiskClient) PlaceOrder(opts PlaceOrderRequest) (PlaceOrderResult, error) {
+	opts, err := withClientOrderID(opts, rc.clientOrderIDs)
+	if err != nil {
+		return PlaceOrderResult{}, err
+	}
+
+	if err := validateOrder(opts); err != nil {
+		return PlaceOrderResult{}, err
+	}
+
+	o, err := rc.engine.admit(opts)
+	if err != nil {
+		return PlaceOrderResult{}, err
+	}
+
+	result, err := rc.TradingAPI.PlaceOrder(opts)
+	rc.engine.placed(o, opts, result, err)
+
+	return result, err
+}
+
+func (rc *RiskClient) CancelOrder(id string) (OrderCancelResult, error) {
+	result, err := rc.TradingAPI.CancelOrder(id)
+	if err == nil {
+		orderID, _ := strconv.ParseInt(id, 10, 64)
+		rc.engine.cancelled(orderID)
+	}
+
+	return result, err
+}
+
+func (rc *RiskClient) CancelOrderByClientOrderID(clientOrderID string) (OrderCancelResult, error) {
+	result, err := rc.TradingAPI.CancelOrderByClientOrderID(clientOrderID)
+	if err == nil {
+		orderID, _ := strconv.ParseInt(result.ID, 10, 64)
+		rc.engine.cancelled(orderID)
+	}
+
+	return result, err
+}
+
+func (rc *RiskClient) CancelAllOrders() (CancelAllOrdersResult, error) {
+	result, err := rc.TradingAPI.CancelAllOrders()
+	if err == nil {
+		rc.engine.cancelledAll()
+	}
+
+	return result, err
+}
@@ -0,0 +1,243 @@
+package bitstamp_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/b2broker/bitstamp"
+	"github.com/b2broker/bitstamp/bitstamptest"
+)
+
+func limitOrder(side bitstamp.OrderSide, price float64, amount float64) bitstamp.PlaceOrderRequest {
+	return bitstamp.PlaceOrderRequest{Symbol: "btcusd", Side: side, Type: bitstamp.Limit, Price: price, Amount: amount}
+}
+
+func TestRiskEngineRejections(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits bitstamp.RiskLimits
+		// book опорная цена по стакану bitstamptest
+		book bool
+		// setup готовит состояние до проверяемого ордера
+		setup func(t *testing.T, srv *bitstamptest.Server, risk *bitstamp.RiskEngine, client *bitstamp.RiskClient)
+		req   bitstamp.PlaceOrderRequest
+		// check ожидаемая проверка, пусто если ордер проходит
+		check bitstamp.RiskCheck
+	}{
+		{
+			name:   "within limits",
+			limits: bitstamp.RiskLimits{Default: bitstamp.SymbolLimits{MaxOrderAmount: 1, MaxOrderNotional: 1000}},
+			req:    limitOrder(bitstamp.Buy, 19000, 0.01),
+		},
+		{
+			name:   "fat finger",
+			limits: bitstamp.RiskLimits{Default: bitstamp.SymbolLimits{MaxOrderAmount: 0.1}},
+			req:    limitOrder(bitstamp.Buy, 19000, 0.2),
+			check:  bitstamp.RiskFatFinger,
+		},
+		{
+			name: "symbol limits replace default",
+			limits: bitstamp.RiskLimits{
+				Default: bitstamp.SymbolLimits{MaxOrderAmount: 1},
+				Symbols: map[string]bitstamp.SymbolLimits{"btcusd": {MaxOrderAmount: 0.01}},
+			},
+			req:   limitOrder(bitstamp.Buy, 19000, 0.02),
+			check: bitstamp.RiskFatFinger,
+		},
+		{
+			name:   "order notional",
+			limits: bitstamp.RiskLimits{Default: bitstamp.SymbolLimits{MaxOrderNotional: 1000}},
+			req:    limitOrder(bitstamp.Buy, 19000, 0.1),
+			check:  bitstamp.RiskOrderNotional,
+		},
+		{
+			name:   "market order without reference price",
+			limits: bitstamp.RiskLimits{Default: bitstamp.SymbolLimits{MaxOrderNotional: 1000}},
+			req:    bitstamp.PlaceOrderRequest{Symbol: "btcusd", Side: bitstamp.Buy, Type: bitstamp.Market, Amount: 0.01},
+			check:  bitstamp.RiskReferencePrice,
+		},
+		{
+			name:   "market order notional by last trade",
+			limits: bitstamp.RiskLimits{Default: bitstamp.SymbolLimits{MaxOrderNotional: 1000}},
+			setup: func(t *testing.T, srv *bitstamptest.Server, risk *bitstamp.RiskEngine, client *bitstamp.RiskClient) {
+				risk.HandleTrade(bitstamp.Trade{Symbol: "btcusd", Price: 20000, Amount: 0.1})
+			},
+			req:   bitstamp.PlaceOrderRequest{Symbol: "btcusd", Side: bitstamp.Buy, Type: bitstamp.Market, Amount: 0.1},
+			check: bitstamp.RiskOrderNotional,
+		},
+		{
+			name:   "price collar by last trade",
+			limits: bitstamp.RiskLimits{Default: bitstamp.SymbolLimits{PriceCollar: 0.05}},
+			setup: func(t *testing.T, srv *bitstamptest.Server, risk *bitstamp.RiskEngine, client *bitstamp.RiskClient) {
+				risk.HandleTrade(bitstamp.Trade{Symbol: "btcusd", Price: 20000, Amount: 0.1})
+			},
+			req:   limitOrder(bitstamp.Buy, 18000, 0.01),
+			check: bitstamp.RiskPriceCollar,
+		},
+		{
+			name:   "price collar by mid",
+			limits: bitstamp.RiskLimits{Default: bitstamp.SymbolLimits{PriceCollar: 0.05}, Reference: bitstamp.ReferenceMid},
+			book:   true,
+			setup: func(t *testing.T, srv *bitstamptest.Server, risk *bitstamp.RiskEngine, client *bitstamp.RiskClient) {
+				// последний трейд далеко от стакана и не должен учитываться
+				risk.HandleTrade(bitstamp.Trade{Symbol: "btcusd", Price: 19000, Amount: 0.1})
+				srv.AddLiquidity("btcusd", bitstamp.Buy, 21900, 1)
+				srv.AddLiquidity("btcusd", bitstamp.Sell, 22100, 1)
+			},
+			req:   limitOrder(bitstamp.Buy, 19500, 0.01),
+			check: bitstamp.RiskPriceCollar,
+		},
+		{
+			name:   "price collar without reference price",
+			limits: bitstamp.RiskLimits{Default: bitstamp.SymbolLimits{PriceCollar: 0.05}},
+			req:    limitOrder(bitstamp.Buy, 19000, 0.01),
+			check:  bitstamp.RiskReferencePrice,
+		},
+		{
+			name:   "order rate",
+			limits: bitstamp.RiskLimits{MaxOrdersPerMinute: 2},
+			setup: func(t *testing.T, srv *bitstamptest.Server, risk *bitstamp.RiskEngine, client *bitstamp.RiskClient) {
+				for i := 0; i < 2; i++ {
+					if _, err := client.PlaceOrder(limitOrder(bitstamp.Buy, 18000, 0.01)); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+			req:   limitOrder(bitstamp.Buy, 18000, 0.01),
+			check: bitstamp.RiskOrderRate,
+		},
+		{
+			name:   "open orders",
+			limits: bitstamp.RiskLimits{MaxOpenOrders: 1},
+			setup: func(t *testing.T, srv *bitstamptest.Server, risk *bitstamp.RiskEngine, client *bitstamp.RiskClient) {
+				if _, err := client.PlaceOrder(limitOrder(bitstamp.Buy, 18000, 0.01)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			req:   limitOrder(bitstamp.Buy, 18000, 0.01),
+			check: bitstamp.RiskOpenOrders,
+		},
+		{
+			name:   "open orders freed by cancel",
+			limits: bitstamp.RiskLimits{MaxOpenOrders: 1},
+			setup: func(t *testing.T, srv *bitstamptest.Server, risk *bitstamp.RiskEngine, client *bitstamp.RiskClient) {
+				result, err := client.PlaceOrder(limitOrder(bitstamp.Buy, 18000, 0.01))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := client.CancelOrder(strconv.FormatInt(result.ID, 10)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			req: limitOrder(bitstamp.Buy, 18000, 0.01),
+		},
+		{
+			name:   "position with resting orders",
+			limits: bitstamp.RiskLimits{Default: bitstamp.SymbolLimits{MaxPosition: 0.015}},
+			setup: func(t *testing.T, srv *bitstamptest.Server, risk *bitstamp.RiskEngine, client *bitstamp.RiskClient) {
+				if _, err := client.PlaceOrder(limitOrder(bitstamp.Buy, 18000, 0.01)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			req:   limitOrder(bitstamp.Buy, 18000, 0.01),
+			check: bitstamp.RiskPosition,
+		},
+		{
+			name:   "position with fills",
+			limits: bitstamp.RiskLimits{Default: bitstamp.SymbolLimits{MaxPosition: 0.015}},
+			setup: func(t *testing.T, srv *bitstamptest.Server, risk *bitstamp.RiskEngine, client *bitstamp.RiskClient) {
+				risk.HandleFill(bitstamp.Fill{TradeID: 1, Symbol: "btcusd", Side: string(bitstamp.Sell), Price: 20000, Size: 0.01})
+			},
+			req:   limitOrder(bitstamp.Sell, 21000, 0.01),
+			check: bitstamp.RiskPosition,
+		},
+		{
+			name:   "duplicated fill counted once",
+			limits: bitstamp.RiskLimits{Default: bitstamp.SymbolLimits{MaxPosition: 0.015}},
+			setup: func(t *testing.T, srv *bitstamptest.Server, risk *bitstamp.RiskEngine, client *bitstamp.RiskClient) {
+				fill := bitstamp.Fill{TradeID: 1, Symbol: "btcusd", Side: string(bitstamp.Sell), Price: 20000, Size: 0.01}
+
+				risk.HandleFill(fill)
+				risk.HandleFill(fill)
+			},
+			req: limitOrder(bitstamp.Sell, 21000, 0.004),
+		},
+		{
+			name:   "self trade",
+			limits: bitstamp.RiskLimits{SelfTradeGuard: true},
+			setup: func(t *testing.T, srv *bitstamptest.Server, risk *bitstamp.RiskEngine, client *bitstamp.RiskClient) {
+				if _, err := client.PlaceOrder(limitOrder(bitstamp.Sell, 20000, 0.01)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			req:   limitOrder(bitstamp.Buy, 20500, 0.01),
+			check: bitstamp.RiskSelfTrade,
+		},
+		{
+			name:   "no self trade below own ask",
+			limits: bitstamp.RiskLimits{SelfTradeGuard: true},
+			setup: func(t *testing.T, srv *bitstamptest.Server, risk *bitstamp.RiskEngine, client *bitstamp.RiskClient) {
+				if _, err := client.PlaceOrder(limitOrder(bitstamp.Sell, 20000, 0.01)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			req: limitOrder(bitstamp.Buy, 19500, 0.01),
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			srv := bitstamptest.NewServer("key", "secret")
+			defer srv.Close()
+
+			srv.SetBalance("usd", 100000)
+			srv.SetBalance("btc", 1)
+
+			opts := []bitstamp.RiskOption{bitstamp.WithRiskClock(time.Now)}
+			if tt.book {
+				opts = append(opts, bitstamp.WithRiskBook(bitstamp.NewPublicClient(bitstamp.WithPublicBaseURL(srv.URL))))
+			}
+
+			risk := bitstamp.NewRiskEngine(tt.limits, opts...)
+			client := bitstamp.NewRiskClient(srv.NewClient(), risk)
+
+			if tt.setup != nil {
+				tt.setup(t, srv, risk, client)
+			}
+
+			sent := len(srv.Orders())
+
+			_, err := client.PlaceOrder(tt.req)
+
+			if tt.check == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if got := len(srv.Orders()); got != sent+1 {
+					t.Fatalf("server has %d orders, want %d", got, sent+1)
+				}
+
+				return
+			}
+
+			var riskErr bitstamp.RiskError
+			if !errors.As(err, &riskErr) || !errors.Is(err, bitstamp.ErrRiskRejected) {
+				t.Fatalf("error = %v, want RiskError", err)
+			}
+
+			if riskErr.Check != tt.check {
+				t.Fatalf("check = %s, want %s (%v)", riskErr.Check, tt.check, err)
+			}
+
+			if got := len(srv.Orders()); got != sent {
+				t.Fatalf("rejected order reached the server: %d orders, want %d", got, sent)
+			}
+		})
+	}
+}
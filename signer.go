@@ -0,0 +1,138 @@
+package bitstamp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+var ErrSignerClosed = errors.New("signer closed")
+
+// Signer подписывает запросы к приватному API. Секрет может храниться вне процесса (KMS, HSM, сервис подписи)
+type Signer interface {
+	// Sign возвращает HMAC-SHA256 подпись сообщения
+	Sign(msg []byte) ([]byte, error)
+	APIKey() string
+}
+
+// HMACSigner хранит секрет в памяти процесса. Close затирает только собственную копию секрета:
+// строки, через которые секрет попал в процесс (аргумент, окружение, файл), остаются в памяти до сборки мусора
+type HMACSigner struct {
+	apiKey string
+	mu     sync.RWMutex
+	secret []byte
+}
+
+// NewHMACSigner создает Signer с секретом в памяти
+func NewHMACSigner(apiKey string, secretKey string) *HMACSigner {
+	return &HMACSigner{
+		apiKey: apiKey,
+		secret: []byte(secretKey),
+	}
+}
+
+func (s *HMACSigner) Sign(msg []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.secret == nil {
+		return nil, ErrSignerClosed
+	}
+
+	h := hmac.New(sha256.New, s.secret)
+	if _, err := h.Write(msg); err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+func (s *HMACSigner) APIKey() string {
+	return s.apiKey
+}
+
+// Close затирает копию секрета в HMACSigner, после этого Sign возвращает ErrSignerClosed
+func (s *HMACSigner) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.secret {
+		s.secret[i] = 0
+	}
+
+	s.secret = nil
+}
+
+// NewEnvSigner читает ключ и секрет из переменных окружения и удаляет секрет из окружения
+func NewEnvSigner(apiKeyVar string, secretKeyVar string) (*HMACSigner, error) {
+	apiKey := os.Getenv(apiKeyVar)
+	if apiKey == "" {
+		return nil, fmt.Errorf("%s isn't set", apiKeyVar)
+	}
+
+	secretKey := os.Getenv(secretKeyVar)
+	if secretKey == "" {
+		return nil, fmt.Errorf("%s isn't set", secretKeyVar)
+	}
+
+	if err := os.Unsetenv(secretKeyVar); err != nil {
+		return nil, err
+	}
+
+	return NewHMACSigner(apiKey, secretKey), nil
+}
+
+type credentialsFile struct {
+	APIKey    string `json:"api_key"`
+	SecretKey string `json:"secret_key"`
+}
+
+// NewFileSigner читает ключ и секрет из JSON файла вида {"api_key": "...", "secret_key": "..."}
+func NewFileSigner(path string) (*HMACSigner, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds credentialsFile
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("could not parse credentials file: %w", err)
+	}
+
+	creds.APIKey = strings.TrimSpace(creds.APIKey)
+	if creds.APIKey == "" || creds.SecretKey == "" {
+		return nil, fmt.Errorf("credentials file %s: api_key or secret_key is empty", path)
+	}
+
+	return NewHMACSigner(creds.APIKey, strings.TrimSpace(creds.SecretKey)), nil
+}
+
+// SignFunc подписывает сообщение вне процесса
+type SignFunc func(msg []byte) ([]byte, error)
+
+type remoteSigner struct {
+	apiKey string
+	sign   SignFunc
+}
+
+// NewRemoteSigner создает Signer, который делегирует подпись KMS, HSM или сервису подписи
+func NewRemoteSigner(apiKey string, sign SignFunc) Signer {
+	return remoteSigner{
+		apiKey: apiKey,
+		sign:   sign,
+	}
+}
+
+func (s remoteSigner) Sign(msg []byte) ([]byte, error) {
+	return s.sign(msg)
+}
+
+func (s remoteSigner) APIKey() string {
+	return s.apiKey
+}
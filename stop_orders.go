@@ -0,0 +1,668 @@
+package bitstamp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrStopNotFound стоп-ордера с таким ClientOrderID нет среди ожидающих
+var ErrStopNotFound = errors.New("stop order not found")
+
+// StopKind тип эмулируемого стоп-ордера
+type StopKind string
+
+const (
+	// StopMarket рыночный ордер при достижении TriggerPrice
+	StopMarket StopKind = "stop"
+	// StopLimit лимитный ордер по LimitPrice при достижении TriggerPrice
+	StopLimit StopKind = "stop_limit"
+	// TrailingStop рыночный ордер, когда цена откатывается от экстремума на TrailAmount или TrailPercent
+	TrailingStop StopKind = "trailing_stop"
+)
+
+// StopState состояние стоп-ордера
+type StopState string
+
+const (
+	// StopPending ожидает срабатывания
+	StopPending StopState = "pending"
+	// StopTriggered сработал, ордер выставляется. Bitstamp не проверяет уникальность ClientOrderID,
+	// поэтому Resume сначала ищет ордер по ClientOrderID и выставляет его, только если ордера нет
+	StopTriggered StopState = "triggered"
+)
+
+// StopOrder эмулируемый стоп-ордер. Продажа срабатывает при цене не выше TriggerPrice, покупка не ниже
+type StopOrder struct {
+	// ClientOrderID выставляемого ордера, он же идентификатор стоп-ордера
+	ClientOrderID string    `json:"client_order_id"`
+	Kind          StopKind  `json:"kind"`
+	Symbol        string    `json:"symbol"`
+	Side          OrderSide `json:"side"`
+	Amount        float64   `json:"amount"`
+	TriggerPrice  float64   `json:"trigger_price"`
+	// LimitPrice и ExecType для StopLimit
+	LimitPrice float64 `json:"limit_price,omitempty"`
+	ExecType   string  `json:"exec_type,omitempty"`
+	// TrailAmount и TrailPercent расстояние TrailingStop от экстремума, задается одно из них. 0.01 = 1%
+	TrailAmount  float64 `json:"trail_amount,omitempty"`
+	TrailPercent float64 `json:"trail_percent,omitempty"`
+	// Extreme максимум цены для продажи и минимум для покупки с момента создания TrailingStop
+	Extreme float64   `json:"extreme,omitempty"`
+	State   StopState `json:"state"`
+	// TriggeredAt время и цена срабатывания
+	TriggeredAt    time.Time `json:"triggered_at,omitempty"`
+	TriggeredPrice float64   `json:"triggered_price,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (s StopOrder) validate() error {
+	if s.ClientOrderID == "" {
+		return ErrNoClientOrderID
+	}
+
+	if s.Symbol == "" {
+		return fmt.Errorf("symbol isn't specified")
+	}
+
+	if s.Side != Buy && s.Side != Sell {
+		return ErrNoSide
+	}
+
+	if s.Amount <= 0 {
+		return fmt.Errorf("amount isn't specified")
+	}
+
+	switch s.Kind {
+	case StopMarket:
+	case StopLimit:
+		if s.LimitPrice <= 0 {
+			return fmt.Errorf("limit price isn't specified")
+		}
+	case TrailingStop:
+		if (s.TrailAmount > 0) == (s.TrailPercent > 0) {
+			return fmt.Errorf("exactly one of trail amount and trail percent must be specified")
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown stop kind %q", s.Kind)
+	}
+
+	if s.TriggerPrice <= 0 {
+		return fmt.Errorf("trigger price isn't specified")
+	}
+
+	return nil
+}
+
+// observe обновляет экстремум TrailingStop и проверяет срабатывание
+func (s *StopOrder) observe(price float64) (trailed bool, triggered bool) {
+	if s.Kind == TrailingStop {
+		if s.Extreme == 0 || s.Side == Sell && price > s.Extreme || s.Side == Buy && price < s.Extreme {
+			s.Extreme = price
+			trailed = true
+		}
+
+		distance := s.TrailAmount
+		if s.TrailPercent > 0 {
+			distance = s.Extreme * s.TrailPercent
+		}
+
+		if s.Side == Sell {
+			s.TriggerPrice = s.Extreme - distance
+		} else {
+			s.TriggerPrice = s.Extreme + distance
+		}
+	}
+
+	if s.Side == Sell {
+		return trailed, price <= s.TriggerPrice
+	}
+
+	return trailed, price >= s.TriggerPrice
+}
+
+func (s StopOrder) request() PlaceOrderRequest {
+	req := PlaceOrderRequest{
+		Amount:        s.Amount,
+		Symbol:        s.Symbol,
+		Side:          s.Side,
+		Type:          Market,
+		ClientOrderID: s.ClientOrderID,
+	}
+
+	if s.Kind == StopLimit {
+		req.Type = Limit
+		req.Price = s.LimitPrice
+		req.ExecType = s.ExecType
+	}
+
+	return req
+}
+
+// StopEventType тип события стоп-ордера
+type StopEventType string
+
+const (
+	StopEventAdded     StopEventType = "added"
+	StopEventCancelled StopEventType = "cancelled"
+	StopEventTriggered StopEventType = "triggered"
+	// StopEventPlaced ордер выставлен, Result ответ PlaceOrder
+	StopEventPlaced StopEventType = "placed"
+	// StopEventFailed ордер не выставлен, Err ошибка PlaceOrder. Стоп-ордер удаляется, если Bitstamp
+	// отклонил ордер. Если результат неизвестен, Stop остается в StopTriggered и проверяется Resume
+	StopEventFailed StopEventType = "failed"
+)
+
+// StopEvent изменение стоп-ордера
+type StopEvent struct {
+	Type   StopEventType
+	Stop   StopOrder
+	Result PlaceOrderResult
+	Err    error
+	At     time.Time
+}
+
+// StopStore хранилище ожидающих и сработавших стоп-ордеров
+type StopStore interface {
+	Load() ([]StopOrder, error)
+	Save(stops []StopOrder) error
+}
+
+// FileStopStore хранит стоп-ордера в JSON-файле. Файл заменяется атомарно через rename
+type FileStopStore struct {
+	path string
+}
+
+// NewFileStopStore создает хранилище в файле path
+func NewFileStopStore(path string) *FileStopStore {
+	return &FileStopStore{path: path}
+}
+
+// Load возвращает сохраненные стоп-ордера. Отсутствующий файл означает пустой список
+func (fs *FileStopStore) Load() ([]StopOrder, error) {
+	data, err := ioutil.ReadFile(fs.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var stops []StopOrder
+
+	if err := json.Unmarshal(data, &stops); err != nil {
+		return nil, fmt.Errorf("could not parse stop orders: %w", err)
+	}
+
+	return stops, nil
+}
+
+func (fs *FileStopStore) Save(stops []StopOrder) error {
+	data, err := json.Marshal(stops)
+	if err != nil {
+		return err
+	}
+
+	tmp := fs.path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, fs.path); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(fs.path))
+}
+
+// StopEngine эмулирует стоп-ордера: следит за ценой по HandleTrade или HandleOrderBook
+// и выставляет ордер через api, когда цена достигает триггера
+//
+//	stops, err := bitstamp.NewStopEngine(client, bitstamp.WithStopStore(bitstamp.NewFileStopStore("stops.json")))
+//	trades.OnTrade(stops.HandleTrade)
+//	stops.Resume()
+type StopEngine struct {
+	api            TradingAPI
+	store          StopStore
+	logger         Logger
+	now            func() time.Time
+	clientOrderIDs ClientOrderIDFunc
+
+	mu    sync.Mutex
+	stops map[string]*StopOrder
+	// placing стоп-ордера, ордер которых выставляется сейчас
+	placing map[string]struct{}
+
+	handlersMu sync.Mutex
+	handlers   []func(StopEvent)
+
+	wg sync.WaitGroup
+}
+
+// StopOption настройка StopEngine
+type StopOption func(*StopEngine)
+
+// WithStopStore задает хранилище стоп-ордеров. Без него стоп-ордера не переживают перезапуск
+func WithStopStore(store StopStore) StopOption {
+	return func(se *StopEngine) {
+		se.store = store
+	}
+}
+
+// WithStopLogger задает логгер
+func WithStopLogger(logger Logger) StopOption {
+	return func(se *StopEngine) {
+		se.logger = logger
+	}
+}
+
+// WithStopClock задает источник времени
+func WithStopClock(now func() time.Time) StopOption {
+	return func(se *StopEngine) {
+		se.now = now
+	}
+}
+
+// WithStopClientOrderIDs задает генератор ClientOrderID для стоп-ордеров без него
+func WithStopClientOrderIDs(generate ClientOrderIDFunc) StopOption {
+	return func(se *StopEngine) {
+		se.clientOrderIDs = generate
+	}
+}
+
+// NewStopEngine создает эмуляцию стоп-ордеров и загружает сохраненные стоп-ордера
+func NewStopEngine(api TradingAPI, opts ...StopOption) (*StopEngine, error) {
+	se := &StopEngine{
+		api:     api,
+		logger:  NewLogrusLogger(logrus.WithField("provider", "bitstamp").WithField("module", "stops")),
+		now:     time.Now,
+		stops:   make(map[string]*StopOrder),
+		placing: make(map[string]struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(se)
+	}
+
+	se.logger = newRedactingLogger(se.logger)
+
+	if se.store == nil {
+		return se, nil
+	}
+
+	stops, err := se.store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("could not load stop orders: %w", err)
+	}
+
+	for i := range stops {
+		stop := stops[i]
+		se.stops[stop.ClientOrderID] = &stop
+	}
+
+	return se, nil
+}
+
+// OnEvent добавляет обработчик событий стоп-ордеров. Вызывается в горутине источника цены
+// или выставления ордера, поэтому не должен блокироваться
+func (se *StopEngine) OnEvent(handler func(StopEvent)) {
+	se.handlersMu.Lock()
+	defer se.handlersMu.Unlock()
+
+	se.handlers = append(se.handlers, handler)
+}
+
+// Add добавляет стоп-ордер и сохраняет его. Пустой ClientOrderID заполняется генератором
+func (se *StopEngine) Add(stop StopOrder) (StopOrder, error) {
+	if stop.ClientOrderID == "" && se.clientOrderIDs != nil {
+		id, err := se.clientOrderIDs()
+		if err != nil {
+			return StopOrder{}, fmt.Errorf("could not generate client order id: %w", err)
+		}
+
+		stop.ClientOrderID = id
+	}
+
+	if err := stop.validate(); err != nil {
+		return StopOrder{}, err
+	}
+
+	stop.State = StopPending
+	stop.Extreme = 0
+	stop.CreatedAt = se.now()
+
+	if stop.Kind == TrailingStop {
+		stop.TriggerPrice = 0
+	}
+
+	se.mu.Lock()
+	if _, ok := se.stops[stop.ClientOrderID]; ok {
+		se.mu.Unlock()
+		return StopOrder{}, fmt.Errorf("%w: %s", ErrDuplicateClientOrderID, stop.ClientOrderID)
+	}
+
+	se.stops[stop.ClientOrderID] = &stop
+	err := se.save()
+	if err != nil {
+		delete(se.stops, stop.ClientOrderID)
+	}
+	se.mu.Unlock()
+
+	if err != nil {
+		return StopOrder{}, err
+	}
+
+	se.notify(StopEvent{Type: StopEventAdded, Stop: stop, At: se.now()})
+
+	return stop, nil
+}
+
+// Cancel удаляет ожидающий стоп-ордер. Сработавший стоп-ордер отменить нельзя
+func (se *StopEngine) Cancel(clientOrderID string) error {
+	se.mu.Lock()
+	stop, ok := se.stops[clientOrderID]
+	if !ok || stop.State != StopPending {
+		se.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrStopNotFound, clientOrderID)
+	}
+
+	delete(se.stops, clientOrderID)
+	err := se.save()
+	snapshot := *stop
+	se.mu.Unlock()
+
+	se.notify(StopEvent{Type: StopEventCancelled, Stop: snapshot, At: se.now()})
+
+	return err
+}
+
+// Stops возвращает ожидающие и сработавшие, но еще не выставленные стоп-ордера
+func (se *StopEngine) Stops() []StopOrder {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+
+	result := make([]StopOrder, 0, len(se.stops))
+	for _, stop := range se.stops {
+		result = append(result, *stop)
+	}
+
+	sort.Slice(result, func(a, b int) bool { return result[a].CreatedAt.Before(result[b].CreatedAt) })
+
+	return result
+}
+
+// HandleTrade проверяет триггеры по цене сделки. Подходит для TradeStream.OnTrade
+func (se *StopEngine) HandleTrade(trade Trade) {
+	se.observe(trade.Symbol, trade.Price, trade.Price)
+}
+
+// HandleOrderBook проверяет триггеры по стакану: продажа по лучшему биду, покупка по лучшему аску
+func (se *StopEngine) HandleOrderBook(symbol string, book OrderBookResult) {
+	var bid, ask float64
+
+	if len(book.Bids) > 0 {
+		bid = book.Bids[0].Price
+	}
+
+	if len(book.Asks) > 0 {
+		ask = book.Asks[0].Price
+	}
+
+	se.observe(symbol, bid, ask)
+}
+
+// observe проверяет стоп-ордера символа. Цена 0 означает отсутствие цены для стороны
+func (se *StopEngine) observe(symbol string, sellPrice float64, buyPrice float64) {
+	now := se.now()
+
+	se.mu.Lock()
+
+	var (
+		triggered []StopOrder
+		changed   bool
+	)
+
+	for _, stop := range se.stops {
+		if stop.Symbol != symbol || stop.State != StopPending {
+			continue
+		}
+
+		price := buyPrice
+		if stop.Side == Sell {
+			price = sellPrice
+		}
+
+		if price <= 0 {
+			continue
+		}
+
+		trailed, fire := stop.observe(price)
+		changed = changed || trailed
+
+		if !fire {
+			continue
+		}
+
+		stop.State = StopTriggered
+		stop.TriggeredAt = now
+		stop.TriggeredPrice = price
+		triggered = append(triggered, *stop)
+		changed = true
+	}
+
+	if changed {
+		if err := se.save(); err != nil {
+			se.logger.WithError(err).Error("could not save stop orders")
+		}
+	}
+	se.mu.Unlock()
+
+	for _, stop := range triggered {
+		se.logger.
+			WithField("client_order_id", stop.ClientOrderID).
+			WithField("price", stop.TriggeredPrice).
+			Info("stop order triggered")
+		se.notify(StopEvent{Type: StopEventTriggered, Stop: stop, At: now})
+		se.place(stop.ClientOrderID, false)
+	}
+}
+
+// Resume выставляет ордера сработавших стоп-ордеров: оставшихся с прошлого запуска и с неизвестным
+// результатом выставления. Ордер сначала ищется по ClientOrderID и выставляется, только если его нет.
+// Стоп-ордер, ордер которого выставляется сейчас, пропускается
+func (se *StopEngine) Resume() {
+	for _, stop := range se.Stops() {
+		if stop.State == StopTriggered {
+			se.place(stop.ClientOrderID, true)
+		}
+	}
+}
+
+// place выставляет ордер в отдельной горутине, чтобы не блокировать поток цен. retry означает,
+// что ордер мог быть выставлен раньше. Стоп-ордер удаляется, только если ордер выставлен или точно отклонен
+func (se *StopEngine) place(clientOrderID string, retry bool) {
+	se.mu.Lock()
+	// стоп-ордер мог быть выставлен и удален после снимка Stops
+	current, ok := se.stops[clientOrderID]
+	if !ok || current.State != StopTriggered {
+		se.mu.Unlock()
+		return
+	}
+
+	if _, ok := se.placing[clientOrderID]; ok {
+		se.mu.Unlock()
+		return
+	}
+
+	stop := *current
+	se.placing[clientOrderID] = struct{}{}
+	se.wg.Add(1)
+	se.mu.Unlock()
+
+	go func() {
+		defer se.wg.Done()
+
+		result, err := se.submit(stop, retry)
+		known := placeKnown(err)
+
+		se.mu.Lock()
+		delete(se.placing, stop.ClientOrderID)
+
+		if known {
+			delete(se.stops, stop.ClientOrderID)
+			if serr := se.save(); serr != nil {
+				se.logger.WithError(serr).Error("could not save stop orders")
+			}
+		}
+		se.mu.Unlock()
+
+		event := StopEvent{Type: StopEventPlaced, Stop: stop, Result: result, At: se.now()}
+
+		if err != nil {
+			logger := se.logger.WithError(err).WithField("client_order_id", stop.ClientOrderID)
+			if known {
+				logger.Error("could not place stop order")
+			} else {
+				logger.Error("stop order result is unknown, it will be checked by Resume")
+			}
+
+			event.Type = StopEventFailed
+			event.Err = err
+		}
+
+		se.notify(event)
+	}()
+}
+
+// submit выставляет ордер. При повторе и после ошибки сети или 5xx ордер ищется по client_order_id:
+// найденный ордер считается выставленным
+func (se *StopEngine) submit(stop StopOrder, retry bool) (PlaceOrderResult, error) {
+	req := stop.request()
+
+	if retry {
+		status, err := se.api.GetOrderStatusByClientOrderID(stop.ClientOrderID)
+		if err == nil {
+			return placedResult(req, status), nil
+		}
+
+		if !IsOrderNotFound(err) {
+			return PlaceOrderResult{}, stopError{err: fmt.Errorf("could not check stop order status: %w", err), retry: true}
+		}
+	}
+
+	result, err := se.api.PlaceOrder(req)
+
+	var serr stopError
+	if placeKnown(err) || errors.As(err, &serr) {
+		return result, err
+	}
+
+	status, cerr := se.api.GetOrderStatusByClientOrderID(stop.ClientOrderID)
+	if cerr != nil {
+		if !IsOrderNotFound(cerr) {
+			se.logger.WithError(cerr).WithField("client_order_id", stop.ClientOrderID).Warn("could not check stop order status")
+		}
+
+		return result, err
+	}
+
+	return placedResult(req, status), nil
+}
+
+// placedResult ответ PlaceOrder для ордера, найденного по client_order_id
+func placedResult(req PlaceOrderRequest, status OrderStatusResult) PlaceOrderResult {
+	return PlaceOrderResult{
+		ID:            status.ID,
+		Type:          sideType(req.Side),
+		Price:         req.Price,
+		Amount:        req.Amount,
+		ClientOrderID: req.ClientOrderID,
+	}
+}
+
+// stopError ошибка обертки api, которая сама решает судьбу стоп-ордера: retry оставляет его
+// сработавшим для Resume, иначе он удаляется. Статус по client_order_id после такой ошибки не проверяется
+type stopError struct {
+	err   error
+	retry bool
+}
+
+func (e stopError) Error() string {
+	return e.err.Error()
+}
+
+func (e stopError) Unwrap() error {
+	return e.err
+}
+
+// placeKnown результат выставления известен: ордер выставлен или точно не выставлен
+func placeKnown(err error) bool {
+	if err == nil {
+		return true
+	}
+
+	var serr stopError
+	if errors.As(err, &serr) {
+		return !serr.retry
+	}
+
+	return IsRejected(err)
+}
+
+// Close дожидается выставления сработавших стоп-ордеров
+func (se *StopEngine) Close() {
+	se.wg.Wait()
+}
+
+// save сохраняет стоп-ордера. Вызывается под se.mu
+func (se *StopEngine) save() error {
+	if se.store == nil {
+		return nil
+	}
+
+	stops := make([]StopOrder, 0, len(se.stops))
+	for _, stop := range se.stops {
+		stops = append(stops, *stop)
+	}
+
+	sort.Slice(stops, func(a, b int) bool { return stops[a].CreatedAt.Before(stops[b].CreatedAt) })
+
+	return se.store.Save(stops)
+}
+
+func (se *StopEngine) notify(event StopEvent) {
+	se.handlersMu.Lock()
+	handlers := se.handlers
+	se.handlersMu.Unlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
@@ -0,0 +1,267 @@
+package bitstamp_test
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/b2broker/bitstamp"
+	"github.com/b2broker/bitstamp/bitstamptest"
+)
+
+// stopEvents собирает события StopEngine
+type stopEvents struct {
+	mu     sync.Mutex
+	events []bitstamp.StopEvent
+}
+
+func (e *stopEvents) handle(event bitstamp.StopEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.events = append(e.events, event)
+}
+
+func (e *stopEvents) find(eventType bitstamp.StopEventType) (bitstamp.StopEvent, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i := len(e.events) - 1; i >= 0; i-- {
+		if e.events[i].Type == eventType {
+			return e.events[i], true
+		}
+	}
+
+	return bitstamp.StopEvent{}, false
+}
+
+// serverOrders ордера bitstamptest с client_order_id
+func serverOrders(srv *bitstamptest.Server, clientOrderID string) []bitstamptest.Order {
+	var orders []bitstamptest.Order
+
+	for _, o := range srv.Orders() {
+		if o.ClientOrderID == clientOrderID {
+			orders = append(orders, o)
+		}
+	}
+
+	return orders
+}
+
+func TestStopEngineTrailing(t *testing.T) {
+	tests := []struct {
+		name   string
+		stop   bitstamp.StopOrder
+		prices []float64
+		// triggered цена срабатывания, 0 если стоп не сработал
+		triggered float64
+		// extreme и trigger ожидаемые экстремум и цена триггера несработавшего стопа
+		extreme float64
+		trigger float64
+	}{
+		{
+			name:      "sell by amount",
+			stop:      bitstamp.StopOrder{Side: bitstamp.Sell, TrailAmount: 10},
+			prices:    []float64{100, 110, 105, 101, 100},
+			triggered: 100,
+		},
+		{
+			name:      "sell by percent",
+			stop:      bitstamp.StopOrder{Side: bitstamp.Sell, TrailPercent: 0.1},
+			prices:    []float64{100, 120, 110, 108},
+			triggered: 108,
+		},
+		{
+			name:      "buy by amount",
+			stop:      bitstamp.StopOrder{Side: bitstamp.Buy, TrailAmount: 5},
+			prices:    []float64{100, 90, 94, 96},
+			triggered: 96,
+		},
+		{
+			name:    "sell follows the high",
+			stop:    bitstamp.StopOrder{Side: bitstamp.Sell, TrailAmount: 10},
+			prices:  []float64{100, 95, 91, 105, 96},
+			extreme: 105,
+			trigger: 95,
+		},
+		{
+			name:    "buy follows the low",
+			stop:    bitstamp.StopOrder{Side: bitstamp.Buy, TrailPercent: 0.1},
+			prices:  []float64{100, 80, 87, 85},
+			extreme: 80,
+			trigger: 88,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			srv := bitstamptest.NewServer("key", "secret")
+			defer srv.Close()
+
+			srv.SetBalance("usd", 100000)
+			srv.SetBalance("btc", 1)
+
+			se, err := bitstamp.NewStopEngine(srv.NewClient())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			events := &stopEvents{}
+			se.OnEvent(events.handle)
+
+			stop := tt.stop
+			stop.ClientOrderID = "trail-1"
+			stop.Kind = bitstamp.TrailingStop
+			stop.Symbol = "btcusd"
+			stop.Amount = 0.01
+
+			if _, err := se.Add(stop); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, price := range tt.prices {
+				se.HandleTrade(bitstamp.Trade{Symbol: "btcusd", Price: price, Amount: 0.1})
+				// сделки другого символа не двигают экстремум
+				se.HandleTrade(bitstamp.Trade{Symbol: "ethusd", Price: price * 100, Amount: 0.1})
+			}
+
+			se.Close()
+
+			if tt.triggered == 0 {
+				stops := se.Stops()
+				if len(stops) != 1 || stops[0].State != bitstamp.StopPending {
+					t.Fatalf("stops = %+v, want one pending", stops)
+				}
+
+				if math.Abs(stops[0].Extreme-tt.extreme) > 1e-9 || math.Abs(stops[0].TriggerPrice-tt.trigger) > 1e-9 {
+					t.Fatalf("extreme %v trigger %v, want %v and %v", stops[0].Extreme, stops[0].TriggerPrice, tt.extreme, tt.trigger)
+				}
+
+				if got := len(serverOrders(srv, stop.ClientOrderID)); got != 0 {
+					t.Fatalf("%d orders placed before trigger", got)
+				}
+
+				return
+			}
+
+			triggered, ok := events.find(bitstamp.StopEventTriggered)
+			if !ok || triggered.Stop.TriggeredPrice != tt.triggered {
+				t.Fatalf("triggered event %+v, want price %v", triggered, tt.triggered)
+			}
+
+			if _, ok := events.find(bitstamp.StopEventPlaced); !ok {
+				t.Fatal("stop order isn't placed")
+			}
+
+			orders := serverOrders(srv, stop.ClientOrderID)
+			if len(orders) != 1 || orders[0].Side != tt.stop.Side || orders[0].Type != bitstamp.Market {
+				t.Fatalf("server orders %+v, want one %s market order", orders, tt.stop.Side)
+			}
+
+			if stops := se.Stops(); len(stops) != 0 {
+				t.Fatalf("stops after placement = %+v", stops)
+			}
+		})
+	}
+}
+
+func TestStopEngineResume(t *testing.T) {
+	tests := []struct {
+		name string
+		// lost запрос дошел до биржи, потерялся только ответ
+		lost bool
+		// statusFault статус по client_order_id недоступен во время Resume
+		statusFault bool
+		// orders ордеров с client_order_id стопа после Resume
+		orders    int
+		triggered bool
+	}{
+		{name: "order wasn't placed", orders: 1},
+		{name: "order was placed, response lost", lost: true, orders: 1},
+		{name: "status unknown", statusFault: true, triggered: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			srv := bitstamptest.NewServer("key", "secret")
+			defer srv.Close()
+
+			srv.SetBalance("btc", 1)
+
+			se, err := bitstamp.NewStopEngine(srv.NewClient())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			events := &stopEvents{}
+			se.OnEvent(events.handle)
+
+			stop, err := se.Add(bitstamp.StopOrder{
+				ClientOrderID: "stop-1",
+				Kind:          bitstamp.StopMarket,
+				Symbol:        "btcusd",
+				Side:          bitstamp.Sell,
+				Amount:        0.01,
+				TriggerPrice:  19000,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			srv.InjectFault(bitstamptest.Fault{Path: "/api/v2/sell/market/btcusd/", Status: http.StatusBadGateway, Code: "502", Reason: "Bad gateway"})
+
+			se.HandleTrade(bitstamp.Trade{Symbol: "btcusd", Price: 18990, Amount: 0.1})
+			se.Close()
+
+			if _, ok := events.find(bitstamp.StopEventFailed); !ok {
+				t.Fatal("expected failed event")
+			}
+
+			if stops := se.Stops(); len(stops) != 1 || stops[0].State != bitstamp.StopTriggered {
+				t.Fatalf("stops = %+v, want one triggered", stops)
+			}
+
+			if tt.lost {
+				req := bitstamp.PlaceOrderRequest{Symbol: "btcusd", Side: bitstamp.Sell, Type: bitstamp.Market, Amount: 0.01, ClientOrderID: stop.ClientOrderID}
+				if _, err := srv.NewClient().PlaceOrder(req); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.statusFault {
+				srv.InjectFault(bitstamptest.Fault{Path: "/api/v2/order_status/", Status: http.StatusInternalServerError, Code: "500", Reason: "Internal error"})
+			}
+
+			se.Resume()
+			se.Close()
+
+			orders := serverOrders(srv, stop.ClientOrderID)
+			if len(orders) != tt.orders {
+				t.Fatalf("server has %d orders %+v, want %d", len(orders), orders, tt.orders)
+			}
+
+			stops := se.Stops()
+			if tt.triggered {
+				if len(stops) != 1 || stops[0].State != bitstamp.StopTriggered {
+					t.Fatalf("stops = %+v, want one triggered", stops)
+				}
+
+				return
+			}
+
+			if len(stops) != 0 {
+				t.Fatalf("stops after Resume = %+v", stops)
+			}
+
+			placed, ok := events.find(bitstamp.StopEventPlaced)
+			if !ok || placed.Result.ID != orders[0].ID {
+				t.Fatalf("placed event %+v, want order %d", placed, orders[0].ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package bitstamp
+
+import "strings"
+
+// Span span трассировки
+type Span interface {
+	// End завершает span. status - статус или код ошибки Bitstamp
+	End(status string, err error)
+}
+
+// Tracer хуки трассировки. Реализация для OpenTelemetry: bitstampotel.New.
+// Один Tracer передается и в PrivateClient, и в Websocket, чтобы трейды связывались с ордерами по ClientOrderID
+type Tracer interface {
+	// StartRequest начинает span запроса к приватному REST API
+	StartRequest(endpoint string, symbol string, clientOrderID string) Span
+	// StartFill начинает span доставки трейда
+	StartFill(fill Fill) Span
+}
+
+type nopSpan struct{}
+
+func (nopSpan) End(string, error) {}
+
+// nopTracer используется, если трассировка не задана
+type nopTracer struct{}
+
+func (nopTracer) StartRequest(string, string, string) Span { return nopSpan{} }
+func (nopTracer) StartFill(Fill) Span                      { return nopSpan{} }
+
+// symbolFromPath достает пару из пути запроса: /api/v2/buy/market/btcusd/ -> btcusd
+func symbolFromPath(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) < 4 {
+		return ""
+	}
+
+	switch segments[2] {
+	case "buy", "sell", "user_transactions", "open_orders":
+	default:
+		return ""
+	}
+
+	last := segments[len(segments)-1]
+	if last == "market" || last == "all" {
+		return ""
+	}
+
+	return last
+}
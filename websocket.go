@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -16,27 +17,186 @@ var ErrWSClientStopped = errors.New("ws client stopped")
 
 var errDoReconnect = errors.New("reconnect")
 
+// doReconnect помечает ошибку как требующую переподключения, сохраняя причину
+func doReconnect(err error) error {
+	return fmt.Errorf("%w: %v", errDoReconnect, err)
+}
+
 // Websocket коннектор для Bitstamp для получение трейдов
 type Websocket struct {
-	symbols []string
-	fills   chan Fill
-	logger  *logrus.Entry
-	stopMu  sync.Mutex
-	stop    chan struct{}
-	wg      sync.WaitGroup
+	symbols   []string
+	url       string
+	fills     *fillQueue
+	histories map[string]*fillHistory
+	logger    Logger
+	metrics   Metrics
+	tracer    Tracer
+	observer  FrameObserver
+	stopMu    sync.Mutex
+	stop      chan struct{}
+	wg        sync.WaitGroup
+
+	fillsBuffer   int
+	fillsPolicy   OverflowPolicy
+	noFills       bool
+	subs          subscriptions
+	backoff       Backoff
+	states        chan WSStateEvent
+	state         int32
+	reconnects    uint64
+	lastMessageAt int64
+	// orderHandler получает события private-my_orders, если задан через WithOrderEvents
+	orderHandler func(OrderEvent)
 }
 
 const (
 	bitstampWS = "wss://ws.bitstamp.net/"
 )
 
+// WSOption настройка Websocket
+type WSOption func(*Websocket)
+
+// WithBackoff задает экспоненциальную задержку между переподключениями
+func WithBackoff(backoff Backoff) WSOption {
+	return func(ws *Websocket) {
+		ws.backoff = backoff
+	}
+}
+
+// WithFillsBuffer задает размер буфера канала Fills()
+func WithFillsBuffer(size int) WSOption {
+	return func(ws *Websocket) {
+		ws.fillsBuffer = size
+	}
+}
+
+// WithOverflowPolicy задает поведение при заполненном буфере Fills()
+func WithOverflowPolicy(policy OverflowPolicy) WSOption {
+	return func(ws *Websocket) {
+		ws.fillsPolicy = policy
+	}
+}
+
+// WithoutFillsChannel отключает общий канал Fills(), трейды доставляются только подписчикам
+// NewFillSubscription и OnFill. Fills() в этом случае возвращает закрытый канал
+func WithoutFillsChannel() WSOption {
+	return func(ws *Websocket) {
+		ws.noFills = true
+	}
+}
+
+// WithWSLogger задает логгер для Websocket и его соединений. Секреты вырезаются из всех сообщений
+func WithWSLogger(logger Logger) WSOption {
+	return func(ws *Websocket) {
+		ws.logger = logger
+	}
+}
+
+// WithWSMetrics задает хуки для сбора метрик Websocket и его соединений
+func WithWSMetrics(metrics Metrics) WSOption {
+	return func(ws *Websocket) {
+		ws.metrics = metrics
+	}
+}
+
+// WithWSTracer задает трассировку трейдов. Для связи с ордерами нужен тот же Tracer, что и у PrivateClient
+func WithWSTracer(tracer Tracer) WSOption {
+	return func(ws *Websocket) {
+		ws.tracer = tracer
+	}
+}
+
+// WithWSURL задает адрес WebSocket'a, например фейкового сервера из bitstamptest
+func WithWSURL(url string) WSOption {
+	return func(ws *Websocket) {
+		ws.url = url
+	}
+}
+
+// WithFrameObserver задает наблюдателя за соединениями и сырыми кадрами, например bitstamprec.Recorder
+func WithFrameObserver(observer FrameObserver) WSOption {
+	return func(ws *Websocket) {
+		ws.observer = observer
+	}
+}
+
+// WithStatesBuffer задает размер буфера канала States()
+func WithStatesBuffer(size int) WSOption {
+	return func(ws *Websocket) {
+		ws.states = make(chan WSStateEvent, size)
+	}
+}
+
 // NewWSClient Создает новый Websocket инстанс
 func NewWSClient(symbols ...string) *Websocket {
-	return &Websocket{
-		symbols: symbols,
-		fills:   make(chan Fill, 256),
-		logger:  logrus.WithField("provider", "bitstamp").WithField("module", "websocket"),
-		stop:    make(chan struct{}),
+	return NewWSClientWithOptions(symbols)
+}
+
+// NewWSClientWithOptions Создает новый Websocket инстанс с настройками
+func NewWSClientWithOptions(symbols []string, opts ...WSOption) *Websocket {
+	ws := &Websocket{
+		symbols:     symbols,
+		url:         bitstampWS,
+		histories:   make(map[string]*fillHistory),
+		logger:      NewLogrusLogger(logrus.WithField("provider", "bitstamp").WithField("module", "websocket")),
+		metrics:     nopMetrics{},
+		tracer:      nopTracer{},
+		stop:        make(chan struct{}),
+		fillsBuffer: defaultFillsBuffer,
+		states:      make(chan WSStateEvent, 64),
+		state:       int32(StateDisconnected),
+	}
+
+	for _, opt := range opts {
+		opt(ws)
+	}
+
+	ws.logger = newRedactingLogger(ws.logger)
+	ws.subs.logger = ws.logger
+	ws.fills = newFillQueue(ws.fillsBuffer, ws.fillsPolicy)
+	if ws.noFills {
+		ws.fills.close()
+	}
+
+	return ws
+}
+
+// States возвращает канал смены состояний подключения.
+// Если канал не вычитывается и буфер заполнен, события отбрасываются
+func (ws *Websocket) States() <-chan WSStateEvent {
+	return ws.states
+}
+
+// Stats возвращает текущее состояние, количество переподключений и время последнего сообщения
+func (ws *Websocket) Stats() WSStats {
+	stats := WSStats{
+		State:        WSState(atomic.LoadInt32(&ws.state)),
+		Reconnects:   atomic.LoadUint64(&ws.reconnects),
+		FillsDropped: ws.fills.droppedCount(),
+		FillsQueued:  ws.fills.queued(),
+	}
+
+	if last := atomic.LoadInt64(&ws.lastMessageAt); last > 0 {
+		stats.LastMessageAt = time.Unix(0, last)
+	}
+
+	return stats
+}
+
+func (ws *Websocket) setState(state WSState, attempt int, err error) {
+	atomic.StoreInt32(&ws.state, int32(state))
+
+	event := WSStateEvent{
+		State:   state,
+		Err:     err,
+		Attempt: attempt,
+		At:      time.Now(),
+	}
+
+	select {
+	case ws.states <- event:
+	default:
+		ws.logger.WithField("state", state).Warn("states channel is full, event dropped")
 	}
 }
 
@@ -45,39 +205,50 @@ func (ws *Websocket) subscribe(conn *WSConn, tokenData *GenerateWSTokenResult) e
 		return fmt.Errorf("no symbols to subscribe")
 	}
 
+	channels := []string{"private-my_trades"}
+	if ws.orderHandler != nil {
+		channels = append(channels, "private-my_orders")
+	}
+
 	for _, symbol := range ws.symbols {
-		msg := websocketMessage{
-			Event: "bts:subscribe",
-			Data: struct {
-				Channel string `json:"channel"`
-				Auth    string `json:"auth"`
-			}{
-				Channel: fmt.Sprintf("private-my_trades_%s-%v",
-					symbol,
-					tokenData.UserID,
-				),
-				Auth: tokenData.Token,
-			},
-		}
+		for _, channel := range channels {
+			msg := websocketMessage{
+				Event: "bts:subscribe",
+				Data: struct {
+					Channel string `json:"channel"`
+					Auth    string `json:"auth"`
+				}{
+					Channel: fmt.Sprintf("%s_%s-%v",
+						channel,
+						symbol,
+						tokenData.UserID,
+					),
+					Auth: tokenData.Token,
+				},
+			}
 
-		result, err := json.Marshal(msg)
-		if err != nil {
-			return err
-		}
+			result, err := json.Marshal(msg)
+			if err != nil {
+				return err
+			}
 
-		if err := conn.SendMessage(string(result)); err != nil {
-			return err
+			if err := conn.SendMessage(string(result)); err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
-// Run синхронная функция, которая подключается к Websocket'у, пересоздает connection в случае дисконекта
-func (ws *Websocket) Run(httpPrivateClient *PrivateClient, reconnectDelay time.Duration) error {
+// Run синхронная функция, которая подключается к Websocket'у, пересоздает connection в случае дисконекта.
+// tokens выдает токен для приватных каналов, обычно это PrivateClient.
+// reconnectDelay используется как начальная задержка, если она не задана через WithBackoff
+func (ws *Websocket) Run(tokens TokenProvider, reconnectDelay time.Duration) error {
 	ws.stopMu.Lock()
 	select {
 	case <-ws.stop:
+		ws.stopMu.Unlock()
 		return ErrWSClientStopped
 	default:
 		ws.wg.Add(1)
@@ -85,23 +256,52 @@ func (ws *Websocket) Run(httpPrivateClient *PrivateClient, reconnectDelay time.D
 	}
 	ws.stopMu.Unlock()
 
+	backoff := ws.backoff
+	if backoff.Initial == 0 {
+		backoff.Initial = reconnectDelay
+	}
+
+	attempt := 0
+
 	for {
-		if err := ws.run(httpPrivateClient); err != nil {
-			if !errors.Is(err, errDoReconnect) {
-				return err
-			}
+		ws.setState(StateConnecting, attempt, nil)
 
-			select {
-			case <-time.NewTimer(reconnectDelay).C:
-				continue
-			case <-ws.stop:
-				return ErrWSClientStopped
-			}
+		err := ws.run(tokens, func() { attempt = 0 })
+		if !errors.Is(err, errDoReconnect) {
+			ws.setState(StateStopped, attempt, err)
+			return err
+		}
+
+		ws.setState(StateDisconnected, attempt, err)
+
+		if backoff.MaxAttempts > 0 && attempt+1 >= backoff.MaxAttempts {
+			err = fmt.Errorf("%w: %v", ErrMaxReconnectAttempts, err)
+			ws.setState(StateStopped, attempt, err)
+			return err
+		}
+
+		delay := backoff.delay(attempt)
+		attempt++
+
+		ws.logger.WithField("attempt", attempt).WithField("delay", delay).Info("reconnecting")
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+			atomic.AddUint64(&ws.reconnects, 1)
+			ws.metrics.IncReconnect()
+			continue
+		case <-ws.stop:
+			timer.Stop()
+			ws.setState(StateStopped, attempt, ErrWSClientStopped)
+			return ErrWSClientStopped
 		}
 	}
 }
 
-// Stop останавливает клиент и дожидается пока все сообщения в очереди обработаются
+// Stop останавливает клиент и дожидается пока все сообщения в очереди обработаются.
+// После этого канал Fills() закрывается
 func (ws *Websocket) Stop() {
 	ws.stopMu.Lock()
 	select {
@@ -111,24 +311,29 @@ func (ws *Websocket) Stop() {
 	}
 	ws.stopMu.Unlock()
 	ws.wg.Wait()
+	ws.fills.close()
+	ws.subs.close()
 }
 
-func (ws *Websocket) run(httpPrivateClient *PrivateClient) error {
+// run подключается и читает сообщения до дисконекта. subscribed вызывается после успешной подписки
+func (ws *Websocket) run(tokens TokenProvider, subscribed func()) error {
 	ws.logger.Info("connecting")
 
-	// если connection не удался, то через reconnectDelay будет повторная попытка подключения
+	// если connection не удался, то через задержку будет повторная попытка подключения
 	conn, err := ws.connect()
 	if err != nil {
 		ws.logger.WithError(err).Error("connection to websocket failed")
-		return errDoReconnect
+		return doReconnect(err)
 	}
 
 	defer conn.Stop()
 
-	tokenData, err := httpPrivateClient.GenerateWSToken()
+	ws.setState(StateConnected, 0, nil)
+
+	tokenData, err := tokens.GenerateWSToken()
 	if err != nil {
 		ws.logger.WithError(err).Error("could not generate token")
-		return errDoReconnect
+		return doReconnect(err)
 	}
 
 	if err := ws.subscribe(conn, tokenData); err != nil {
@@ -137,15 +342,39 @@ func (ws *Websocket) run(httpPrivateClient *PrivateClient) error {
 
 	incoming := conn.RunReader(time.Second * 15)
 
+	// трейды, пришедшие по WebSocket'у во время восстановления, копятся в incoming
+	// и отправляются после восстановленных
+	if err := ws.recoverFills(tokens); err != nil {
+		if errors.Is(err, ErrFillsOverflow) {
+			return err
+		}
+
+		ws.logger.WithError(err).Error("could not recover missed fills")
+		return doReconnect(err)
+	}
+
+	ws.setState(StateSubscribed, 0, nil)
+	subscribed()
+
 	for {
 		select {
-		case msg := <-incoming:
-			ws.handleMessage(msg)
+		case msg, ok := <-incoming:
+			if !ok {
+				return doReconnect(errors.New("connection closed"))
+			}
+
+			atomic.StoreInt64(&ws.lastMessageAt, time.Now().UnixNano())
+
+			if err := ws.handleMessage(msg); err != nil {
+				return err
+			}
 		case <-ws.stop:
 			conn.Stop()
 
 			for msg := range incoming {
-				ws.handleMessage(msg)
+				if err := ws.handleMessage(msg); err != nil {
+					return err
+				}
 			}
 
 			return ErrWSClientStopped
@@ -158,53 +387,87 @@ func (ws *Websocket) connect() (*WSConn, error) {
 
 	dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint
 
-	conn, _, err := dialer.Dial(bitstampWS, nil)
+	conn, _, err := dialer.Dial(ws.url, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewWSConn(conn), nil
-}
+	if ws.observer != nil {
+		ws.observer.OnConnect(ws.url)
+	}
 
-func (ws *Websocket) handleMessage(msg []byte) {
-	ws.logger.WithField("body", string(msg)).Debug("got msg")
+	return NewWSConn(conn,
+		WithConnLogger(ws.logger.WithField("module", "wsconn")),
+		WithConnMetrics(ws.metrics),
+		WithConnFrameObserver(ws.observer),
+	), nil
+}
 
+// handleMessage разбирает сообщение. Ошибка возвращается, только если трейд не удалось доставить
+func (ws *Websocket) handleMessage(msg []byte) error {
 	var rawMsg bitstampFill
 	if err := json.Unmarshal(msg, &rawMsg); err != nil {
-		ws.logger.WithError(err).Error("could not unmarshal message")
-		return
+		ws.logger.WithError(err).WithField("size", len(msg)).Error("could not unmarshal message")
+		return nil
 	}
 
+	// тело не логируется: в нем id пользователя в канале, client_order_id и объемы
+	ws.logger.WithField("event", rawMsg.Event).WithField("size", len(msg)).Debug("got msg")
+
+	ws.metrics.IncMessage(rawMsg.Event)
+
 	switch rawMsg.Event {
 	case eventSubscription:
 		// Skip subscription confirmation events
-		return
+		return nil
 	case eventTrade:
 		parsedMsg, err := convertMessage(&rawMsg)
 		if err != nil {
 			ws.logger.WithError(err).Error("could not convert message")
-			return
+			return nil
 		}
-		ws.fills <- parsedMsg
+		return ws.emit(parsedMsg)
+	case string(OrderEventCreated), string(OrderEventChanged), string(OrderEventDeleted):
+		ws.handleOrderEvent(msg)
+		return nil
 	default:
 		ws.logger.WithField("event", rawMsg.Event).Warn("unknown event type")
+		return nil
 	}
 }
 
+// Liquidity сторона ликвидности трейда
+type Liquidity string
+
+const (
+	LiquidityUnknown Liquidity = ""
+	LiquidityMaker   Liquidity = "maker"
+	LiquidityTaker   Liquidity = "taker"
+)
+
 // Fill трейд, который получает клиент из библиотеки
 type Fill struct {
+	// OrderID собственный ордер: BuyOrderID для покупки, SellOrderID для продажи
 	OrderID       int64
+	BuyOrderID    int64
+	SellOrderID   int64
 	TradeID       int64
 	ClientOrderID string
 	Symbol        string
 	Price         float64
 	Size          float64
 	Fee           float64
+	FeeCurrency   string
 	Side          string
-	FilledAt      time.Time
+	// Liquidity оценка по id ордеров, Bitstamp не присылает сторону ликвидности в my_trades
+	Liquidity Liquidity
+	// FilledAt время трейда с точностью до микросекунд
+	FilledAt time.Time
+	// Recovered трейд был пропущен во время реконнекта и получен через REST
+	Recovered bool
 }
 
-// Fills возвращает канал
+// Fills возвращает канал трейдов. Канал закрывается после Stop()
 func (ws *Websocket) Fills() <-chan Fill {
-	return ws.fills
+	return ws.fills.ch
 }
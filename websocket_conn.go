@@ -1,6 +1,7 @@
 package bitstamp
 
 import (
+	"strconv"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -12,21 +13,62 @@ const (
 	PongWait      = time.Second * 20
 )
 
-var (
-	logger = logrus.WithField("service", "bitstamp").WithField("module", "wsconn")
-)
-
 // WSConn драйвер для получения OrderBook с бирж, работащих с WebSocket
 type WSConn struct {
 	conn     *websocket.Conn
 	readerCh chan []byte
+	logger   Logger
+	metrics  Metrics
+	observer FrameObserver
+}
+
+// FrameObserver получает сырые кадры WebSocket'a, например для записи трафика
+type FrameObserver interface {
+	// OnConnect вызывается после установки нового соединения
+	OnConnect(url string)
+	// OnFrame вызывается для каждого прочитанного кадра
+	OnFrame(frame []byte)
+}
+
+// WSConnOption настройка WSConn
+type WSConnOption func(*WSConn)
+
+// WithConnLogger задает логгер соединения. Секреты вырезаются из всех сообщений
+func WithConnLogger(logger Logger) WSConnOption {
+	return func(ws *WSConn) {
+		ws.logger = logger
+	}
+}
+
+// WithConnMetrics задает хуки для сбора метрик соединения
+func WithConnMetrics(metrics Metrics) WSConnOption {
+	return func(ws *WSConn) {
+		ws.metrics = metrics
+	}
+}
+
+// WithConnFrameObserver задает наблюдателя за прочитанными кадрами
+func WithConnFrameObserver(observer FrameObserver) WSConnOption {
+	return func(ws *WSConn) {
+		ws.observer = observer
+	}
 }
 
 // NewWSConn создает новый экземпляр *WebSocket
-func NewWSConn(conn *websocket.Conn) *WSConn {
-	return &WSConn{
-		conn: conn,
+func NewWSConn(conn *websocket.Conn, opts ...WSConnOption) *WSConn {
+	ws := &WSConn{
+		conn:    conn,
+		logger:  NewLogrusLogger(logrus.WithField("service", "bitstamp").WithField("module", "wsconn")),
+		metrics: nopMetrics{},
+	}
+
+	for _, opt := range opts {
+		opt(ws)
 	}
+
+	ws.logger = newRedactingLogger(ws.logger)
+
+	return ws
 }
 
 // keepalive отправляет ping сообщения, чтобы поддерживать websocket connection
@@ -36,10 +78,16 @@ func (ws *WSConn) keepalive(close chan struct{}) {
 	defer tk.Stop()
 
 	// если в ответ прислали pong, то обновляется deadline connection'a
+	// в ping передается время отправки, pong возвращает его обратно
 	ws.conn.SetPongHandler(func(appData string) error {
-		logger.Debug("got pong message")
+		ws.logger.Debug("got pong message")
+
+		if sentAt, err := strconv.ParseInt(appData, 10, 64); err == nil {
+			ws.metrics.ObservePingRTT(time.Since(time.Unix(0, sentAt)))
+		}
+
 		if err := ws.conn.SetReadDeadline(time.Now().Add(PongWait)); err != nil {
-			logger.WithError(err).Error("could not update read-readline")
+			ws.logger.WithError(err).Error("could not update read-readline")
 			return err
 		}
 		return nil
@@ -50,10 +98,11 @@ func (ws *WSConn) keepalive(close chan struct{}) {
 		case <-close:
 			return
 		case <-tk.C:
-			logger.Debug("sending ping")
-			err := ws.conn.WriteMessage(websocket.PingMessage, nil)
+			ws.logger.Debug("sending ping")
+			sentAt := strconv.FormatInt(time.Now().UnixNano(), 10)
+			err := ws.conn.WriteMessage(websocket.PingMessage, []byte(sentAt))
 			if err != nil {
-				logger.WithError(err).Error("could not send ping-message")
+				ws.logger.WithError(err).Error("could not send ping-message")
 				return
 			}
 		}
@@ -73,15 +122,19 @@ func (ws *WSConn) reader(timeout time.Duration) {
 
 	for {
 		if err := ws.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
-			logger.WithError(err).Error("could not set deadline for websocket")
+			ws.logger.WithError(err).Error("could not set deadline for websocket")
 			return
 		}
 		_, msg, err := ws.conn.ReadMessage()
 		if err != nil {
-			logger.WithError(err).Error("could not read from websocket")
+			ws.logger.WithError(err).Error("could not read from websocket")
 			return
 		}
 
+		if ws.observer != nil {
+			ws.observer.OnFrame(msg)
+		}
+
 		ws.readerCh <- msg
 	}
 }
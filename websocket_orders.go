@@ -0,0 +1,120 @@
+package bitstamp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// OrderEventType тип события канала private-my_orders
+type OrderEventType string
+
+const (
+	OrderEventCreated OrderEventType = "order_created"
+	OrderEventChanged OrderEventType = "order_changed"
+	OrderEventDeleted OrderEventType = "order_deleted"
+)
+
+// OrderEvent изменение собственного ордера
+type OrderEvent struct {
+	Type          OrderEventType
+	ID            int64
+	ClientOrderID string
+	Symbol        string
+	Side          OrderSide
+	Price         float64
+	// Amount неисполненный остаток ордера
+	Amount float64
+	At     time.Time
+}
+
+// bitstampOrderEvent событие канала private-my_orders.
+// {"data": {"id": 1551917787021312, "id_str": "1551917787021312", "order_type": 0, "datetime": "1667474425", "microtimestamp": "1667474425458123", "amount": 0.0002, "amount_str": "0.00020000", "price": 20512, "price_str": "20512", "client_order_id": "x-1"}, "channel": "private-my_orders_btcusd-12345", "event": "order_created"}
+type bitstampOrderEvent struct {
+	Channel string `json:"channel"`
+	Data    struct {
+		IDStr          json.Number `json:"id_str"`
+		ID             json.Number `json:"id"`
+		OrderType      int         `json:"order_type"`
+		Microtimestamp json.Number `json:"microtimestamp"`
+		AmountStr      json.Number `json:"amount_str"`
+		PriceStr       json.Number `json:"price_str"`
+		ClientOrderID  string      `json:"client_order_id"`
+	} `json:"data"`
+	Event string `json:"event"`
+}
+
+func convertOrderEvent(msg *bitstampOrderEvent) (OrderEvent, error) {
+	// channel: private-my_orders_btcusd-<user_id>
+	symbol := strings.Replace(msg.Channel, "private-my_orders_", "", 1)
+	if idx := strings.LastIndex(symbol, "-"); idx >= 0 {
+		symbol = symbol[:idx]
+	}
+
+	event := OrderEvent{
+		Type:          OrderEventType(msg.Event),
+		ClientOrderID: msg.Data.ClientOrderID,
+		Symbol:        symbol,
+		Side:          Buy,
+	}
+
+	if msg.Data.OrderType == OrderSideSell {
+		event.Side = Sell
+	}
+
+	// id_str точнее: id не помещается в float64 без потерь
+	id := msg.Data.IDStr
+	if id == "" {
+		id = msg.Data.ID
+	}
+
+	var err error
+
+	if event.ID, err = numberToInt(id); err != nil {
+		return OrderEvent{}, fmt.Errorf("id convertation error: %w", err)
+	}
+
+	if event.Amount, err = numberToFloat(msg.Data.AmountStr); err != nil {
+		return OrderEvent{}, fmt.Errorf("amount convertation error: %w", err)
+	}
+
+	if event.Price, err = numberToFloat(msg.Data.PriceStr); err != nil {
+		return OrderEvent{}, fmt.Errorf("price convertation error: %w", err)
+	}
+
+	micro, err := numberToInt(msg.Data.Microtimestamp)
+	if err != nil {
+		return OrderEvent{}, fmt.Errorf("microtimestamp convertation error: %w", err)
+	}
+
+	event.At = time.Unix(0, micro*int64(time.Microsecond)).UTC()
+
+	return event, nil
+}
+
+// WithOrderEvents подписывает Websocket на private-my_orders. handler вызывается в горутине чтения
+// для каждого события ордера, поэтому не должен блокироваться
+func WithOrderEvents(handler func(OrderEvent)) WSOption {
+	return func(ws *Websocket) {
+		ws.orderHandler = handler
+	}
+}
+
+func (ws *Websocket) handleOrderEvent(msg []byte) {
+	var raw bitstampOrderEvent
+	if err := json.Unmarshal(msg, &raw); err != nil {
+		ws.logger.WithError(err).Error("could not unmarshal order event")
+		return
+	}
+
+	event, err := convertOrderEvent(&raw)
+	if err != nil {
+		ws.logger.WithError(err).Error("could not convert order event")
+		return
+	}
+
+	if ws.orderHandler != nil {
+		ws.orderHandler(event)
+	}
+}
@@ -0,0 +1,353 @@
+package bitstamp
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// recentFillsLimit сколько последних трейдов по символу хранится для дедупликации
+const recentFillsLimit = 1024
+
+// recentTradesLimit сколько последних TradeID по всем символам хранится для отбрасывания повторов
+const recentTradesLimit = 16 * recentFillsLimit
+
+const transactionTimeLayout = "2006-01-02 15:04:05.999999"
+
+// fillKey ключ для сопоставления трейда из WebSocket'a и трейда из user_transactions,
+// у которых не совпадают идентификаторы
+type fillKey struct {
+	OrderID int64
+	Side    string
+	Price   float64
+	Size    float64
+}
+
+// seenFill трейд, учтенный fillDedup
+type seenFill struct {
+	tradeID int64
+	key     fillKey
+	source  int
+	// matched трейд сопоставлен с трейдом другого источника
+	matched bool
+}
+
+const (
+	fillSourceLive = iota
+	fillSourceRecovered
+)
+
+// fillDedup дедупликация трейдов из WebSocket'a и из user_transactions. Внутри источника трейды
+// сравниваются по TradeID. Трейд одного источника считается дубликатом, если трейд другого источника
+// с тем же fillKey еще не сопоставлен: одинаковые частичные исполнения ордера учитываются количеством
+type fillDedup struct {
+	// limit сколько трейдов хранится, 0 без ограничения
+	limit     int
+	ids       [2]map[int64]struct{}
+	unmatched [2]map[fillKey][]*seenFill
+	order     []*seenFill
+}
+
+func newFillDedup(limit int) *fillDedup {
+	d := &fillDedup{limit: limit}
+
+	for i := range d.ids {
+		d.ids[i] = make(map[int64]struct{})
+		d.unmatched[i] = make(map[fillKey][]*seenFill)
+	}
+
+	return d
+}
+
+// remember запоминает трейд, возвращает false если такой трейд уже был
+func (d *fillDedup) remember(fill Fill) bool {
+	source, other := fillSourceLive, fillSourceRecovered
+	if fill.Recovered {
+		source, other = other, source
+	}
+
+	if fill.TradeID != 0 {
+		if _, ok := d.ids[source][fill.TradeID]; ok {
+			return false
+		}
+
+		d.ids[source][fill.TradeID] = struct{}{}
+	}
+
+	seen := &seenFill{
+		tradeID: fill.TradeID,
+		key: fillKey{
+			OrderID: fill.OrderID,
+			Side:    fill.Side,
+			Price:   fill.Price,
+			Size:    fill.Size,
+		},
+		source: source,
+	}
+
+	if queue := d.unmatched[other][seen.key]; len(queue) > 0 {
+		queue[0].matched = true
+		d.unmatch(queue[0])
+
+		seen.matched = true
+		d.push(seen)
+
+		return false
+	}
+
+	d.unmatched[source][seen.key] = append(d.unmatched[source][seen.key], seen)
+	d.push(seen)
+
+	return true
+}
+
+// push добавляет трейд и вытесняет самый старый после limit
+func (d *fillDedup) push(seen *seenFill) {
+	d.order = append(d.order, seen)
+
+	if d.limit == 0 || len(d.order) <= d.limit {
+		return
+	}
+
+	oldest := d.order[0]
+	d.order[0] = nil
+	d.order = d.order[1:]
+
+	delete(d.ids[oldest.source], oldest.tradeID)
+
+	if !oldest.matched {
+		d.unmatch(oldest)
+	}
+}
+
+// unmatch убирает трейд из очереди несопоставленных
+func (d *fillDedup) unmatch(seen *seenFill) {
+	queue := d.unmatched[seen.source][seen.key]
+
+	for i, s := range queue {
+		if s != seen {
+			continue
+		}
+
+		queue = append(queue[:i], queue[i+1:]...)
+
+		break
+	}
+
+	if len(queue) == 0 {
+		delete(d.unmatched[seen.source], seen.key)
+		return
+	}
+
+	d.unmatched[seen.source][seen.key] = queue
+}
+
+// recentTrades последние TradeID для отбрасывания повторов. Хранит не больше limit id, самые старые вытесняются
+type recentTrades struct {
+	limit int
+	ids   map[int64]struct{}
+	order []int64
+}
+
+func newRecentTrades(limit int) *recentTrades {
+	return &recentTrades{
+		limit: limit,
+		ids:   make(map[int64]struct{}),
+	}
+}
+
+// remember запоминает id, возвращает false если такой id уже был. Нулевой id не запоминается
+func (r *recentTrades) remember(id int64) bool {
+	if id == 0 {
+		return true
+	}
+
+	if _, ok := r.ids[id]; ok {
+		return false
+	}
+
+	r.ids[id] = struct{}{}
+	r.order = append(r.order, id)
+
+	if len(r.order) > r.limit {
+		delete(r.ids, r.order[0])
+		r.order = r.order[1:]
+	}
+
+	return true
+}
+
+// fillHistory последний увиденный трейд и недавние трейды по символу
+type fillHistory struct {
+	lastTradeID int64
+	lastSeen    time.Time
+	dedup       *fillDedup
+}
+
+func newFillHistory() *fillHistory {
+	return &fillHistory{
+		dedup: newFillDedup(recentFillsLimit),
+	}
+}
+
+// remember запоминает трейд, возвращает false если такой трейд уже был
+func (fh *fillHistory) remember(fill Fill) bool {
+	if !fh.dedup.remember(fill) {
+		return false
+	}
+
+	if fill.FilledAt.After(fh.lastSeen) {
+		fh.lastSeen = fill.FilledAt
+		fh.lastTradeID = fill.TradeID
+	}
+
+	return true
+}
+
+// recoverFills запрашивает через REST трейды, пропущенные пока WebSocket был отключен,
+// и отправляет их в Fills() с пометкой Recovered. Если tokens не умеет отдавать транзакции, восстановление пропускается
+func (ws *Websocket) recoverFills(tokens TokenProvider) error {
+	source, ok := tokens.(transactionsSource)
+
+	for _, symbol := range ws.symbols {
+		history := ws.history(symbol)
+
+		// первое подключение: восстанавливать нечего, запоминаем момент подписки
+		if history.lastSeen.IsZero() {
+			history.lastSeen = time.Now()
+			continue
+		}
+
+		if !ok {
+			ws.logger.WithField("symbol", symbol).Warn("token provider has no transactions, missed fills are not recovered")
+			history.lastSeen = time.Now()
+			continue
+		}
+
+		transactions, err := source.GetPairTransactions(symbol, history.lastSeen)
+		if err != nil {
+			return fmt.Errorf("could not get transactions for %s: %w", symbol, err)
+		}
+
+		since := history.lastSeen
+
+		for _, transaction := range transactions {
+			if transaction.Type != TransactionTrade {
+				continue
+			}
+
+			fill, err := transactionToFill(symbol, transaction)
+			if err != nil {
+				ws.logger.WithError(err).WithField("id", transaction.ID).Error("could not convert transaction")
+				continue
+			}
+
+			if fill.FilledAt.Before(since) {
+				continue
+			}
+
+			ws.logger.WithField("trade", fill.TradeID).Info("recovered missed fill")
+
+			if err := ws.emit(fill); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (ws *Websocket) history(symbol string) *fillHistory {
+	history, ok := ws.histories[symbol]
+	if !ok {
+		history = newFillHistory()
+		ws.histories[symbol] = history
+	}
+
+	return history
+}
+
+// emit отправляет трейд в Fills(), пропуская дубликаты
+func (ws *Websocket) emit(fill Fill) error {
+	if !ws.history(fill.Symbol).remember(fill) {
+		ws.logger.WithField("trade", fill.TradeID).Debug("skip duplicate fill")
+		return nil
+	}
+
+	span := ws.tracer.StartFill(fill)
+
+	ws.subs.publish(fill)
+
+	dropped := ws.fills.droppedCount()
+	if err := ws.fills.push(fill); err != nil {
+		span.End("error", err)
+		return err
+	}
+
+	span.End("ok", nil)
+
+	if delta := ws.fills.droppedCount() - dropped; delta > 0 {
+		ws.metrics.AddFillsDropped(delta)
+	}
+
+	ws.metrics.IncFillsEmitted(fill.Symbol)
+	ws.metrics.SetFillsDepth(ws.fills.queued())
+
+	return nil
+}
+
+// transactionToFill конвертирует транзакцию типа trade из user_transactions в Fill.
+// Пример: {"id": 183814449, "order_id": 1373320601649153, "datetime": "2021-06-19 15:58:44.669000", "type": "2", "fee": "0.16277", "usd": "-32.55428700", "btc": "0.00090000", "btc_usd": 36171.43}
+func transactionToFill(symbol string, transaction TransactionResult) (Fill, error) {
+	var base string
+	var price float64
+
+	for key, value := range transaction.Amounts {
+		parts := strings.Split(key, "_")
+		if len(parts) == 2 && parts[0]+parts[1] == symbol {
+			base = parts[0]
+			price = value
+			break
+		}
+	}
+
+	if base == "" {
+		return Fill{}, fmt.Errorf("no price for %s", symbol)
+	}
+
+	amount := transaction.Amounts[base]
+
+	side := string(Buy)
+	if amount < 0 {
+		side = string(Sell)
+	}
+
+	filledAt, err := time.Parse(transactionTimeLayout, transaction.DateTime)
+	if err != nil {
+		return Fill{}, fmt.Errorf("datetime convertation error: %w", err)
+	}
+
+	fill := Fill{
+		OrderID:   transaction.OrderID,
+		TradeID:   transaction.ID,
+		Symbol:    symbol,
+		Price:     price,
+		Size:      math.Abs(amount),
+		Fee:       transaction.Fee,
+		Side:      side,
+		FilledAt:  filledAt,
+		Recovered: true,
+	}
+
+	// user_transactions не содержит встречный ордер и валюту комиссии
+	_, fill.FeeCurrency = SplitSymbol(symbol)
+
+	if side == string(Buy) {
+		fill.BuyOrderID = transaction.OrderID
+	} else {
+		fill.SellOrderID = transaction.OrderID
+	}
+
+	return fill, nil
+}
@@ -0,0 +1,117 @@
+package bitstamp
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+var ErrMaxReconnectAttempts = errors.New("max reconnect attempts exceeded")
+
+// WSState состояние подключения Websocket
+type WSState int
+
+const (
+	StateConnecting WSState = iota
+	StateConnected
+	StateSubscribed
+	StateDisconnected
+	StateStopped
+)
+
+func (s WSState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateSubscribed:
+		return "subscribed"
+	case StateDisconnected:
+		return "disconnected"
+	case StateStopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
+// WSStateEvent смена состояния подключения. Err содержит причину для Disconnected и Stopped
+type WSStateEvent struct {
+	State   WSState
+	Err     error
+	Attempt int
+	At      time.Time
+}
+
+// WSStats счетчики Websocket
+type WSStats struct {
+	State         WSState
+	Reconnects    uint64
+	LastMessageAt time.Time
+	// FillsDropped трейды, выброшенные политикой OverflowDropOldest
+	FillsDropped uint64
+	// FillsQueued трейды, ожидающие доставки в Fills()
+	FillsQueued int
+}
+
+// Backoff настройки задержки между переподключениями.
+// Задержка растет как Initial * Multiplier^attempt, но не больше Max,
+// и случайно отклоняется на долю Jitter в обе стороны.
+type Backoff struct {
+	// Initial начальная задержка, если 0 - используется reconnectDelay из Run
+	Initial time.Duration
+	// Max предел задержки, если 0 - defaultBackoffMax, но не меньше Initial
+	Max time.Duration
+	// Multiplier рост задержки, если 0 - defaultBackoffMultiplier. 1 - постоянная задержка
+	Multiplier float64
+	Jitter     float64
+	// MaxAttempts количество попыток подряд, после которого Run возвращает ErrMaxReconnectAttempts. 0 - без ограничений
+	MaxAttempts int
+}
+
+const (
+	defaultBackoffMultiplier = 2
+	defaultBackoffMax        = time.Minute
+)
+
+// delay возвращает задержку перед попыткой attempt (начиная с 0)
+func (b Backoff) delay(attempt int) time.Duration {
+	d := float64(b.Initial)
+
+	multiplier := b.Multiplier
+	if multiplier == 0 {
+		multiplier = defaultBackoffMultiplier
+	}
+
+	if multiplier > 1 {
+		d *= math.Pow(multiplier, float64(attempt))
+	}
+
+	limit := b.Max
+	if limit == 0 {
+		limit = defaultBackoffMax
+		if b.Initial > limit {
+			limit = b.Initial
+		}
+	}
+
+	if d > float64(limit) {
+		d = float64(limit)
+	}
+
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (rand.Float64()*2 - 1) //nolint:gosec
+	}
+
+	if d < 0 {
+		return 0
+	}
+
+	if d > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return time.Duration(d)
+}
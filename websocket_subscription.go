@@ -0,0 +1,138 @@
+package bitstamp
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// FillSubscription независимая подписка на трейды со своим буфером и политикой переполнения
+type FillSubscription struct {
+	subs  *subscriptions
+	queue *fillQueue
+	err   atomic.Value
+}
+
+// C возвращает канал трейдов подписки. Канал закрывается после Unsubscribe или Stop()
+func (s *FillSubscription) C() <-chan Fill {
+	return s.queue.ch
+}
+
+// Unsubscribe отменяет подписку и закрывает канал. Трейды, не доставленные подписчику, отбрасываются
+func (s *FillSubscription) Unsubscribe() {
+	s.subs.remove(s)
+	s.queue.abort()
+}
+
+// Dropped количество трейдов, выброшенных политикой OverflowDropOldest
+func (s *FillSubscription) Dropped() uint64 {
+	return s.queue.droppedCount()
+}
+
+// Err возвращает причину, по которой подписка была отменена библиотекой (ErrFillsOverflow для OverflowError)
+func (s *FillSubscription) Err() error {
+	if err, ok := s.err.Load().(error); ok {
+		return err
+	}
+
+	return nil
+}
+
+// subscriptions реестр подписчиков. emit читает снимок без блокировок,
+// поэтому добавление и отмена подписки не блокируют чтение из WebSocket'a
+type subscriptions struct {
+	mu       sync.Mutex
+	closed   bool
+	snapshot atomic.Value // []*FillSubscription
+	logger   Logger
+}
+
+func (ss *subscriptions) list() []*FillSubscription {
+	list, _ := ss.snapshot.Load().([]*FillSubscription)
+	return list
+}
+
+// NewFillSubscription создает подписку на все трейды.
+// Если клиент уже остановлен, возвращается подписка с закрытым каналом
+func (ws *Websocket) NewFillSubscription(buffer int, policy OverflowPolicy) *FillSubscription {
+	return ws.subs.add(buffer, policy)
+}
+
+// OnFill вызывает handler для каждого трейда в отдельной горутине.
+// Трейды копятся в неограниченной очереди, поэтому медленный handler не блокирует чтение
+func (ws *Websocket) OnFill(handler func(Fill)) *FillSubscription {
+	return ws.subs.onFill(handler)
+}
+
+func (ss *subscriptions) add(buffer int, policy OverflowPolicy) *FillSubscription {
+	sub := &FillSubscription{
+		subs:  ss,
+		queue: newFillQueue(buffer, policy),
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.closed {
+		sub.queue.close()
+		return sub
+	}
+
+	current := ss.list()
+	next := make([]*FillSubscription, 0, len(current)+1)
+	next = append(next, current...)
+	ss.snapshot.Store(append(next, sub))
+
+	return sub
+}
+
+func (ss *subscriptions) onFill(handler func(Fill)) *FillSubscription {
+	sub := ss.add(0, OverflowSpill)
+
+	go func() {
+		for fill := range sub.C() {
+			handler(fill)
+		}
+	}()
+
+	return sub
+}
+
+func (ss *subscriptions) remove(sub *FillSubscription) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	current := ss.list()
+	next := make([]*FillSubscription, 0, len(current))
+
+	for _, s := range current {
+		if s != sub {
+			next = append(next, s)
+		}
+	}
+
+	ss.snapshot.Store(next)
+}
+
+// publish доставляет трейд всем подписчикам. Подписка с OverflowError отменяется при переполнении
+func (ss *subscriptions) publish(fill Fill) {
+	for _, sub := range ss.list() {
+		if err := sub.queue.push(fill); err != nil {
+			ss.logger.WithError(err).Warn("fill subscription overflow, unsubscribing")
+			sub.err.Store(err)
+			sub.Unsubscribe()
+		}
+	}
+}
+
+// close закрывает каналы всех подписчиков после доставки оставшихся трейдов
+func (ss *subscriptions) close() {
+	ss.mu.Lock()
+	ss.closed = true
+	list := ss.list()
+	ss.snapshot.Store([]*FillSubscription(nil))
+	ss.mu.Unlock()
+
+	for _, sub := range list {
+		sub.queue.close()
+	}
+}
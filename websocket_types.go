@@ -1,8 +1,8 @@
 package bitstamp
 
 import (
+	"encoding/json"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -12,54 +12,157 @@ const (
 	eventSubscription = "bts:subscription_succeeded"
 )
 
+// bitstampFill событие канала private-my_trades. Числовые поля Bitstamp присылает как строками, так и числами.
+// Пример покупки:
+// {"data": {"id": 257950614, "amount": "0.00020000", "price": "20512", "microtimestamp": "1667474425458123", "fee": "0.02051", "fee_currency": "usd", "order_id": 1551917787021312, "buy_order_id": 1551917787021312, "sell_order_id": 1551917734531072, "client_order_id": "x-1", "side": "buy"}, "channel": "private-my_trades_btcusd-12345", "event": "trade"}
+// Пример продажи:
+// {"data": {"id": 257950710, "amount": "0.00020000", "price": "20515", "microtimestamp": "1667474439002517", "fee": "0.02052", "buy_order_id": 1551917851267072, "sell_order_id": 1551917790576640, "client_order_id": "", "side": "sell"}, "channel": "private-my_trades_btcusd-12345", "event": "trade"}
 type bitstampFill struct {
 	Channel string `json:"channel"`
 	Data    struct {
-		ID            int64  `json:"id"`
-		OrderID       int64  `json:"buy_order_id"`
-		ClientOrderID string `json:"client_order_id"`
-		Amount        string `json:"amount"`
-		Price         string `json:"price"`
-		Fee           string `json:"fee"`
-		Side          string `json:"Side"`
-		Timestamp     int64  `json:"microtimestamp,string"`
+		ID             json.Number `json:"id"`
+		OrderID        json.Number `json:"order_id"`
+		BuyOrderID     json.Number `json:"buy_order_id"`
+		SellOrderID    json.Number `json:"sell_order_id"`
+		ClientOrderID  string      `json:"client_order_id"`
+		Amount         json.Number `json:"amount"`
+		Price          json.Number `json:"price"`
+		Fee            json.Number `json:"fee"`
+		FeeCurrency    string      `json:"fee_currency"`
+		Side           string      `json:"side"`
+		Microtimestamp json.Number `json:"microtimestamp"`
 	} `json:"data"`
 	Event string `json:"event"`
 }
 
+// numberToInt пустое значение считается нулем
+func numberToInt(n json.Number) (int64, error) {
+	if n == "" {
+		return 0, nil
+	}
+
+	return n.Int64()
+}
+
+func numberToFloat(n json.Number) (float64, error) {
+	if n == "" {
+		return 0, nil
+	}
+
+	return n.Float64()
+}
+
+// quoteCurrencies известные котируемые валюты Bitstamp, длинные раньше коротких
+var quoteCurrencies = []string{"usdt", "usdc", "eur", "usd", "gbp", "btc", "eth"}
+
+// SplitSymbol делит пару на базовую и котируемую валюту: btcusdt -> btc, usdt
+func SplitSymbol(symbol string) (string, string) {
+	for _, quote := range quoteCurrencies {
+		if strings.HasSuffix(symbol, quote) && len(symbol) > len(quote) {
+			return strings.TrimSuffix(symbol, quote), quote
+		}
+	}
+
+	return symbol, ""
+}
+
+// liquidityByOrderIDs оценивает сторону ликвидности: ордер с большим id выставлен позже и, вероятно,
+// забрал ликвидность. my_trades не сообщает сторону ликвидности, поэтому результат эвристика:
+// id ордеров Bitstamp не гарантированно монотонны, а измененный ордер сохраняет старый id
+func liquidityByOrderIDs(own int64, counterparty int64) Liquidity {
+	switch {
+	case own == 0 || counterparty == 0:
+		return LiquidityUnknown
+	case own > counterparty:
+		return LiquidityTaker
+	default:
+		return LiquidityMaker
+	}
+}
+
 func convertMessage(fill *bitstampFill) (Fill, error) {
+	// channel: private-my_trades_btcusd-<user_id>
 	symbol := strings.Replace(fill.Channel, "private-my_trades_", "", 1)
-	createdAt := time.Unix(fill.Data.Timestamp/1000000, fill.Data.Timestamp%1000*1000000)
+	if idx := strings.LastIndex(symbol, "-"); idx >= 0 {
+		symbol = symbol[:idx]
+	}
 
 	if fill.Data.Side != string(Buy) && fill.Data.Side != string(Sell) {
 		return Fill{}, fmt.Errorf("not valid side: %s", fill.Data.Side)
 	}
 
-	amount, err := strconv.ParseFloat(fill.Data.Amount, 64)
+	microtimestamp, err := numberToInt(fill.Data.Microtimestamp)
+	if err != nil {
+		return Fill{}, fmt.Errorf("microtimestamp convertation error: %w", err)
+	}
+
+	tradeID, err := numberToInt(fill.Data.ID)
+	if err != nil {
+		return Fill{}, fmt.Errorf("id convertation error: %w", err)
+	}
+
+	orderID, err := numberToInt(fill.Data.OrderID)
+	if err != nil {
+		return Fill{}, fmt.Errorf("order_id convertation error: %w", err)
+	}
+
+	buyOrderID, err := numberToInt(fill.Data.BuyOrderID)
+	if err != nil {
+		return Fill{}, fmt.Errorf("buy_order_id convertation error: %w", err)
+	}
+
+	sellOrderID, err := numberToInt(fill.Data.SellOrderID)
+	if err != nil {
+		return Fill{}, fmt.Errorf("sell_order_id convertation error: %w", err)
+	}
+
+	amount, err := numberToFloat(fill.Data.Amount)
 	if err != nil {
 		return Fill{}, fmt.Errorf("amount convertation error: %w", err)
 	}
 
-	price, err := strconv.ParseFloat(fill.Data.Price, 64)
+	price, err := numberToFloat(fill.Data.Price)
 	if err != nil {
 		return Fill{}, fmt.Errorf("price convertation error: %w", err)
 	}
 
-	fee, err := strconv.ParseFloat(fill.Data.Fee, 64)
+	fee, err := numberToFloat(fill.Data.Fee)
 	if err != nil {
 		return Fill{}, fmt.Errorf("fee convertation error: %w", err)
 	}
 
+	// собственный ордер выбирается по стороне трейда, order_id приоритетнее
+	counterparty := sellOrderID
+	if fill.Data.Side == string(Sell) {
+		counterparty = buyOrderID
+	}
+
+	if orderID == 0 {
+		orderID = buyOrderID
+		if fill.Data.Side == string(Sell) {
+			orderID = sellOrderID
+		}
+	}
+
+	feeCurrency := strings.ToLower(fill.Data.FeeCurrency)
+	if feeCurrency == "" {
+		_, feeCurrency = SplitSymbol(symbol)
+	}
+
 	return Fill{
-		TradeID:       fill.Data.ID,
-		OrderID:       fill.Data.OrderID,
+		TradeID:       tradeID,
+		OrderID:       orderID,
+		BuyOrderID:    buyOrderID,
+		SellOrderID:   sellOrderID,
 		ClientOrderID: fill.Data.ClientOrderID,
 		Symbol:        symbol,
 		Price:         price,
 		Size:          amount,
 		Fee:           fee,
+		FeeCurrency:   feeCurrency,
 		Side:          fill.Data.Side,
-		FilledAt:      createdAt,
+		Liquidity:     liquidityByOrderIDs(orderID, counterparty),
+		FilledAt:      time.Unix(0, microtimestamp*int64(time.Microsecond)),
 	}, nil
 }
 
@@ -0,0 +1,126 @@
+package bitstamp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// recordedFrames читает кадры WebSocket'a из записи bitstamprec
+func recordedFrames(t *testing.T, path string) [][]byte {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	// первая строка заголовок записи
+	if !scanner.Scan() {
+		t.Fatalf("%s: empty recording", path)
+	}
+
+	var frames [][]byte
+
+	for scanner.Scan() {
+		var entry struct {
+			Kind  string `json:"kind"`
+			Frame string `json:"frame"`
+		}
+
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+
+		if entry.Kind == "ws_frame" {
+			frames = append(frames, []byte(entry.Frame))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return frames
+}
+
+// TestConvertMessageGolden разбирает события my_trades из записей bitstamprec в testdata/my_trades
+// и сравнивает трейды с файлами .golden
+func TestConvertMessageGolden(t *testing.T) {
+	recordings, err := filepath.Glob(filepath.Join("testdata", "my_trades", "*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recordings) == 0 {
+		t.Fatal("no recordings")
+	}
+
+	for _, recording := range recordings {
+		recording := recording
+		name := strings.TrimSuffix(filepath.Base(recording), ".jsonl")
+
+		t.Run(name, func(t *testing.T) {
+			var fills []Fill
+
+			for _, frame := range recordedFrames(t, recording) {
+				var message bitstampFill
+				if err := json.Unmarshal(frame, &message); err != nil {
+					t.Fatal(err)
+				}
+
+				if message.Event != eventTrade || !strings.HasPrefix(message.Channel, "private-my_trades_") {
+					continue
+				}
+
+				fill, err := convertMessage(&message)
+				if err != nil {
+					t.Fatalf("%s: %v", frame, err)
+				}
+
+				// golden не должен зависеть от часового пояса машины
+				fill.FilledAt = fill.FilledAt.UTC()
+				fills = append(fills, fill)
+			}
+
+			if len(fills) == 0 {
+				t.Fatal("no my_trades frames in recording")
+			}
+
+			got, err := json.MarshalIndent(fills, "", "\t")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got = append(got, '\n')
+			golden := strings.TrimSuffix(recording, ".jsonl") + ".golden"
+
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(got, want) {
+				t.Errorf("fills mismatch\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}